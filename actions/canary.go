@@ -0,0 +1,112 @@
+package actions
+
+import (
+	"github.com/danielkrainas/canaria-api/common"
+	"github.com/danielkrainas/canaria-api/context"
+	"github.com/danielkrainas/canaria-api/storage"
+)
+
+func CreateCanary(ctx context.Context, s storage.StorageDriver, q *DeliveryQueue, c *common.Canary) error {
+	if err := s.Canaries().Store(ctx, c, newRevision(ctx, c, common.RevisionCreated, "")); err != nil {
+		return err
+	}
+
+	return Dispatch(ctx, s.Hooks(), q, c, common.EventCreated)
+}
+
+// UpdateCanary refreshes the canary and stores it as long as nobody else has
+// used lastUpdateToken in the meantime.
+func UpdateCanary(ctx context.Context, s storage.StorageDriver, q *DeliveryQueue, c *common.Canary, lastUpdateToken string, changed bool) error {
+	if changed {
+		c.Revision++
+	}
+
+	action, event := common.RevisionRefreshed, common.EventRefreshed
+	if changed {
+		action, event = common.RevisionUpdated, common.EventUpdated
+	}
+
+	c.Refresh(lastUpdateToken)
+	if err := s.Canaries().Replace(ctx, c, lastUpdateToken, newRevision(ctx, c, action, lastUpdateToken)); err != nil {
+		return err
+	}
+
+	return Dispatch(ctx, s.Hooks(), q, c, event)
+}
+
+const maxMemberRetries = 3
+
+// ChangeMembers applies change to the canary's owner or collaborators and
+// stores it along with a revision recording the action and the member it
+// concerns. The update token is left as it is, so a refresh that gets in the
+// way only means applying the change again to the refreshed canary.
+func ChangeMembers(ctx context.Context, s storage.StorageDriver, c *common.Canary, action string, member string, change func(c *common.Canary) error) (*common.Canary, error) {
+	for i := 0; ; i++ {
+		if err := change(c); err != nil {
+			return nil, err
+		}
+
+		r := newRevision(ctx, c, action, "")
+		r.Member = member
+		err := s.Canaries().Replace(ctx, c, c.UpdateToken, r)
+		if err == nil {
+			return c, nil
+		} else if err != storage.ErrConflict || i == maxMemberRetries {
+			return nil, err
+		}
+
+		if c, err = s.Canaries().Get(ctx, c.ID); err != nil {
+			return nil, err
+		} else if c.IsDead() {
+			return nil, storage.ErrConflict
+		}
+	}
+}
+
+// UnpinCanary forgets the public key pinned by the canary's first verified
+// signature, so that it may be signed by another key or not at all. It is
+// recorded like any other change.
+func UnpinCanary(ctx context.Context, s storage.StorageDriver, c *common.Canary, lastUpdateToken string) error {
+	c.PublicKeyFingerprint = ""
+	c.Verified = false
+	return s.Canaries().Replace(ctx, c, lastUpdateToken, newRevision(ctx, c, common.RevisionUnpinned, lastUpdateToken))
+}
+
+// KillZombie kills an expired canary, announcing it as a zombie first.
+func KillZombie(ctx context.Context, s storage.StorageDriver, q *DeliveryQueue, c *common.Canary) error {
+	zombie := *c
+	return kill(ctx, s, q, c, &zombie)
+}
+
+func KillCanary(ctx context.Context, s storage.StorageDriver, q *DeliveryQueue, c *common.Canary) error {
+	return kill(ctx, s, q, c, nil)
+}
+
+func kill(ctx context.Context, s storage.StorageDriver, q *DeliveryQueue, c *common.Canary, zombie *common.Canary) error {
+	lastUpdateToken := c.UpdateToken
+	c.Kill()
+	if err := s.Canaries().Replace(ctx, c, lastUpdateToken, newRevision(ctx, c, common.RevisionKilled, lastUpdateToken)); err != nil {
+		return err
+	}
+
+	if zombie != nil {
+		if err := Dispatch(ctx, s.Hooks(), q, zombie, common.EventZombie); err != nil {
+			return err
+		}
+	}
+
+	if err := Dispatch(ctx, s.Hooks(), q, c, common.EventDead); err != nil {
+		return err
+	}
+
+	removed, err := s.Hooks().DeleteForCanary(ctx, c.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range removed {
+		context.GetLogger(ctx).Infof("hook removed: %s", id)
+	}
+
+	return nil
+}
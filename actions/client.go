@@ -0,0 +1,97 @@
+package actions
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/danielkrainas/canaria-api/common"
+)
+
+const (
+	hookDialTimeout    = 10 * time.Second
+	hookRequestTimeout = 30 * time.Second
+	hookMaxRedirects   = 5
+)
+
+var ErrInsecureSSLForbidden = errors.New("insecure_ssl is not allowed")
+
+// HookClient sends webhook requests within the outbound policy. The policy is
+// checked against the address actually being connected to, after name
+// resolution, so a host name cannot be re-pointed at a denied address between
+// validation and delivery.
+type HookClient struct {
+	policy   *common.HookPolicy
+	secure   *http.Client
+	insecure *http.Client
+}
+
+func NewHookClient(policy *common.HookPolicy) *HookClient {
+	return &HookClient{
+		policy:   policy,
+		secure:   newPolicyClient(policy, false),
+		insecure: newPolicyClient(policy, true),
+	}
+}
+
+func newPolicyClient(policy *common.HookPolicy, insecureSSL bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: hookDialTimeout,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			ip := net.ParseIP(host)
+			if ip == nil {
+				return fmt.Errorf("unexpected dial address %q", address)
+			}
+
+			return policy.CheckIP(ip)
+		},
+	}
+
+	transport := &http.Transport{
+		// proxies would be dialed instead of the target, defeating the policy.
+		Proxy:               nil,
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: hookDialTimeout,
+	}
+
+	if insecureSSL {
+		transport.TLSClientConfig = &tls.Config{
+			InsecureSkipVerify: true,
+		}
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   hookRequestTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= hookMaxRedirects {
+				return fmt.Errorf("stopped after %d redirects", hookMaxRedirects)
+			}
+
+			return policy.CheckScheme(req.URL.Scheme)
+		},
+	}
+}
+
+func (hc *HookClient) Do(req *http.Request, insecureSSL bool) (*http.Response, error) {
+	if err := hc.policy.CheckScheme(req.URL.Scheme); err != nil {
+		return nil, err
+	}
+
+	if !insecureSSL {
+		return hc.secure.Do(req)
+	} else if hc.policy != nil && hc.policy.ForbidInsecureSSL {
+		return nil, ErrInsecureSSLForbidden
+	}
+
+	return hc.insecure.Do(req)
+}
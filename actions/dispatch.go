@@ -0,0 +1,42 @@
+package actions
+
+import (
+	"github.com/danielkrainas/canaria-api/common"
+	"github.com/danielkrainas/canaria-api/context"
+	"github.com/danielkrainas/canaria-api/storage"
+)
+
+// Dispatch queues the event for every active hook of the canary that is
+// subscribed to it. All canary events go through here.
+func Dispatch(ctx context.Context, hs storage.HookStorage, q *DeliveryQueue, c *common.Canary, event string) error {
+	return dispatch(ctx, hs, q, c, nil, event)
+}
+
+func DispatchHookEvent(ctx context.Context, hs storage.HookStorage, q *DeliveryQueue, c *common.Canary, subject *common.WebHook, event string) error {
+	return dispatch(ctx, hs, q, c, subject, event)
+}
+
+func dispatch(ctx context.Context, hs storage.HookStorage, q *DeliveryQueue, c *common.Canary, subject *common.WebHook, event string) error {
+	hooks, err := hs.GetForCanary(ctx, c.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, wh := range hooks {
+		if !wh.Active || !wh.Subscribed(event) {
+			continue
+		}
+
+		d := common.NewDelivery(wh, c, event)
+		if subject != nil {
+			d.About(subject)
+		}
+
+		context.GetLogger(ctx).Infof("notifying %s of event %s", wh.ID, event)
+		if err := q.Enqueue(ctx, d); err != nil {
+			context.GetLogger(ctx).Errorf("error queueing %s event for hook %s: %v", event, wh.ID, err)
+		}
+	}
+
+	return nil
+}
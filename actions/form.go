@@ -0,0 +1,78 @@
+package actions
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"strconv"
+)
+
+const FormContentType = "application/x-www-form-urlencoded"
+
+// formEncode flattens the JSON representation of data into form values so
+// that form payloads carry exactly the same fields as JSON payloads:
+//
+//	{"action": "dead"}                   action=dead
+//	{"canary": {"id": "x"}}              canary[id]=x
+//	{"canary": {"labels": ["a", "b"]}}   canary[labels][]=a&canary[labels][]=b
+//	{"items": [{"id": "x"}]}             items[0][id]=x
+//
+// Numbers keep their JSON form, booleans become "true" or "false" and null
+// becomes an empty value. Empty objects and arrays are omitted.
+func formEncode(data interface{}) (url.Values, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	if fields, ok := v.(map[string]interface{}); ok {
+		for k, fv := range fields {
+			flattenFormValue(form, k, fv)
+		}
+	}
+
+	return form, nil
+}
+
+func flattenFormValue(form url.Values, key string, v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, fv := range v {
+			flattenFormValue(form, key+"["+k+"]", fv)
+		}
+
+	case []interface{}:
+		for i, item := range v {
+			switch item.(type) {
+			case map[string]interface{}, []interface{}:
+				flattenFormValue(form, key+"["+strconv.Itoa(i)+"]", item)
+			default:
+				flattenFormValue(form, key+"[]", item)
+			}
+		}
+
+	case json.Number:
+		form.Add(key, v.String())
+
+	case string:
+		form.Add(key, v)
+
+	case bool:
+		if v {
+			form.Add(key, "true")
+		} else {
+			form.Add(key, "false")
+		}
+
+	default:
+		form.Add(key, "")
+	}
+}
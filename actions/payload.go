@@ -0,0 +1,384 @@
+package actions
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/danielkrainas/canaria-api/common"
+)
+
+const (
+	JsonContentType        = "application/json; charset=utf-8"
+	CloudEventsContentType = "application/cloudevents+json; charset=utf-8"
+
+	// CloudEventTypePrefix is prepended to event names to form the CloudEvents
+	// type attribute, e.g. io.canaria.dead.
+	CloudEventTypePrefix = "io.canaria."
+
+	pagerDutySource = "canaria"
+
+	redactedValue = "[redacted]"
+)
+
+// validatePayload checks the parts of a webhook that decide what its payload
+// looks like.
+func validatePayload(wh *common.WebHook) error {
+	switch wh.ContentType {
+	case "", common.JsonContent, common.FormContent, common.SlackContent, common.DiscordContent, common.CloudEventsContent:
+	case common.PagerDutyContent:
+		if wh.Secret == "" {
+			return errors.New("pagerduty hooks need the integration's routing key as their secret")
+		}
+
+	case common.TemplateContent:
+		if wh.Template == "" {
+			return errors.New("template is required for the template content type")
+		}
+
+		_, err := parseTemplate(wh.Template)
+		return err
+
+	default:
+		return fmt.Errorf("unsupported content type: %q", wh.ContentType)
+	}
+
+	if wh.Template != "" {
+		return errors.New("template is only used with the template content type")
+	}
+
+	return nil
+}
+
+// buildPayload renders the delivery in the hook's content type.
+func buildPayload(d *common.Delivery) ([]byte, string, error) {
+	n := d.Notification()
+	switch d.Hook.ContentType {
+	case common.FormContent:
+		form, err := formEncode(n)
+		if err != nil {
+			return nil, "", err
+		}
+
+		return []byte(form.Encode()), FormContentType, nil
+
+	case common.TemplateContent:
+		return templatePayload(d)
+
+	case common.SlackContent:
+		body, err := json.Marshal(slackPayload(d))
+		return body, JsonContentType, err
+
+	case common.DiscordContent:
+		body, err := json.Marshal(discordPayload(d))
+		return body, JsonContentType, err
+
+	case common.PagerDutyContent:
+		body, err := json.Marshal(pagerDutyPayload(d))
+		return body, JsonContentType, err
+
+	case common.CloudEventsContent:
+		body, err := json.Marshal(cloudEventPayload(d))
+		return body, CloudEventsContentType, err
+	}
+
+	body, err := json.Marshal(n)
+	return body, JsonContentType, err
+}
+
+// recordedPayload is the body as it is kept in the delivery history, which is
+// served back to anyone allowed to read the canary. The routing key of
+// pagerduty hooks is the hook's secret and never makes it there.
+func recordedPayload(d *common.Delivery, body []byte) string {
+	if d.Hook.ContentType != common.PagerDutyContent || d.Hook.Secret == "" {
+		return string(body)
+	}
+
+	key, _ := json.Marshal(d.Hook.Secret)
+	redacted, _ := json.Marshal(redactedValue)
+	field := []byte(`"routing_key":`)
+	return string(bytes.Replace(body, append(field, key...), append(field, redacted...), 1))
+}
+
+// templatePayload renders the hook's template. The output is sent as JSON
+// when it is valid JSON and as plain text otherwise.
+func templatePayload(d *common.Delivery) ([]byte, string, error) {
+	t, err := parseTemplate(d.Hook.Template)
+	if err != nil {
+		return nil, "", err
+	}
+
+	data, err := templateData(d.Notification())
+	if err != nil {
+		return nil, "", err
+	}
+
+	if fields, ok := data.(map[string]interface{}); ok {
+		fields["delivery"] = d.ID
+		if d.Canary != nil {
+			fields["state"] = d.Canary.State()
+		}
+	}
+
+	body, err := renderTemplate(t, data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if json.Valid(body) {
+		return body, JsonContentType, nil
+	}
+
+	return body, "text/plain; charset=utf-8", nil
+}
+
+func canaryName(c *common.Canary) string {
+	if c == nil {
+		return ""
+	} else if c.Title != "" {
+		return fmt.Sprintf("%q (%s)", c.Title, c.ID)
+	}
+
+	return c.ID
+}
+
+// eventSummary is a one line, human readable description of the event used by
+// the chat and incident presets.
+func eventSummary(d *common.Delivery) string {
+	name := canaryName(d.Canary)
+	hook := ""
+	if d.Subject != nil {
+		hook = d.Subject.ID
+	}
+
+	switch d.Event {
+	case common.EventCreated:
+		return fmt.Sprintf("Canary %s was created", name)
+	case common.EventRefreshed:
+		return fmt.Sprintf("Canary %s was refreshed", name)
+	case common.EventUpdated:
+		return fmt.Sprintf("Canary %s was updated", name)
+	case common.EventExpiring:
+		if d.Canary != nil {
+			return fmt.Sprintf("Canary %s expires at %s", name, time.Unix(d.Canary.ExpiresAt(), 0).UTC().Format(time.RFC1123))
+		}
+
+		return fmt.Sprintf("Canary %s is about to expire", name)
+	case common.EventZombie:
+		return fmt.Sprintf("Canary %s missed its deadline", name)
+	case common.EventDead:
+		return fmt.Sprintf("Canary %s is dead", name)
+	case common.EventHookCreated:
+		return fmt.Sprintf("Hook %s was added to canary %s", hook, name)
+	case common.EventHookUpdated:
+		return fmt.Sprintf("Hook %s of canary %s was updated", hook, name)
+	case common.EventHookDeleted:
+		return fmt.Sprintf("Hook %s was removed from canary %s", hook, name)
+	case common.EventHookDisabled:
+		return fmt.Sprintf("Hook %s of canary %s was disabled after failing", hook, name)
+	case common.EventPing:
+		return fmt.Sprintf("Ping from canary %s", name)
+	}
+
+	return fmt.Sprintf("Canary %s: %s", name, d.Event)
+}
+
+// eventSeverity ranks events for presets that color or prioritize them.
+func eventSeverity(event string) string {
+	switch event {
+	case common.EventDead:
+		return "critical"
+	case common.EventZombie:
+		return "error"
+	case common.EventExpiring, common.EventHookDisabled:
+		return "warning"
+	}
+
+	return "info"
+}
+
+var severityColors = map[string]int{
+	"critical": 0xd50200,
+	"error":    0xe8590c,
+	"warning":  0xf2c744,
+	"info":     0x2eb886,
+}
+
+type slackMessage struct {
+	Text        string             `json:"text"`
+	Attachments []*slackAttachment `json:"attachments,omitempty"`
+}
+
+type slackAttachment struct {
+	Fallback string        `json:"fallback"`
+	Color    string        `json:"color"`
+	Text     string        `json:"text,omitempty"`
+	Fields   []*slackField `json:"fields,omitempty"`
+	Ts       int64         `json:"ts"`
+}
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+func slackPayload(d *common.Delivery) *slackMessage {
+	summary := eventSummary(d)
+	attachment := &slackAttachment{
+		Fallback: summary,
+		Color:    fmt.Sprintf("#%06x", severityColors[eventSeverity(d.Event)]),
+		Ts:       time.Now().Unix(),
+		Fields: []*slackField{
+			{Title: "Event", Value: d.Event, Short: true},
+		},
+	}
+
+	if c := d.Canary; c != nil {
+		attachment.Text = c.Message
+		attachment.Fields = append(attachment.Fields, &slackField{Title: "State", Value: c.State(), Short: true})
+	}
+
+	return &slackMessage{
+		Text:        summary,
+		Attachments: []*slackAttachment{attachment},
+	}
+}
+
+type discordMessage struct {
+	Content string          `json:"content"`
+	Embeds  []*discordEmbed `json:"embeds,omitempty"`
+}
+
+type discordEmbed struct {
+	Title       string          `json:"title"`
+	Description string          `json:"description,omitempty"`
+	Color       int             `json:"color"`
+	Timestamp   string          `json:"timestamp"`
+	Fields      []*discordField `json:"fields,omitempty"`
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+func discordPayload(d *common.Delivery) *discordMessage {
+	summary := eventSummary(d)
+	embed := &discordEmbed{
+		Title:     summary,
+		Color:     severityColors[eventSeverity(d.Event)],
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Fields: []*discordField{
+			{Name: "Event", Value: d.Event, Inline: true},
+		},
+	}
+
+	if c := d.Canary; c != nil {
+		embed.Description = c.Message
+		embed.Fields = append(embed.Fields, &discordField{Name: "State", Value: c.State(), Inline: true})
+	}
+
+	return &discordMessage{
+		Content: summary,
+		Embeds:  []*discordEmbed{embed},
+	}
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyDetails `json:"payload,omitempty"`
+}
+
+type pagerDutyDetails struct {
+	Summary       string                      `json:"summary"`
+	Source        string                      `json:"source"`
+	Severity      string                      `json:"severity"`
+	Timestamp     string                      `json:"timestamp"`
+	Component     string                      `json:"component,omitempty"`
+	Class         string                      `json:"class"`
+	CustomDetails *common.WebHookNotification `json:"custom_details"`
+}
+
+// pagerDutyPayload maps events onto PagerDuty Events API v2 incidents. A
+// canary that expires, goes zombie or dies triggers an incident keyed on the
+// canary, which it resolves again once it is refreshed or updated.
+// Disabled hooks open their own incident that is resolved when the hook is
+// updated. The hook's secret is the integration's routing key.
+func pagerDutyPayload(d *common.Delivery) *pagerDutyEvent {
+	e := &pagerDutyEvent{
+		RoutingKey: d.Hook.Secret,
+		DedupKey:   d.CanaryID,
+	}
+
+	if d.Subject != nil {
+		switch d.Event {
+		case common.EventHookCreated, common.EventHookUpdated, common.EventHookDeleted, common.EventHookDisabled:
+			e.DedupKey = d.CanaryID + "/hooks/" + d.Subject.ID
+		}
+	}
+
+	switch d.Event {
+	case common.EventExpiring, common.EventZombie, common.EventDead, common.EventHookDisabled:
+		e.EventAction = "trigger"
+
+	case common.EventPing:
+		e.EventAction = "trigger"
+		e.DedupKey = d.ID
+
+	default:
+		e.EventAction = "resolve"
+		return e
+	}
+
+	e.Payload = &pagerDutyDetails{
+		Summary:       eventSummary(d),
+		Source:        pagerDutySource,
+		Severity:      eventSeverity(d.Event),
+		Timestamp:     time.Now().UTC().Format(time.RFC3339),
+		Class:         d.Event,
+		CustomDetails: d.Notification(),
+	}
+
+	if d.Canary != nil {
+		e.Payload.Component = d.Canary.ID
+	}
+
+	return e
+}
+
+type cloudEvent struct {
+	SpecVersion     string                      `json:"specversion"`
+	Type            string                      `json:"type"`
+	Source          string                      `json:"source"`
+	ID              string                      `json:"id"`
+	Time            string                      `json:"time"`
+	Subject         string                      `json:"subject,omitempty"`
+	DataContentType string                      `json:"datacontenttype"`
+	Data            *common.WebHookNotification `json:"data"`
+}
+
+// cloudEventPayload is a CloudEvents 1.0 event in structured mode. Redeliveries
+// keep the id of the original delivery so receivers can recognize duplicates.
+func cloudEventPayload(d *common.Delivery) *cloudEvent {
+	id := d.ID
+	if d.RedeliveryOf != "" {
+		id = d.RedeliveryOf
+	}
+
+	return &cloudEvent{
+		SpecVersion:     "1.0",
+		Type:            CloudEventTypePrefix + d.Event,
+		Source:          "/v1/canary/" + d.CanaryID,
+		ID:              id,
+		Time:            time.Unix(d.CreatedAt, 0).UTC().Format(time.RFC3339),
+		Subject:         d.CanaryID,
+		DataContentType: "application/json",
+		Data:            d.Notification(),
+	}
+}
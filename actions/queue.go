@@ -0,0 +1,557 @@
+package actions
+
+import (
+	"errors"
+	"math/rand"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielkrainas/canaria-api/common"
+	"github.com/danielkrainas/canaria-api/configuration"
+	"github.com/danielkrainas/canaria-api/context"
+	"github.com/danielkrainas/canaria-api/notifier"
+	"github.com/danielkrainas/canaria-api/storage"
+)
+
+var ErrHookInactive = errors.New("hook is inactive")
+
+const (
+	defaultMaxAttempts = 10
+	defaultBackoff     = 5 * time.Second
+	defaultMaxBackoff  = time.Hour
+	defaultInterval    = 5 * time.Second
+
+	defaultFailureThreshold = 20
+
+	defaultWorkers      = 4
+	defaultPerHostLimit = 2
+	defaultQueueSize    = 100
+
+	pruneInterval     = time.Minute
+	drainPollInterval = 100 * time.Millisecond
+)
+
+type DeliveryQueue struct {
+	context.Context
+
+	storage      storage.StorageDriver
+	deliveries   storage.DeliveryStorage
+	notifiers    notifier.Set
+	maxAttempts  int
+	threshold    int
+	backoff      time.Duration
+	maxBackoff   time.Duration
+	interval     time.Duration
+	workers      int
+	perHostLimit int
+	lastPrune    time.Time
+
+	// jobs carries due deliveries from the scheduler to the workers. Every
+	// delivery in it or being attempted is claimed, along with a slot of its
+	// destination host.
+	jobs    chan *common.Delivery
+	mu      sync.Mutex
+	claimed map[string]string
+	hosts   map[string]int
+	stats   QueueStats
+
+	// hookLocks serializes the circuit breaker updates of each hook between
+	// workers delivering to it at the same time.
+	hookLocks map[string]*hookLock
+
+	cancel   context.CancelFunc
+	wake     chan struct{}
+	quit     chan struct{}
+	done     chan struct{}
+	workerWg sync.WaitGroup
+	stopOnce sync.Once
+}
+
+// QueueStats describes the load on a delivery queue. Deferred and HostLimited
+// count, per scheduling pass, due deliveries that had to be left pending
+// because the queue was full or their destination host was at its limit. A
+// steady rise in either is the first sign of a backlog.
+type QueueStats struct {
+	Workers     int    `json:"workers"`
+	Capacity    int    `json:"capacity"`
+	Queued      int    `json:"queued"`
+	InFlight    int    `json:"in_flight"`
+	Hosts       int    `json:"hosts"`
+	Deferred    uint64 `json:"deferred"`
+	HostLimited uint64 `json:"host_limited"`
+	Delivered   uint64 `json:"delivered"`
+	Failed      uint64 `json:"failed"`
+}
+
+func NewDeliveryQueue(ctx context.Context, s storage.StorageDriver, ns notifier.Set, config configuration.NotificationsConfig) *DeliveryQueue {
+	q := &DeliveryQueue{
+		storage:      s,
+		deliveries:   s.Deliveries(),
+		notifiers:    ns,
+		maxAttempts:  config.MaxAttempts,
+		threshold:    config.FailureThreshold,
+		backoff:      config.Backoff,
+		maxBackoff:   config.MaxBackoff,
+		interval:     config.Interval,
+		workers:      config.Workers,
+		perHostLimit: config.PerHostLimit,
+		claimed:      make(map[string]string),
+		hosts:        make(map[string]int),
+		hookLocks:    make(map[string]*hookLock),
+		wake:         make(chan struct{}, 1),
+		quit:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+
+	// attempts outlive the requests that queue them and are only cut short
+	// when a drain runs out of time.
+	q.Context, q.cancel = context.WithCancel(ctx)
+
+	if q.maxAttempts <= 0 {
+		q.maxAttempts = defaultMaxAttempts
+	}
+
+	if q.threshold <= 0 {
+		q.threshold = defaultFailureThreshold
+	}
+
+	if q.backoff <= 0 {
+		q.backoff = defaultBackoff
+	}
+
+	if q.maxBackoff <= 0 {
+		q.maxBackoff = defaultMaxBackoff
+	}
+
+	if q.interval <= 0 {
+		q.interval = defaultInterval
+	}
+
+	if q.workers <= 0 {
+		q.workers = defaultWorkers
+	}
+
+	if q.perHostLimit <= 0 {
+		q.perHostLimit = defaultPerHostLimit
+	}
+
+	size := config.QueueSize
+	if size <= 0 {
+		size = defaultQueueSize
+	}
+
+	q.jobs = make(chan *common.Delivery, size)
+	q.stats.Workers = q.workers
+	q.stats.Capacity = size
+	return q
+}
+
+func (q *DeliveryQueue) Enqueue(ctx context.Context, d *common.Delivery) error {
+	if err := q.deliveries.Store(ctx, d); err != nil {
+		return err
+	}
+
+	context.GetLoggerWithField(ctx, "delivery.id", d.ID).Infof("queued %s event for hook %s", d.Event, d.HookID)
+	q.poke()
+	return nil
+}
+
+func (q *DeliveryQueue) poke() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *DeliveryQueue) Stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := q.stats
+	stats.Queued = len(q.jobs)
+	stats.InFlight = len(q.claimed) - stats.Queued
+	stats.Hosts = len(q.hosts)
+	return stats
+}
+
+func (q *DeliveryQueue) Start() {
+	for i := 0; i < q.workers; i++ {
+		q.workerWg.Add(1)
+		go q.work()
+	}
+
+	go q.run()
+}
+
+// Stop stops the queue without waiting for due deliveries. Attempts in
+// progress are abandoned and, like everything else still pending, picked up
+// again on the next start.
+func (q *DeliveryQueue) Stop() {
+	q.Drain(0)
+}
+
+// Drain keeps delivering until nothing is due any more or timeout has passed,
+// then stops the queue. Deliveries that fail while draining are retried on the
+// next start, Drain doesn't wait for their backoff.
+func (q *DeliveryQueue) Drain(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	if timeout > 0 {
+		context.GetLogger(q).Infof("draining deliveries for up to %s", timeout)
+	}
+
+	for time.Now().Before(deadline) && !q.idle() {
+		time.Sleep(drainPollInterval)
+	}
+
+	q.stopOnce.Do(func() {
+		close(q.quit)
+	})
+
+	<-q.done
+	workersDone := make(chan struct{})
+	go func() {
+		q.workerWg.Wait()
+		close(workersDone)
+	}()
+
+	select {
+	case <-workersDone:
+	case <-time.After(time.Until(deadline)):
+		if n := q.Stats().InFlight; n > 0 {
+			context.GetLogger(q).Warnf("drain timed out, abandoning %d deliveries in flight", n)
+		}
+
+		q.cancel()
+		<-workersDone
+	}
+
+	q.cancel()
+}
+
+// idle reports whether there is nothing left to deliver right now.
+func (q *DeliveryQueue) idle() bool {
+	q.mu.Lock()
+	busy := len(q.claimed) > 0
+	q.mu.Unlock()
+	if busy {
+		return false
+	}
+
+	pending, err := q.deliveries.GetPending(q)
+	if err != nil {
+		return true
+	}
+
+	now := time.Now()
+	for _, d := range pending {
+		if d.IsDue(now) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (q *DeliveryQueue) run() {
+	defer close(q.done)
+	defer close(q.jobs)
+
+	ticker := time.NewTicker(q.interval)
+	defer ticker.Stop()
+
+	for {
+		q.schedule()
+		q.prune()
+
+		select {
+		case <-q.quit:
+			return
+		case <-ticker.C:
+		case <-q.wake:
+		}
+	}
+}
+
+// prune drops finished deliveries that fall outside of the storage driver's
+// retention limits.
+func (q *DeliveryQueue) prune() {
+	now := time.Now()
+	if now.Sub(q.lastPrune) < pruneInterval {
+		return
+	}
+
+	q.lastPrune = now
+	removed, err := q.deliveries.Prune(q, now.Unix())
+	if err != nil {
+		context.GetLogger(q).Errorf("error pruning deliveries: %v", err)
+	} else if removed > 0 {
+		context.GetLogger(q).Infof("pruned %d deliveries", removed)
+	}
+}
+
+// schedule hands due deliveries to the workers, oldest first, leaving those it
+// can't place for a later pass. Workers wake the scheduler whenever they free
+// up a slot.
+func (q *DeliveryQueue) schedule() {
+	pending, err := q.deliveries.GetPending(q)
+	if err != nil {
+		context.GetLogger(q).Errorf("error loading pending deliveries: %v", err)
+		return
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].NextAttemptAt < pending[j].NextAttemptAt
+	})
+
+	now := time.Now()
+	for i, d := range pending {
+		select {
+		case <-q.quit:
+			return
+		default:
+		}
+
+		if !d.IsDue(now) {
+			continue
+		}
+
+		host := destination(d)
+		if claimed, ok := q.claim(d.ID, host); !ok {
+			if !claimed {
+				q.count(&q.stats.HostLimited, 1)
+			}
+
+			continue
+		}
+
+		select {
+		case q.jobs <- d:
+		default:
+			q.release(d.ID)
+			deferred := uint64(0)
+			for _, rest := range pending[i:] {
+				if rest.IsDue(now) {
+					deferred++
+				}
+			}
+
+			q.count(&q.stats.Deferred, deferred)
+			context.GetLogger(q).Warnf("delivery queue is full, deferring %d due deliveries", deferred)
+			return
+		}
+	}
+}
+
+// claim reserves a slot for the delivery at its destination host. It reports
+// whether the delivery was already claimed and whether the claim succeeded.
+func (q *DeliveryQueue) claim(id string, host string) (bool, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.claimed[id]; ok {
+		return true, false
+	} else if q.hosts[host] >= q.perHostLimit {
+		return false, false
+	}
+
+	q.claimed[id] = host
+	q.hosts[host]++
+	return false, true
+}
+
+func (q *DeliveryQueue) release(id string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	host, ok := q.claimed[id]
+	if !ok {
+		return
+	}
+
+	delete(q.claimed, id)
+	if q.hosts[host]--; q.hosts[host] <= 0 {
+		delete(q.hosts, host)
+	}
+}
+
+func (q *DeliveryQueue) count(counter *uint64, n uint64) {
+	q.mu.Lock()
+	*counter += n
+	q.mu.Unlock()
+}
+
+func (q *DeliveryQueue) work() {
+	defer q.workerWg.Done()
+
+	for d := range q.jobs {
+		select {
+		case <-q.quit:
+			// left pending for the next start.
+		default:
+			q.attempt(d)
+		}
+
+		q.release(d.ID)
+		q.poke()
+	}
+}
+
+// destination is what per host limits apply to: the host of a webhook's url,
+// or the notifier for other types of hooks.
+func destination(d *common.Delivery) string {
+	if d.Hook == nil {
+		return ""
+	} else if t := d.Hook.NotifierType(); t != common.HookTypeWebhook {
+		return t
+	}
+
+	u, err := url.Parse(d.Hook.Url)
+	if err != nil {
+		return d.Hook.Url
+	}
+
+	return strings.ToLower(u.Host)
+}
+
+func (q *DeliveryQueue) attempt(d *common.Delivery) {
+	logger := context.GetLoggerWithFields(q, map[interface{}]interface{}{
+		"delivery.id":    d.ID,
+		"delivery.event": d.Event,
+		"hook.id":        d.HookID,
+	})
+
+	// deliveries of hooks that have since been removed, like the dead event
+	// of a canary, still go out using the hook as it was when queued.
+	hook, err := q.storage.Hooks().Get(q, d.HookID)
+	if err != nil {
+		hook = nil
+	} else if !hook.Active {
+		d.Failed(ErrHookInactive, 0, 1)
+		logger.Warnf("dropping delivery for inactive hook")
+		if err := q.deliveries.Store(q, d); err != nil {
+			logger.Errorf("error storing delivery: %v", err)
+		}
+
+		return
+	}
+
+	err = Deliver(q, q.notifiers, d)
+	if err != nil && q.Err() != nil {
+		logger.Warnf("delivery attempt interrupted by shutdown: %v", err)
+		return
+	}
+
+	if hook != nil {
+		q.trackHook(logger, hook.ID, d, err)
+	}
+
+	if err != nil {
+		q.count(&q.stats.Failed, 1)
+		d.Failed(err, q.nextBackoff(d.Attempts), q.maxAttempts)
+		if d.IsPending() {
+			logger.Warnf("delivery attempt %d failed, retrying at %s: %v", d.Attempts, time.Unix(d.NextAttemptAt, 0), err)
+		} else {
+			logger.Errorf("delivery failed after %d attempts: %v", d.Attempts, err)
+		}
+	} else {
+		q.count(&q.stats.Delivered, 1)
+		d.Succeeded()
+		logger.Infof("delivered after %d attempts", d.Attempts)
+	}
+
+	if err := q.deliveries.Store(q, d); err != nil {
+		logger.Errorf("error storing delivery: %v", err)
+	}
+}
+
+type hookLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lockHook locks the hook against the other workers and returns the unlock.
+func (q *DeliveryQueue) lockHook(id string) func() {
+	q.mu.Lock()
+	l, ok := q.hookLocks[id]
+	if !ok {
+		l = &hookLock{}
+		q.hookLocks[id] = l
+	}
+
+	l.refs++
+	q.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		q.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(q.hookLocks, id)
+		}
+
+		q.mu.Unlock()
+	}
+}
+
+// trackHook feeds the outcome of an attempt into the hook's circuit breaker and
+// announces the hook to the canary's other hooks when it trips. The hook is
+// read again under its lock, the copy the attempt started with may be stale.
+func (q *DeliveryQueue) trackHook(logger context.Logger, hookID string, d *common.Delivery, deliveryErr error) {
+	unlock := q.lockHook(hookID)
+	hook, err := q.storage.Hooks().Get(q, hookID)
+	if err != nil {
+		unlock()
+		logger.Warnf("not tracking hook removed during the attempt: %v", err)
+		return
+	}
+
+	disabled, changed := false, false
+	if deliveryErr != nil {
+		disabled, changed = hook.RecordFailure(deliveryErr, q.threshold), true
+	} else {
+		changed = hook.RecordSuccess()
+	}
+
+	if changed {
+		err = q.storage.Hooks().Store(q, hook)
+	}
+
+	unlock()
+	if !changed {
+		return
+	} else if err != nil {
+		logger.Errorf("error storing hook: %v", err)
+		return
+	}
+
+	if !disabled {
+		return
+	}
+
+	logger.Warnf("hook disabled after %d consecutive failures", hook.ConsecutiveFailures)
+	c, err := q.storage.Canaries().Get(q, hook.CanaryID)
+	if err != nil {
+		c = d.Canary
+	}
+
+	if err := DispatchHookEvent(q, q.storage.Hooks(), q, c, hook, common.EventHookDisabled); err != nil {
+		logger.Errorf("error dispatching %s event: %v", common.EventHookDisabled, err)
+	}
+}
+
+func (q *DeliveryQueue) nextBackoff(attempts int) time.Duration {
+	delay := q.backoff
+	for i := 0; i < attempts && delay < q.maxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > q.maxBackoff {
+		delay = q.maxBackoff
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
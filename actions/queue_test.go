@@ -0,0 +1,228 @@
+package actions
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danielkrainas/canaria-api/common"
+	"github.com/danielkrainas/canaria-api/configuration"
+	"github.com/danielkrainas/canaria-api/context"
+	"github.com/danielkrainas/canaria-api/notifier"
+	"github.com/danielkrainas/canaria-api/storage/memory"
+)
+
+// testNotifier fails the first failures attempts of every delivery.
+type testNotifier struct {
+	mu       sync.Mutex
+	failures int
+	attempts map[string]int
+}
+
+func (n *testNotifier) Validate(wh *common.WebHook) error {
+	return nil
+}
+
+func (n *testNotifier) Notify(ctx context.Context, d *common.Delivery, a *common.DeliveryAttempt) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.attempts[d.ID]++
+	if n.attempts[d.ID] <= n.failures {
+		return errors.New("receiver unavailable")
+	}
+
+	return nil
+}
+
+func newTestQueue(t *testing.T, n notifier.Notifier, config configuration.NotificationsConfig) (*DeliveryQueue, *common.WebHook) {
+	s := memory.New()
+	wh := common.NewWebHook()
+	wh.CanaryID = "canary"
+	wh.Url = "http://example.com/hook"
+	wh.Active = true
+	if err := s.Hooks().Store(context.Background(), wh); err != nil {
+		t.Fatal(err)
+	}
+
+	ns := notifier.Set{common.HookTypeWebhook: n}
+	return NewDeliveryQueue(context.Background(), s, ns, config), wh
+}
+
+func waitForDelivery(t *testing.T, q *DeliveryQueue, id string, status string) *common.Delivery {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		d, err := q.deliveries.Get(q, id)
+		if err != nil {
+			t.Fatal(err)
+		} else if d.Status == status {
+			return d
+		} else if time.Now().After(deadline) {
+			t.Fatalf("delivery still %s after %d attempts, expected %s", d.Status, d.Attempts, status)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	tests := []struct {
+		backoff    time.Duration
+		maxBackoff time.Duration
+		attempts   int
+		delay      time.Duration
+	}{
+		{time.Second, time.Hour, 0, time.Second},
+		{time.Second, time.Hour, 1, 2 * time.Second},
+		{time.Second, time.Hour, 3, 8 * time.Second},
+		{time.Second, 5 * time.Second, 3, 5 * time.Second},
+		{time.Second, 5 * time.Second, 100, 5 * time.Second},
+		{time.Minute, 30 * time.Second, 0, 30 * time.Second},
+	}
+
+	for _, test := range tests {
+		q := &DeliveryQueue{backoff: test.backoff, maxBackoff: test.maxBackoff}
+		for i := 0; i < 20; i++ {
+			delay := q.nextBackoff(test.attempts)
+			if delay < test.delay/2 || delay > test.delay {
+				t.Fatalf("backoff %s max %s attempts %d: expected %s to %s, got %s", test.backoff, test.maxBackoff, test.attempts, test.delay/2, test.delay, delay)
+			}
+		}
+	}
+}
+
+func TestDestination(t *testing.T) {
+	tests := []struct {
+		hook *common.WebHook
+		host string
+	}{
+		{nil, ""},
+		{&common.WebHook{Url: "https://Example.com:8443/hook"}, "example.com:8443"},
+		{&common.WebHook{Type: common.HookTypeWebhook, Url: "http://example.com/a?b=c"}, "example.com"},
+		{&common.WebHook{Type: "smtp", Url: "http://example.com/hook"}, "smtp"},
+	}
+
+	for _, test := range tests {
+		if host := destination(&common.Delivery{Hook: test.hook}); host != test.host {
+			t.Errorf("%+v: expected %q, got %q", test.hook, test.host, host)
+		}
+	}
+}
+
+func TestClaim(t *testing.T) {
+	q, _ := newTestQueue(t, &testNotifier{}, configuration.NotificationsConfig{PerHostLimit: 2})
+	tests := []struct {
+		id      string
+		host    string
+		claimed bool
+		ok      bool
+	}{
+		{"a", "example.com", false, true},
+		{"a", "example.com", true, false},
+		{"b", "example.com", false, true},
+		{"c", "example.com", false, false},
+		{"c", "example.org", false, true},
+	}
+
+	for _, test := range tests {
+		claimed, ok := q.claim(test.id, test.host)
+		if claimed != test.claimed || ok != test.ok {
+			t.Errorf("claim %s at %s: expected %v, %v, got %v, %v", test.id, test.host, test.claimed, test.ok, claimed, ok)
+		}
+	}
+
+	q.release("a")
+	if _, ok := q.claim("d", "example.com"); !ok {
+		t.Error("expected the released slot to be claimable")
+	}
+
+	if stats := q.Stats(); stats.Hosts != 2 || stats.InFlight != 3 {
+		t.Errorf("expected 3 claims on 2 hosts, got %+v", stats)
+	}
+}
+
+func TestQueueRetries(t *testing.T) {
+	n := &testNotifier{failures: 2, attempts: make(map[string]int)}
+	q, wh := newTestQueue(t, n, configuration.NotificationsConfig{
+		Backoff:    time.Millisecond,
+		MaxBackoff: time.Millisecond,
+		Interval:   10 * time.Millisecond,
+	})
+
+	d := common.NewDelivery(wh, nil, common.EventPing)
+	if err := q.Enqueue(q, d); err != nil {
+		t.Fatal(err)
+	}
+
+	q.Start()
+	defer q.Stop()
+
+	d = waitForDelivery(t, q, d.ID, common.DeliveryDelivered)
+	if d.Attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", d.Attempts)
+	}
+
+	hook, err := q.storage.Hooks().Get(q, wh.ID)
+	if err != nil {
+		t.Fatal(err)
+	} else if hook.ConsecutiveFailures != 0 || !hook.Active {
+		t.Errorf("expected the success to reset the hook, got %d failures, active %v", hook.ConsecutiveFailures, hook.Active)
+	}
+}
+
+func TestQueueGivesUp(t *testing.T) {
+	n := &testNotifier{failures: 100, attempts: make(map[string]int)}
+	q, wh := newTestQueue(t, n, configuration.NotificationsConfig{
+		MaxAttempts:      3,
+		FailureThreshold: 100,
+		Backoff:          time.Millisecond,
+		MaxBackoff:       time.Millisecond,
+		Interval:         10 * time.Millisecond,
+	})
+
+	d := common.NewDelivery(wh, nil, common.EventPing)
+	if err := q.Enqueue(q, d); err != nil {
+		t.Fatal(err)
+	}
+
+	q.Start()
+	defer q.Stop()
+
+	d = waitForDelivery(t, q, d.ID, common.DeliveryFailed)
+	if d.Attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", d.Attempts)
+	}
+
+	if stats := q.Stats(); stats.Failed != 3 || stats.Delivered != 0 {
+		t.Errorf("expected 3 failed attempts, got %+v", stats)
+	}
+}
+
+func TestTrackHookConcurrently(t *testing.T) {
+	q, wh := newTestQueue(t, &testNotifier{}, configuration.NotificationsConfig{FailureThreshold: 1000})
+	logger := context.GetLogger(q)
+	d := common.NewDelivery(wh, nil, common.EventPing)
+
+	const attempts = 50
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.trackHook(logger, wh.ID, d, errors.New("receiver unavailable"))
+		}()
+	}
+
+	wg.Wait()
+	hook, err := q.storage.Hooks().Get(q, wh.ID)
+	if err != nil {
+		t.Fatal(err)
+	} else if hook.ConsecutiveFailures != attempts {
+		t.Errorf("expected %d failures, got %d", attempts, hook.ConsecutiveFailures)
+	}
+
+	if len(q.hookLocks) != 0 {
+		t.Errorf("expected the hook locks to be released, %d left", len(q.hookLocks))
+	}
+}
@@ -0,0 +1,129 @@
+package actions
+
+import (
+	"sync"
+	"time"
+
+	"github.com/danielkrainas/canaria-api/common"
+	"github.com/danielkrainas/canaria-api/configuration"
+	"github.com/danielkrainas/canaria-api/context"
+	"github.com/danielkrainas/canaria-api/storage"
+)
+
+const defaultReaperInterval = 10 * time.Second
+
+type Reaper struct {
+	context.Context
+
+	storage  storage.StorageDriver
+	queue    *DeliveryQueue
+	interval time.Duration
+
+	quit     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewReaper(ctx context.Context, s storage.StorageDriver, q *DeliveryQueue, config configuration.ReaperConfig) *Reaper {
+	r := &Reaper{
+		Context:  ctx,
+		storage:  s,
+		queue:    q,
+		interval: config.Interval,
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	if r.interval <= 0 {
+		r.interval = defaultReaperInterval
+	}
+
+	return r
+}
+
+func (r *Reaper) Start() {
+	go r.run()
+}
+
+func (r *Reaper) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.quit)
+	})
+
+	<-r.done
+}
+
+func (r *Reaper) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.warn()
+		r.reap()
+
+		select {
+		case <-r.quit:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// warn announces canaries that are about to expire. Each warning offset fires
+// at most once per refresh cycle.
+func (r *Reaper) warn() {
+	now := time.Now().Unix()
+	canaries, err := r.storage.Canaries().GetWarnable(r, now)
+	if err != nil {
+		context.GetLogger(r).Errorf("error loading expiring canaries: %v", err)
+		return
+	}
+
+	for _, c := range canaries {
+		due := c.DueWarnings(now)
+		if len(due) < 1 {
+			continue
+		}
+
+		ctx := context.WithLogger(r, context.GetLoggerWithField(r, "canary.id", c.ID))
+		lastUpdateToken := c.UpdateToken
+		c.MarkWarned(due)
+		if err := r.storage.Canaries().Replace(ctx, c, lastUpdateToken, nil); err == storage.ErrConflict {
+			continue
+		} else if err != nil {
+			context.GetLogger(ctx).Errorf("error marking canary warned: %v", err)
+			continue
+		}
+
+		context.GetLogger(ctx).Infof("canary expiring in %ds", c.ExpiresAt()-now)
+		if err := Dispatch(ctx, r.storage.Hooks(), r.queue, c, common.EventExpiring); err != nil {
+			context.GetLogger(ctx).Errorf("error dispatching expiring event: %v", err)
+		}
+	}
+}
+
+func (r *Reaper) reap() {
+	zombies, err := r.storage.Canaries().GetExpired(r, time.Now().Unix())
+	if err != nil {
+		context.GetLogger(r).Errorf("error loading expired canaries: %v", err)
+		return
+	}
+
+	for _, c := range zombies {
+		select {
+		case <-r.quit:
+			return
+		default:
+		}
+
+		ctx := context.WithLogger(r, context.GetLoggerWithField(r, "canary.id", c.ID))
+		context.GetLogger(ctx).Warnf("reaping zombie")
+		if err := KillZombie(ctx, r.storage, r.queue, c); err == storage.ErrConflict {
+			context.GetLogger(ctx).Infof("zombie changed before it could be reaped")
+		} else if err != nil {
+			context.GetLogger(ctx).Errorf("error killing zombie canary: %v", err)
+		}
+	}
+}
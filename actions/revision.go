@@ -0,0 +1,19 @@
+package actions
+
+import (
+	"github.com/danielkrainas/canaria-api/auth"
+	"github.com/danielkrainas/canaria-api/common"
+	"github.com/danielkrainas/canaria-api/context"
+)
+
+// newRevision describes the change about to be stored, along with who made it
+// and from where.
+func newRevision(ctx context.Context, c *common.Canary, action string, lastUpdateToken string) *common.CanaryRevision {
+	r := common.NewCanaryRevision(c, action, lastUpdateToken)
+	r.Actor = context.GetStringValue(ctx, auth.UserNameKey)
+	if req, err := context.GetRequest(ctx); err == nil {
+		r.RemoteAddr = context.RemoteIP(req)
+	}
+
+	return r
+}
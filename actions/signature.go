@@ -0,0 +1,149 @@
+package actions
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danielkrainas/canaria-api/common"
+)
+
+var (
+	HookTimestampHeader = "X-Canary-Timestamp"
+
+	ErrSignatureMissing   = errors.New("signature missing")
+	ErrSignatureInvalid   = errors.New("signature invalid")
+	ErrTimestampInvalid   = errors.New("timestamp invalid")
+	ErrTimestampOutOfSync = errors.New("timestamp outside of tolerance")
+)
+
+var signatureHashes = map[string]func() hash.Hash{
+	common.SignatureSHA1:   sha1.New,
+	common.SignatureSHA256: sha256.New,
+	common.SignatureSHA512: sha512.New,
+}
+
+func signaturePayload(timestamp string, body []byte) []byte {
+	payload := make([]byte, 0, len(timestamp)+1+len(body))
+	payload = append(payload, timestamp...)
+	payload = append(payload, '.')
+	return append(payload, body...)
+}
+
+func Sign(algorithm string, secret string, timestamp int64, body []byte) (string, error) {
+	if algorithm == "" {
+		algorithm = common.SignatureSHA256
+	}
+
+	newHash, ok := signatureHashes[algorithm]
+	if !ok {
+		return "", fmt.Errorf("unsupported signature algorithm: %q", algorithm)
+	}
+
+	mac := hmac.New(newHash, []byte(secret))
+	mac.Write(signaturePayload(strconv.FormatInt(timestamp, 10), body))
+	return algorithm + "=" + hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// signRequest signs the body with every secret the hook currently signs with.
+// While a secret is being rotated the header carries one signature per secret,
+// separated by commas, newest first.
+func signRequest(req *http.Request, wh *common.WebHook, body []byte) error {
+	now := time.Now()
+	timestamp := now.Unix()
+	req.Header.Set(HookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	secrets := wh.SigningSecrets(now)
+	if len(secrets) < 1 {
+		return nil
+	}
+
+	signatures := make([]string, 0, len(secrets))
+	for _, secret := range secrets {
+		signature, err := Sign(wh.SignatureAlgorithm, secret, timestamp, body)
+		if err != nil {
+			return err
+		}
+
+		signatures = append(signatures, signature)
+	}
+
+	req.Header.Set(HookSignatureHeader, strings.Join(signatures, ", "))
+	return nil
+}
+
+// VerifySignature accepts the request if any of the signatures in the header
+// matches the secret. The algorithm is the one the hook was configured with,
+// signatures made with any other algorithm are ignored so that a sender can't
+// downgrade the check.
+func VerifySignature(algorithm string, secret string, signature string, timestamp string, body []byte, tolerance time.Duration) error {
+	if algorithm == "" {
+		algorithm = common.SignatureSHA256
+	}
+
+	if _, ok := signatureHashes[algorithm]; !ok {
+		return fmt.Errorf("unsupported signature algorithm: %q", algorithm)
+	}
+
+	if signature == "" {
+		return ErrSignatureMissing
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrTimestampInvalid
+	}
+
+	if tolerance > 0 {
+		skew := time.Since(time.Unix(ts, 0))
+		if skew < 0 {
+			skew = -skew
+		}
+
+		if skew > tolerance {
+			return ErrTimestampOutOfSync
+		}
+	}
+
+	for _, candidate := range strings.Split(signature, ",") {
+		candidate = strings.TrimSpace(candidate)
+		parts := strings.SplitN(candidate, "=", 2)
+		if len(parts) != 2 || parts[0] != algorithm {
+			continue
+		}
+
+		expected, err := Sign(algorithm, secret, ts, body)
+		if err != nil {
+			continue
+		}
+
+		if hmac.Equal([]byte(expected), []byte(candidate)) {
+			return nil
+		}
+	}
+
+	return ErrSignatureInvalid
+}
+
+func VerifyRequest(r *http.Request, algorithm string, secret string, tolerance time.Duration) ([]byte, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	err = VerifySignature(algorithm, secret, r.Header.Get(HookSignatureHeader), r.Header.Get(HookTimestampHeader), body, tolerance)
+	if err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
@@ -0,0 +1,100 @@
+package actions
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/danielkrainas/canaria-api/common"
+)
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"action":"dead"}`)
+	tests := []struct {
+		algorithm string
+		prefix    string
+		err       bool
+	}{
+		{"", common.SignatureSHA256 + "=", false},
+		{common.SignatureSHA1, common.SignatureSHA1 + "=", false},
+		{common.SignatureSHA256, common.SignatureSHA256 + "=", false},
+		{common.SignatureSHA512, common.SignatureSHA512 + "=", false},
+		{"md5", "", true},
+	}
+
+	for _, test := range tests {
+		signature, err := Sign(test.algorithm, "secret", 1, body)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", test.algorithm)
+			}
+
+			continue
+		} else if err != nil {
+			t.Errorf("%q: %v", test.algorithm, err)
+			continue
+		}
+
+		if len(signature) <= len(test.prefix) || signature[:len(test.prefix)] != test.prefix {
+			t.Errorf("%q: expected prefix %q, got %q", test.algorithm, test.prefix, signature)
+		}
+
+		again, _ := Sign(test.algorithm, "secret", 1, body)
+		if again != signature {
+			t.Errorf("%q: signature is not deterministic", test.algorithm)
+		}
+
+		other, _ := Sign(test.algorithm, "secret", 2, body)
+		if other == signature {
+			t.Errorf("%q: signature does not cover the timestamp", test.algorithm)
+		}
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	body := []byte(`{"action":"dead"}`)
+	now := time.Now().Unix()
+	ts := strconv.FormatInt(now, 10)
+	sign := func(algorithm, secret string, timestamp int64) string {
+		signature, err := Sign(algorithm, secret, timestamp, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return signature
+	}
+
+	sha256Sig := sign(common.SignatureSHA256, "secret", now)
+	tests := []struct {
+		name      string
+		algorithm string
+		signature string
+		timestamp string
+		body      []byte
+		err       error
+	}{
+		{"valid", common.SignatureSHA256, sha256Sig, ts, body, nil},
+		{"default algorithm", "", sha256Sig, ts, body, nil},
+		{"sha512", common.SignatureSHA512, sign(common.SignatureSHA512, "secret", now), ts, body, nil},
+		{"rotation", common.SignatureSHA256, sign(common.SignatureSHA256, "new", now) + ", " + sha256Sig, ts, body, nil},
+		{"missing", common.SignatureSHA256, "", ts, body, ErrSignatureMissing},
+		{"wrong secret", common.SignatureSHA256, sign(common.SignatureSHA256, "other", now), ts, body, ErrSignatureInvalid},
+		{"tampered body", common.SignatureSHA256, sha256Sig, ts, []byte(`{"action":"alive"}`), ErrSignatureInvalid},
+		{"downgrade", common.SignatureSHA256, sign(common.SignatureSHA1, "secret", now), ts, body, ErrSignatureInvalid},
+		{"unknown algorithm in header", common.SignatureSHA256, "md5=00", ts, body, ErrSignatureInvalid},
+		{"malformed", common.SignatureSHA256, "garbage", ts, body, ErrSignatureInvalid},
+		{"bad timestamp", common.SignatureSHA256, sha256Sig, "yesterday", body, ErrTimestampInvalid},
+		{"stale", common.SignatureSHA256, sign(common.SignatureSHA256, "secret", now-600), strconv.FormatInt(now-600, 10), body, ErrTimestampOutOfSync},
+	}
+
+	for _, test := range tests {
+		err := VerifySignature(test.algorithm, "secret", test.signature, test.timestamp, test.body, time.Minute)
+		if err != test.err {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+	}
+
+	if err := VerifySignature("md5", "secret", sha256Sig, ts, body, time.Minute); err == nil {
+		t.Error("expected an unsupported configured algorithm to be rejected")
+	}
+}
@@ -0,0 +1,383 @@
+package actions
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+)
+
+const (
+	MaxTemplateSize   = 16 * 1024
+	MaxTemplateOutput = 64 * 1024
+
+	templateTimeout = time.Second
+
+	// maxRangeDepth and maxRangeIterations bound how much work the loops of a
+	// template can do, whatever the data they range over.
+	maxRangeDepth      = 2
+	maxRangeIterations = 10000
+
+	// rangeTickFunc is called at the start of every range iteration. It is
+	// added by parseTemplate and can't be called by templates themselves.
+	rangeTickFunc = "_rangeTick"
+)
+
+var (
+	ErrTemplateTooLarge       = fmt.Errorf("template exceeds %d bytes", MaxTemplateSize)
+	ErrTemplateOutputTooLarge = fmt.Errorf("template output exceeds %d bytes", MaxTemplateOutput)
+	ErrTemplateTimeout        = fmt.Errorf("template did not finish within %s", templateTimeout)
+	ErrTemplateTooManyLoops   = fmt.Errorf("template ranges over more than %d items", maxRangeIterations)
+)
+
+// templateRun is the state of a single execution of a template. It lets the
+// template stop itself once it runs past its deadline, rather than running on
+// after renderTemplate gave up on it.
+type templateRun struct {
+	deadline   time.Time
+	iterations int
+}
+
+func (r *templateRun) check() error {
+	if r != nil && time.Now().After(r.deadline) {
+		return ErrTemplateTimeout
+	}
+
+	return nil
+}
+
+func (r *templateRun) tick() (string, error) {
+	if r == nil {
+		return "", nil
+	}
+
+	if r.iterations++; r.iterations > maxRangeIterations {
+		return "", ErrTemplateTooManyLoops
+	}
+
+	return "", r.check()
+}
+
+// templateFuncs are the functions available to templates, bound to run. The
+// functions that do real work check the deadline before doing it.
+func templateFuncs(run *templateRun) template.FuncMap {
+	funcs := template.FuncMap{
+		rangeTickFunc: run.tick,
+
+		"json": func(v interface{}) (string, error) {
+			if err := run.check(); err != nil {
+				return "", err
+			}
+
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+
+		// printf replaces the builtin, whose padding could allocate far more
+		// than the output limit before a single byte is written.
+		"printf": func(format string, args ...interface{}) (string, error) {
+			if err := run.check(); err != nil {
+				return "", err
+			} else if err := checkFormat(format); err != nil {
+				return "", err
+			}
+
+			return fmt.Sprintf(format, args...), nil
+		},
+
+		"replace": func(old string, new string, s string) (string, error) {
+			if err := run.check(); err != nil {
+				return "", err
+			} else if n := strings.Count(s, old); len(s)+n*(len(new)-len(old)) > MaxTemplateOutput {
+				return "", ErrTemplateOutputTooLarge
+			}
+
+			return strings.Replace(s, old, new, -1), nil
+		},
+
+		"join": func(sep string, v interface{}) (string, error) {
+			if err := run.check(); err != nil {
+				return "", err
+			}
+
+			items, _ := v.([]interface{})
+			s := make([]string, len(items))
+			for i, item := range items {
+				s[i] = fmt.Sprint(item)
+			}
+
+			return strings.Join(s, sep), nil
+		},
+	}
+
+	for name, fn := range plainTemplateFuncs {
+		funcs[name] = fn
+	}
+
+	return funcs
+}
+
+// plainTemplateFuncs are cheap enough to not need the deadline.
+var plainTemplateFuncs = template.FuncMap{
+
+	"upper":     strings.ToUpper,
+	"lower":     strings.ToLower,
+	"trim":      strings.TrimSpace,
+	"hasPrefix": strings.HasPrefix,
+	"hasSuffix": strings.HasSuffix,
+	"contains":  strings.Contains,
+
+	"truncate": func(n int, s string) string {
+		if n >= 0 && len(s) > n {
+			return s[:n]
+		}
+
+		return s
+	},
+
+	"default": func(def interface{}, v interface{}) interface{} {
+		if v == nil || v == "" {
+			return def
+		}
+
+		return v
+	},
+
+	"time": func(v interface{}) string {
+		switch n := v.(type) {
+		case float64:
+			return time.Unix(int64(n), 0).UTC().Format(time.RFC3339)
+
+		case json.Number:
+			if i, err := n.Int64(); err == nil {
+				return time.Unix(i, 0).UTC().Format(time.RFC3339)
+			}
+		}
+
+		return ""
+	},
+}
+
+const maxFormatWidth = 256
+
+// checkFormat refuses printf verbs with a computed or excessive width or
+// precision.
+func checkFormat(format string) error {
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+
+		n := 0
+		for i++; i < len(format); i++ {
+			c := format[i]
+			if c == '*' {
+				return errors.New("printf: computed width is not allowed")
+			} else if c >= '0' && c <= '9' {
+				if n = n*10 + int(c-'0'); n > maxFormatWidth {
+					return fmt.Errorf("printf: width exceeds %d", maxFormatWidth)
+				}
+			} else if c == '.' {
+				n = 0
+			} else if !strings.ContainsRune("+-# ", rune(c)) {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseTemplate parses a hook's payload template and checks that it stays
+// within what is safe to run for untrusted hook owners. Templates only ever
+// see plain data decoded from the event's JSON, so there are no methods to
+// call, and the only loops allowed are over that data, nested no deeper than
+// maxRangeDepth. Defining or invoking other templates is refused so that
+// templates can't recurse.
+func parseTemplate(text string) (*template.Template, error) {
+	if len(text) > MaxTemplateSize {
+		return nil, ErrTemplateTooLarge
+	} else if strings.Contains(text, rangeTickFunc) {
+		return nil, fmt.Errorf("templates may not call %s", rangeTickFunc)
+	}
+
+	t, err := template.New("payload").Funcs(templateFuncs(nil)).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(t.Templates()) > 1 {
+		return nil, errors.New("templates may not define other templates")
+	}
+
+	if t.Tree == nil || t.Tree.Root == nil {
+		return t, nil
+	}
+
+	tick, err := rangeTick()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkTemplateNode(t.Tree.Root, tick, 0); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// rangeTick is the action that checkTemplateNode puts at the start of every
+// range body.
+func rangeTick() (parse.Node, error) {
+	t, err := template.New("tick").Funcs(templateFuncs(nil)).Parse("{{" + rangeTickFunc + "}}")
+	if err != nil {
+		return nil, err
+	}
+
+	return t.Tree.Root.Nodes[0], nil
+}
+
+// checkTemplateNode refuses the parts of a template that aren't allowed and
+// makes every range count its iterations.
+func checkTemplateNode(node parse.Node, tick parse.Node, depth int) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+
+		for _, child := range n.Nodes {
+			if err := checkTemplateNode(child, tick, depth); err != nil {
+				return err
+			}
+		}
+
+	case *parse.TemplateNode:
+		return errors.New("templates may not invoke other templates")
+
+	case *parse.IfNode:
+		return checkBranch(&n.BranchNode, tick, depth)
+
+	case *parse.WithNode:
+		return checkBranch(&n.BranchNode, tick, depth)
+
+	case *parse.RangeNode:
+		if !rangesOverData(n.Pipe) {
+			return errors.New("range is only allowed over fields of the event")
+		} else if depth >= maxRangeDepth {
+			return fmt.Errorf("ranges may not be nested more than %d deep", maxRangeDepth)
+		}
+
+		if err := checkTemplateNode(n.List, tick, depth+1); err != nil {
+			return err
+		} else if err := checkTemplateNode(n.ElseList, tick, depth); err != nil {
+			return err
+		}
+
+		n.List.Nodes = append([]parse.Node{tick}, n.List.Nodes...)
+	}
+
+	return nil
+}
+
+func checkBranch(b *parse.BranchNode, tick parse.Node, depth int) error {
+	if err := checkTemplateNode(b.List, tick, depth); err != nil {
+		return err
+	}
+
+	return checkTemplateNode(b.ElseList, tick, depth)
+}
+
+// rangesOverData reports whether a range pipeline is a plain field reference,
+// such as .canary.labels or $c.labels. Anything else could be a number and
+// loop for as long as it likes.
+func rangesOverData(pipe *parse.PipeNode) bool {
+	if pipe == nil || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return false
+	}
+
+	switch arg := pipe.Cmds[0].Args[0].(type) {
+	case *parse.FieldNode, *parse.DotNode:
+		return true
+
+	case *parse.VariableNode:
+		return len(arg.Ident) > 1
+	}
+
+	return false
+}
+
+// renderTemplate executes t against data, giving up when the output grows too
+// large or execution takes too long. The execution checks the same deadline
+// on every write, range iteration and function call, so it stops on its own
+// shortly after renderTemplate stops waiting for it.
+func renderTemplate(t *template.Template, data interface{}) ([]byte, error) {
+	run := &templateRun{deadline: time.Now().Add(templateTimeout)}
+	t = t.Funcs(templateFuncs(run))
+
+	out := &cappedBuffer{limit: MaxTemplateOutput, run: run}
+	done := make(chan error, 1)
+	go func() {
+		done <- t.Execute(out, data)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			if out.exceeded {
+				return nil, ErrTemplateOutputTooLarge
+			} else if run.iterations > maxRangeIterations {
+				return nil, ErrTemplateTooManyLoops
+			} else if run.check() != nil {
+				return nil, ErrTemplateTimeout
+			}
+
+			return nil, err
+		}
+
+		return out.Bytes(), nil
+
+	case <-time.After(templateTimeout):
+		return nil, ErrTemplateTimeout
+	}
+}
+
+// templateData is the notification as plain maps and slices, the form that
+// templates get to see.
+func templateData(v interface{}) (interface{}, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var data interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if err := dec.Decode(&data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+type cappedBuffer struct {
+	bytes.Buffer
+
+	limit    int
+	exceeded bool
+	run      *templateRun
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if err := b.run.check(); err != nil {
+		return 0, err
+	} else if b.Len()+len(p) > b.limit {
+		b.exceeded = true
+		return 0, ErrTemplateOutputTooLarge
+	}
+
+	return b.Buffer.Write(p)
+}
@@ -0,0 +1,141 @@
+package actions
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTemplate(t *testing.T) {
+	tests := []struct {
+		text string
+		err  bool
+	}{
+		{`{{.action}}`, false},
+		{`{{upper .canary.title}} {{json .canary}}`, false},
+		{`{{range .canary.labels}}{{.}}{{end}}`, false},
+		{`{{range $l := .canary.labels}}{{$l}}{{else}}none{{end}}`, false},
+		{`{{with .canary}}{{range .labels}}{{range .}}{{.}}{{end}}{{end}}{{end}}`, false},
+		{`{{if .hook}}{{.hook.name}}{{end}}`, false},
+		{`{{range .a}}{{range .b}}{{range .c}}{{end}}{{end}}{{end}}`, true},
+		{`{{range 1000000000}}x{{end}}`, true},
+		{`{{range (json .)}}{{end}}`, true},
+		{`{{range $}}{{end}}`, true},
+		{`{{define "x"}}{{template "x"}}{{end}}`, true},
+		{`{{template "payload"}}`, true},
+		{`{{_rangeTick}}`, true},
+		{`{{.action`, true},
+		{strings.Repeat("x", MaxTemplateSize+1), true},
+	}
+
+	for _, test := range tests {
+		_, err := parseTemplate(test.text)
+		if test.err && err == nil {
+			t.Errorf("%.60q: expected an error", test.text)
+		} else if !test.err && err != nil {
+			t.Errorf("%.60q: %v", test.text, err)
+		}
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	items := make([]interface{}, 200)
+	for i := range items {
+		items[i] = i
+	}
+
+	data := map[string]interface{}{
+		"action": "dead",
+		"canary": map[string]interface{}{
+			"title":      "backups",
+			"labels":     []interface{}{"env=prod", "beta"},
+			"updated_at": float64(0),
+		},
+		"items": items,
+		"big":   strings.Repeat("x", 1024),
+	}
+
+	tests := []struct {
+		text     string
+		expected string
+		err      error
+	}{
+		{`{{.action}}`, "dead", nil},
+		{`{{upper .canary.title}}`, "BACKUPS", nil},
+		{`{{join ", " .canary.labels}}`, "env=prod, beta", nil},
+		{`{{range .canary.labels}}[{{.}}]{{end}}`, "[env=prod][beta]", nil},
+		{`{{json .canary.labels}}`, `["env=prod","beta"]`, nil},
+		{`{{time .canary.updated_at}}`, "1970-01-01T00:00:00Z", nil},
+		{`{{default "none" .missing}}`, "none", nil},
+		{`{{truncate 3 .canary.title}}`, "bac", nil},
+		{`{{replace "a" "o" .canary.title}}`, "bockups", nil},
+		{`{{printf "%5s|" .action}}`, " dead|", nil},
+		{`{{range .items}}{{$.big}}{{end}}`, "", ErrTemplateOutputTooLarge},
+		{`{{range .items}}{{range $.items}}{{end}}{{end}}`, "", ErrTemplateTooManyLoops},
+		{`{{replace "x" $.big .big}}`, "", ErrTemplateOutputTooLarge},
+	}
+
+	for _, test := range tests {
+		tmpl, err := parseTemplate(test.text)
+		if err != nil {
+			t.Errorf("%q: %v", test.text, err)
+			continue
+		}
+
+		// errors returned by functions reach us wrapped by the template.
+		out, err := renderTemplate(tmpl, data)
+		if test.err != nil && (err == nil || !strings.Contains(err.Error(), test.err.Error())) {
+			t.Errorf("%q: expected %v, got %v", test.text, test.err, err)
+		} else if test.err == nil && err != nil {
+			t.Errorf("%q: %v", test.text, err)
+		} else if string(out) != test.expected {
+			t.Errorf("%q: expected %q, got %q", test.text, test.expected, out)
+		}
+	}
+}
+
+func TestRenderTemplateRefusesWidePrintf(t *testing.T) {
+	tests := []string{
+		`{{printf "%*d" 1000000000 1}}`,
+		`{{printf "%1000000000d" 1}}`,
+		`{{printf "%.1000000000f" 1.0}}`,
+	}
+
+	for _, text := range tests {
+		tmpl, err := parseTemplate(text)
+		if err != nil {
+			t.Errorf("%q: %v", text, err)
+			continue
+		}
+
+		if _, err := renderTemplate(tmpl, nil); err == nil || !strings.Contains(err.Error(), "printf") {
+			t.Errorf("%q: expected printf to refuse the format, got %v", text, err)
+		}
+	}
+}
+
+func TestCheckFormat(t *testing.T) {
+	tests := []struct {
+		format string
+		err    bool
+	}{
+		{"plain", false},
+		{"%s %d %v", false},
+		{"%-10s|%+.2f|%#x", false},
+		{"%256d", false},
+		{"%.256f", false},
+		{"100%%", false},
+		{"%257d", true},
+		{"%.257f", true},
+		{"%*d", true},
+		{"%.*f", true},
+	}
+
+	for _, test := range tests {
+		err := checkFormat(test.format)
+		if test.err && err == nil {
+			t.Errorf("%q: expected an error", test.format)
+		} else if !test.err && err != nil {
+			t.Errorf("%q: %v", test.format, err)
+		}
+	}
+}
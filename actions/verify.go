@@ -0,0 +1,237 @@
+package actions
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+
+	"github.com/danielkrainas/canaria-api/common"
+	"github.com/danielkrainas/canaria-api/configuration"
+	"github.com/danielkrainas/canaria-api/context"
+)
+
+const (
+	defaultKeyCacheTTL  = time.Hour
+	defaultFetchTimeout = 10 * time.Second
+
+	maxPublicKeySize = 64 * 1024
+)
+
+var (
+	ErrPublicKeyMissing  = errors.New("signature requires a public key or public key url")
+	ErrPublicKeyPinned   = errors.New("public key does not match the pinned fingerprint")
+	ErrPublicKeyInvalid  = errors.New("unsupported or malformed public key")
+	ErrSignatureMismatch = errors.New("signature does not match canary content")
+	ErrSignatureRequired = errors.New("canary has a pinned public key and must stay signed")
+
+	// ErrPublicKeyUnavailable is all that callers learn about failed key
+	// fetches, the details would tell them about the server's network.
+	ErrPublicKeyUnavailable = errors.New("unable to fetch the public key")
+)
+
+type cachedKey struct {
+	key       string
+	fetchedAt time.Time
+}
+
+// KeyResolver fetches public keys from canaries' key urls. Fetches go through
+// the same outbound policy as webhooks, key urls are just as much in the
+// hands of clients.
+type KeyResolver struct {
+	client  *HookClient
+	ttl     time.Duration
+	timeout time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedKey
+}
+
+func NewKeyResolver(config configuration.SignaturesConfig, policy *common.HookPolicy) *KeyResolver {
+	kr := &KeyResolver{
+		client:  NewHookClient(policy),
+		ttl:     config.KeyCacheTTL,
+		timeout: config.FetchTimeout,
+		cache:   make(map[string]cachedKey),
+	}
+
+	if kr.timeout <= 0 {
+		kr.timeout = defaultFetchTimeout
+	}
+
+	if kr.ttl <= 0 {
+		kr.ttl = defaultKeyCacheTTL
+	}
+
+	return kr
+}
+
+func (kr *KeyResolver) Resolve(ctx context.Context, c *common.Canary) (string, error) {
+	if c.PublicKey != "" {
+		return c.PublicKey, nil
+	} else if c.PublicKeyUrl == "" {
+		return "", ErrPublicKeyMissing
+	}
+
+	kr.mu.Lock()
+	cached, ok := kr.cache[c.PublicKeyUrl]
+	kr.mu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < kr.ttl {
+		return cached.key, nil
+	}
+
+	context.GetLogger(ctx).Debugf("fetching public key from %s", c.PublicKeyUrl)
+	raw, err := kr.fetch(ctx, c.PublicKeyUrl)
+	if err != nil {
+		context.GetLogger(ctx).Warnf("error fetching public key from %s: %v", c.PublicKeyUrl, err)
+		return "", ErrPublicKeyUnavailable
+	}
+
+	key := string(raw)
+	kr.mu.Lock()
+	kr.cache[c.PublicKeyUrl] = cachedKey{key: key, fetchedAt: time.Now()}
+	kr.mu.Unlock()
+	return key, nil
+}
+
+func (kr *KeyResolver) fetch(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, kr.timeout)
+	defer cancel()
+
+	res, err := kr.client.Do(req.WithContext(fetchCtx), false)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %q", res.Status)
+	}
+
+	raw, err := ioutil.ReadAll(io.LimitReader(res.Body, maxPublicKeySize+1))
+	if err != nil {
+		return nil, err
+	} else if len(raw) > maxPublicKeySize {
+		return nil, fmt.Errorf("key exceeds %d bytes", maxPublicKeySize)
+	}
+
+	return raw, nil
+}
+
+// VerifyCanary checks the canary signature against its public key. Unsigned
+// canaries are accepted but left unverified. The fingerprint of the first key
+// that verifies is pinned on the canary and later keys must match it, once
+// pinned the canary can't drop its signature until it is unpinned.
+func VerifyCanary(ctx context.Context, kr *KeyResolver, c *common.Canary) error {
+	c.Verified = false
+	if c.Signature == "" {
+		if c.PublicKeyFingerprint != "" {
+			return ErrSignatureRequired
+		}
+
+		return nil
+	}
+
+	key, err := kr.Resolve(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	var fingerprint string
+	if isArmoredPGP(key) {
+		fingerprint, err = verifyPGP(key, c.Signature, c.SignedContent())
+	} else {
+		fingerprint, err = verifyEd25519(key, c.Signature, c.SignedContent())
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if c.PublicKeyFingerprint != "" && !strings.EqualFold(c.PublicKeyFingerprint, fingerprint) {
+		return ErrPublicKeyPinned
+	}
+
+	c.PublicKeyFingerprint = fingerprint
+	c.Verified = true
+	return nil
+}
+
+func isArmoredPGP(key string) bool {
+	return strings.Contains(key, "-----BEGIN PGP PUBLIC KEY BLOCK-----")
+}
+
+func verifyPGP(key string, signature string, content []byte) (string, error) {
+	keyring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(key))
+	if err != nil || len(keyring) == 0 {
+		return "", ErrPublicKeyInvalid
+	}
+
+	signer, err := openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(content), strings.NewReader(signature))
+	if err != nil {
+		return "", ErrSignatureMismatch
+	}
+
+	return strings.ToUpper(hex.EncodeToString(signer.PrimaryKey.Fingerprint[:])), nil
+}
+
+func parseEd25519Key(key string) (ed25519.PublicKey, error) {
+	key = strings.TrimSpace(key)
+	if block, _ := pem.Decode([]byte(key)); block != nil {
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, ErrPublicKeyInvalid
+		}
+
+		edKey, ok := pub.(ed25519.PublicKey)
+		if !ok {
+			return nil, ErrPublicKeyInvalid
+		}
+
+		return edKey, nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, ErrPublicKeyInvalid
+	}
+
+	return ed25519.PublicKey(raw), nil
+}
+
+func verifyEd25519(key string, signature string, content []byte) (string, error) {
+	pub, err := parseEd25519Key(key)
+	if err != nil {
+		return "", err
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(signature))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return "", ErrSignatureMismatch
+	}
+
+	if !ed25519.Verify(pub, content, sig) {
+		return "", ErrSignatureMismatch
+	}
+
+	sum := sha256.Sum256(pub)
+	return strings.ToUpper(hex.EncodeToString(sum[:])), nil
+}
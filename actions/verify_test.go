@@ -0,0 +1,214 @@
+package actions
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+
+	"github.com/danielkrainas/canaria-api/common"
+	"github.com/danielkrainas/canaria-api/configuration"
+	"github.com/danielkrainas/canaria-api/context"
+)
+
+type testKey struct {
+	public  string
+	pem     string
+	private ed25519.PrivateKey
+}
+
+func newTestKey(t *testing.T) *testKey {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testKey{
+		public:  base64.StdEncoding.EncodeToString(pub),
+		pem:     string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+		private: priv,
+	}
+}
+
+func (k *testKey) sign(c *common.Canary) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(k.private, c.SignedContent()))
+}
+
+func newSignedCanary() *common.Canary {
+	return &common.Canary{
+		ID:         "canary",
+		TimeToLive: 600,
+		Title:      "title",
+		Message:    "message",
+		Labels:     []string{},
+	}
+}
+
+func TestVerifyCanary(t *testing.T) {
+	key, other := newTestKey(t), newTestKey(t)
+	tests := []struct {
+		name     string
+		canary   func(c *common.Canary)
+		err      error
+		verified bool
+	}{
+		{"unsigned", func(c *common.Canary) {}, nil, false},
+		{"unsigned with a pin", func(c *common.Canary) {
+			c.PublicKeyFingerprint = "AB"
+		}, ErrSignatureRequired, false},
+		{"raw key", func(c *common.Canary) {
+			c.PublicKey = key.public
+			c.Signature = key.sign(c)
+		}, nil, true},
+		{"pem key", func(c *common.Canary) {
+			c.PublicKey = key.pem
+			c.Signature = key.sign(c)
+		}, nil, true},
+		{"no key", func(c *common.Canary) {
+			c.Signature = key.sign(c)
+		}, ErrPublicKeyMissing, false},
+		{"malformed key", func(c *common.Canary) {
+			c.PublicKey = "not a key"
+			c.Signature = key.sign(c)
+		}, ErrPublicKeyInvalid, false},
+		{"other key", func(c *common.Canary) {
+			c.PublicKey = other.public
+			c.Signature = key.sign(c)
+		}, ErrSignatureMismatch, false},
+		{"changed content", func(c *common.Canary) {
+			c.PublicKey = key.public
+			c.Signature = key.sign(c)
+			c.Message = "all is well"
+		}, ErrSignatureMismatch, false},
+		{"malformed signature", func(c *common.Canary) {
+			c.PublicKey = key.public
+			c.Signature = "c2ln"
+		}, ErrSignatureMismatch, false},
+		{"pinned to another key", func(c *common.Canary) {
+			c.PublicKey = other.public
+			c.Signature = other.sign(c)
+			c.PublicKeyFingerprint = "AB"
+		}, ErrPublicKeyPinned, false},
+	}
+
+	kr := NewKeyResolver(configuration.SignaturesConfig{}, nil)
+	for _, test := range tests {
+		c := newSignedCanary()
+		c.Verified = true
+		test.canary(c)
+		err := VerifyCanary(context.Background(), kr, c)
+		if err != test.err {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+
+		if c.Verified != test.verified {
+			t.Errorf("%s: expected verified %v, got %v", test.name, test.verified, c.Verified)
+		}
+	}
+}
+
+func TestVerifyCanaryPins(t *testing.T) {
+	key := newTestKey(t)
+	kr := NewKeyResolver(configuration.SignaturesConfig{}, nil)
+	c := newSignedCanary()
+	c.PublicKey = key.public
+	c.Signature = key.sign(c)
+	if err := VerifyCanary(context.Background(), kr, c); err != nil {
+		t.Fatal(err)
+	} else if c.PublicKeyFingerprint == "" {
+		t.Fatal("expected the key to be pinned")
+	}
+
+	pinned := c.PublicKeyFingerprint
+
+	// the same key in another encoding is still the pinned key.
+	c.PublicKey = key.pem
+	c.Title = "new title"
+	c.Signature = key.sign(c)
+	if err := VerifyCanary(context.Background(), kr, c); err != nil {
+		t.Fatal(err)
+	} else if c.PublicKeyFingerprint != pinned {
+		t.Fatalf("expected the pin %s to be kept, got %s", pinned, c.PublicKeyFingerprint)
+	}
+
+	other := newTestKey(t)
+	c.PublicKey = other.public
+	c.Signature = other.sign(c)
+	if err := VerifyCanary(context.Background(), kr, c); err != ErrPublicKeyPinned {
+		t.Fatalf("expected %v, got %v", ErrPublicKeyPinned, err)
+	}
+
+	c.PublicKey, c.Signature = "", ""
+	if err := VerifyCanary(context.Background(), kr, c); err != ErrSignatureRequired {
+		t.Fatalf("expected %v, got %v", ErrSignatureRequired, err)
+	}
+}
+
+func TestVerifyCanaryPGP(t *testing.T) {
+	entity, err := openpgp.NewEntity("canary", "", "canary@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var armoredKey bytes.Buffer
+	w, err := armor.Encode(&armoredKey, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := entity.Serialize(w); err != nil {
+		t.Fatal(err)
+	}
+
+	w.Close()
+	c := newSignedCanary()
+	c.PublicKey = armoredKey.String()
+	var signature bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&signature, entity, bytes.NewReader(c.SignedContent()), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Signature = signature.String()
+	kr := NewKeyResolver(configuration.SignaturesConfig{}, nil)
+	if err := VerifyCanary(context.Background(), kr, c); err != nil {
+		t.Fatal(err)
+	} else if !c.Verified || len(c.PublicKeyFingerprint) != 40 {
+		t.Fatalf("expected a verified canary pinned to the key, got %v %q", c.Verified, c.PublicKeyFingerprint)
+	}
+
+	c.Message = "all is well"
+	if err := VerifyCanary(context.Background(), kr, c); err != ErrSignatureMismatch {
+		t.Fatalf("expected %v, got %v", ErrSignatureMismatch, err)
+	}
+}
+
+func TestKeyResolverCache(t *testing.T) {
+	key := newTestKey(t)
+	kr := NewKeyResolver(configuration.SignaturesConfig{KeyCacheTTL: time.Minute}, nil)
+	url := "https://keys.example.com/canary.pub"
+	kr.cache[url] = cachedKey{key: key.public, fetchedAt: time.Now()}
+
+	c := newSignedCanary()
+	c.PublicKeyUrl = url
+	c.Signature = key.sign(c)
+	if err := VerifyCanary(context.Background(), kr, c); err != nil {
+		t.Fatalf("expected the cached key to be used, got %v", err)
+	}
+
+	// a key given inline wins over the url.
+	other := newTestKey(t)
+	c.PublicKey = other.public
+	if resolved, err := kr.Resolve(context.Background(), c); err != nil || resolved != other.public {
+		t.Fatalf("expected the inline key, got %q, %v", resolved, err)
+	}
+}
@@ -2,18 +2,17 @@ package actions
 
 import (
 	"bytes"
-	"crypto/tls"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
-	"net/url"
-	"reflect"
+	"time"
 
 	"github.com/danielkrainas/canaria-api/common"
 	"github.com/danielkrainas/canaria-api/context"
-	"github.com/danielkrainas/canaria-api/uuid"
+	"github.com/danielkrainas/canaria-api/notifier"
+	"github.com/danielkrainas/canaria-api/storage"
 )
 
 var (
@@ -24,122 +23,123 @@ var (
 	HookUserAgent = "Canary-Hooker/0.0.1"
 )
 
-func Notify(ctx context.Context, wh *common.WebHook, c *common.Canary, eventType string) error {
-	deliveryID := uuid.Generate()
-	n := &common.WebHookNotification{
-		Action: eventType,
-		Canary: c,
-	}
+// MaxRecordedResponseBody bounds how much of a receiver's response is kept in
+// the delivery history.
+const MaxRecordedResponseBody = 4096
 
-	req, err := http.NewRequest(http.MethodPost, wh.Url, nil)
+// Notify delivers an event right away, without retries, and records the
+// attempt in the delivery history.
+func Notify(ctx context.Context, ns notifier.Set, ds storage.DeliveryStorage, wh *common.WebHook, c *common.Canary, eventType string) error {
+	d := common.NewDelivery(wh, c, eventType)
+	err := Deliver(ctx, ns, d)
 	if err != nil {
-		context.GetLogger(ctx).Errorf("WebHook.Notify: error creating request: %v", err)
-		wh.Deactivate()
-		return err
+		d.Failed(err, 0, 1)
+	} else {
+		d.Succeeded()
 	}
 
-	req.Header.Set(HookEventHeader, eventType)
-	req.Header.Set(HookDeliveryHeader, deliveryID)
-	req.Header.Set("Content-Type", "application/json; charset=utf-8")
-	req.Header.Set("User-Agent", HookUserAgent)
+	if err := ds.Store(ctx, d); err != nil {
+		context.GetLogger(ctx).Errorf("error storing delivery: %v", err)
+	}
 
-	if wh.ContentType == common.FormContent {
-		form, err := formEncode(n, "")
-		if err != nil {
-			wh.Deactivate()
-			return err
-		}
+	return err
+}
 
-		req.Form = form
-	} else {
-		encoded, err := json.Marshal(n)
-		if err != nil {
-			wh.Deactivate()
-			return err
-		}
+// Deliver makes one attempt at sending the delivery with the hook's notifier
+// and records it in the delivery's history. Updating the delivery status is
+// left to the caller.
+func Deliver(ctx context.Context, ns notifier.Set, d *common.Delivery) error {
+	a := &common.DeliveryAttempt{
+		Attempt:   d.Attempts + 1,
+		Timestamp: time.Now().Unix(),
+	}
 
-		req.Body = ioutil.NopCloser(bytes.NewBuffer(encoded))
+	n, err := ns.For(d.Hook)
+	if err == nil {
+		err = n.Notify(ctx, d, a)
 	}
 
-	client := &http.Client{}
-	if wh.InsecureSSL {
-		client.Transport = &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
-		}
+	if err != nil {
+		a.Error = err.Error()
 	}
 
-	res, err := client.Do(req)
-	defer res.Body.Close()
-	if err != nil || res.StatusCode >= 300 {
-		if err == nil {
-			err = fmt.Errorf("unexpected status \"%d %s\" encountered", res.StatusCode, res.Status)
-		}
+	d.Record(a)
+	return err
+}
+
+type webhookNotifier struct {
+	policy *common.HookPolicy
+	client *HookClient
+}
+
+var _ notifier.Notifier = &webhookNotifier{}
 
+// NewWebhookNotifier returns the notifier for hooks of the webhook type, which
+// POST events to the hook's url within the outbound policy.
+func NewWebhookNotifier(policy *common.HookPolicy) notifier.Notifier {
+	return &webhookNotifier{
+		policy: policy,
+		client: NewHookClient(policy),
+	}
+}
+
+func (wn *webhookNotifier) Validate(wh *common.WebHook) error {
+	if wh.Url == "" {
+		return errors.New("url is required")
+	} else if err := wn.policy.CheckURL(wh.Url); err != nil {
 		return err
+	} else if wh.InsecureSSL && wn.policy != nil && wn.policy.ForbidInsecureSSL {
+		return ErrInsecureSSLForbidden
 	}
 
-	return nil
+	return validatePayload(wh)
 }
 
-func copyFormValues(dest url.Values, src url.Values) {
-	for k, v := range src {
-		dest.Set(k, v[0])
+func (wn *webhookNotifier) Notify(ctx context.Context, d *common.Delivery, a *common.DeliveryAttempt) error {
+	wh := d.Hook
+	a.Url = wh.Url
+
+	body, contentType, err := buildPayload(d)
+	if err != nil {
+		return err
 	}
-}
 
-func formEncode(data interface{}, nestPath string) (url.Values, error) {
-	t := reflect.TypeOf(data)
-	v := reflect.ValueOf(data)
-	if t.Kind() == reflect.Ptr {
-		t = t.Elem()
-		v = v.Elem()
+	req, err := http.NewRequest(http.MethodPost, wh.Url, bytes.NewReader(body))
+	if err != nil {
+		context.GetLogger(ctx).Errorf("WebHook.Notify: error creating request: %v", err)
+		return err
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set(HookEventHeader, d.Event)
+	req.Header.Set(HookDeliveryHeader, d.ID)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", HookUserAgent)
+
+	if err := signRequest(req, wh, body); err != nil {
+		return err
+	}
+
+	a.RequestHeaders = req.Header.Clone()
+	a.RequestBody = recordedPayload(d, body)
+
+	start := time.Now()
+	res, err := wn.client.Do(req, wh.InsecureSSL)
+	if err != nil {
+		a.Duration = time.Since(start).Nanoseconds() / int64(time.Millisecond)
+		return err
 	}
 
-	form := url.Values{}
-	for i := 0; i < t.NumField(); i++ {
-		p := t.Field(i)
-		if !p.Anonymous {
-			name := p.Tag.Get("json")
-			if len(nestPath) > 0 {
-				name = fmt.Sprintf("%s.%s", nestPath, name)
-			}
-
-			switch p.Type.Kind() {
-			case reflect.Array:
-				af := v.Field(i)
-				for j := 0; j < af.Len(); j++ {
-					itemValue := af.Index(j)
-					if af.Type().Kind() == reflect.Struct {
-						return url.Values{}, errors.New("complex objects are not supported at this time.")
-					}
-
-					itemForm, err := formEncode(itemValue, name)
-					if err != nil {
-						return url.Values{}, err
-					}
-
-					copyFormValues(form, itemForm)
-				}
-
-				break
-
-			case reflect.Struct:
-				otherForm, err := formEncode(v.Field(i).Interface(), nestPath)
-				if err != nil {
-					return url.Values{}, err
-				}
-
-				copyFormValues(form, otherForm)
-				break
-
-			default:
-				form.Set(p.Tag.Get("json"), v.Field(i).String())
-				break
-			}
-		}
+	defer res.Body.Close()
+	resBody, err := ioutil.ReadAll(io.LimitReader(res.Body, MaxRecordedResponseBody))
+	a.Duration = time.Since(start).Nanoseconds() / int64(time.Millisecond)
+	a.ResponseStatus = res.StatusCode
+	a.ResponseBody = string(resBody)
+	if err != nil {
+		return err
+	} else if res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status \"%s\" encountered", res.Status)
 	}
 
-	return form, nil
+	return nil
 }
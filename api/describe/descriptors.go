@@ -17,7 +17,11 @@ type RouteDescriptor struct {
 type MethodDescriptor struct {
 	Method      string
 	Description string
-	Requests    []RequestDescriptor
+
+	// Scopes are what a token needs to be granted to use the method, in the
+	// type:name:actions form of challenges.
+	Scopes   []string
+	Requests []RequestDescriptor
 }
 
 type RequestDescriptor struct {
@@ -11,6 +11,8 @@ import (
 var (
 	IdRegex = regexp.MustCompile(`(?i)[a-f0-9]{8}-[a-f0-9]{4}-4[a-f0-9]{3}-[89aAbB][a-f0-9]{3}-[a-f0-9]{12}`)
 
+	UserNameRegex = regexp.MustCompile(`[A-Za-z0-9._@+-]+`)
+
 	uuidParameter = describe.ParameterDescriptor{
 		Name:        "uuid",
 		Type:        "string",
@@ -76,6 +78,24 @@ var (
 		},
 	}
 
+	deniedResponseDescriptor = describe.ResponseDescriptor{
+		Name:        "Access Denied",
+		StatusCode:  http.StatusForbidden,
+		Description: "The client is authenticated but the strategy doesn't allow the requested access, or it is not the canary's owner or, where that is enough, one of its collaborators. Holders of registry:admin:* may act on canaries they don't own.",
+		Headers: []describe.ParameterDescriptor{
+			jsonContentLengthHeader,
+		},
+
+		Body: describe.BodyDescriptor{
+			ContentType: "application/json; charset=utf-8",
+			Format:      errorsBody,
+		},
+
+		ErrorCodes: []errcode.ErrorCode{
+			errcode.ErrorCodeDenied,
+		},
+	}
+
 	canaryNotFoundResponseDescriptor = describe.ResponseDescriptor{
 		Name:        "No Such Canary Error",
 		StatusCode:  http.StatusNotFound,
@@ -119,6 +139,155 @@ var (
 	canaryRequestBody = ``
 
 	canaryBody = ``
+
+	canaryPatchBody = `{
+    "title": "<title>",
+    "message": "<message>",
+    "labels": ["<label>", ...],
+    "ttl": <seconds>,
+    "signature": "<signature>",
+    "warnings": [<seconds before expiry>, ...]
+}`
+
+	canaryHistoryBody = `{
+    "revisions": [
+        {
+            "id": "<uuid>",
+            "canary_id": "<uuid>",
+            "sequence": <integer>,
+            "action": "created|refreshed|updated|killed|unpinned|transferred|granted|revoked",
+            "revision": <integer>,
+            "timestamp": <unix seconds>,
+            "ttl": <seconds>,
+            "actor": "<username>",
+            "remote_addr": "<ip>",
+            "member": "<username>",
+            "content_hash": "<sha256 hex>",
+            "previous_token_hash": "<sha256 hex>"
+        },
+        ...
+    ],
+    "next": "<cursor>"
+}`
+
+	canaryChainBody = `{
+    "canary_id": "<uuid>",
+    "head": "<sha256 hex>",
+    "entries": [
+        {
+            "canary_id": "<uuid>",
+            "sequence": <integer>,
+            "action": "created|refreshed|updated|killed|unpinned|transferred|granted|revoked",
+            "title": "<title>",
+            "message": "<message>",
+            "labels": ["<label>", ...],
+            "ttl": <seconds>,
+            "updated_at": <unix seconds>,
+            "signature": "<signature>",
+            "previous_hash": "<sha256 hex>",
+            "hash": "<sha256 hex>"
+        },
+        ...
+    ],
+    "next": "<cursor>"
+}`
+
+	deliveryBody = `{
+    "id": "<uuid>",
+    "event": "<event>",
+    "hook_id": "<uuid>",
+    "canary_id": "<uuid>",
+    "canary": <canary>,
+    "status": "pending|delivered|failed",
+    "attempts": <integer>,
+    "created_at": <unix seconds>,
+    "last_attempt_at": <unix seconds>,
+    "next_attempt_at": <unix seconds>,
+    "last_error": "<error>",
+    "redelivery_of": "<uuid>",
+    "history": [
+        {
+            "attempt": <integer>,
+            "timestamp": <unix seconds>,
+            "url": "<url>",
+            "request_headers": {"<name>": ["<value>", ...], ...},
+            "request_body": "<body>",
+            "response_status": <status code>,
+            "response_body": "<first 4096 bytes of the response>",
+            "duration_ms": <milliseconds>,
+            "error": "<error>"
+        },
+        ...
+    ]
+}`
+
+	collaboratorsBody = `{
+    "owner": "<username>",
+    "collaborators": ["<username>", ...]
+}`
+
+	ownerBody = `{
+    "owner": "<username>"
+}`
+
+	deliveryListBody = `{
+    "deliveries": [
+        <delivery>,
+        ...
+    ],
+    "next": "<cursor>"
+}`
+
+	tokenBody = `{
+    "token": "<jwt>",
+    "access_token": "<jwt>",
+    "expires_in": <seconds>,
+    "issued_at": "<RFC 3339 timestamp>"
+}`
+
+	hookListBody = `{
+    "hooks": [
+        {
+            "id": "<uuid>",
+            "name": "<name>",
+            "type": "webhook|smtp|command|file",
+            "config": {
+                "url": "<url>",
+                "content_type": "json|form|template|slack|discord|pagerduty|cloudevents",
+                "template": "<text/template>",
+                "signature_algorithm": "sha1|sha256|sha512",
+                "insecure_ssl": <boolean>,
+                "has_secret": <boolean>,
+                "secret_fingerprint": "<uuid>",
+                "options": {"<name>": "<value>", ...}
+            },
+            "events": ["<event>", ...],
+            "active": <boolean>,
+            "consecutive_failures": <integer>,
+            "disabled_at": <unix seconds>,
+            "updated_at": <unix seconds>,
+            "last_delivery": {
+                "id": "<uuid>",
+                "event": "<event>",
+                "status": "pending|delivered|failed",
+                "attempts": <integer>,
+                "created_at": <unix seconds>,
+                "last_attempt_at": <unix seconds>,
+                "last_error": "<error>"
+            }
+        },
+        ...
+    ],
+    "next": "<cursor>"
+}`
+
+	canaryListBody = `{
+    "canaries": [
+        <canary>,
+        ...
+    ],
+    "next": "<cursor>"
+}`
 )
 
 var APIDescriptor = struct {
@@ -132,11 +301,12 @@ var routeDescriptors = []describe.RouteDescriptor{
 		Name:        RouteNameBase,
 		Path:        "/v1",
 		Entity:      "Base",
-		Description: "Base V1 API route, can be used for lightweight version checks and to validate authentication.",
+		Description: "Base V1 API route, can be used for lightweight version checks and to validate authentication. It needs the catalog list scope.",
 		Methods: []describe.MethodDescriptor{
 			{
 				Method:      "GET",
 				Description: "Check that the server supports the Canaria V1 API.",
+				Scopes:      []string{"registry:catalog:list"},
 				Requests: []describe.RequestDescriptor{
 					{
 						Headers: []describe.ParameterDescriptor{
@@ -172,9 +342,104 @@ var routeDescriptors = []describe.RouteDescriptor{
 		Entity:      "Canary",
 		Description: "",
 		Methods: []describe.MethodDescriptor{
+			{
+				Method:      "GET",
+				Description: "List canaries known to the server, optionally filtered by state and labels. Only the canaries the client may read are listed, so a page may hold fewer than the limit and still have a next one. Owners are only shown to members of each canary and admins.",
+				Scopes:      []string{"registry:catalog:list"},
+				Requests: []describe.RequestDescriptor{
+					{
+						Name:        "Canary List",
+						Description: "Return a page of canaries.",
+						Headers: []describe.ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+
+						QueryParameters: []describe.ParameterDescriptor{
+							{
+								Name:        "state",
+								Type:        "string",
+								Description: "Comma separated list of canary states to include.",
+								Format:      "alive|zombie|dead[,...]",
+								Examples:    []string{"alive,zombie"},
+							},
+							{
+								Name:        "labels",
+								Type:        "string",
+								Description: "Label selector, a comma separated list of requirements using the =, ==, !=, in, notin and existence operators.",
+								Format:      "<selector>",
+								Examples:    []string{"env=prod,region in (eu,us),!legacy"},
+							},
+							{
+								Name:        "sort",
+								Type:        "string",
+								Description: "Field the results are ordered by.",
+								Format:      "updated_at|expires_at",
+							},
+							{
+								Name:        "order",
+								Type:        "string",
+								Description: "Sort direction.",
+								Format:      "asc|desc",
+							},
+							{
+								Name:        "limit",
+								Type:        "integer",
+								Description: "Maximum number of canaries to return.",
+								Format:      "<integer>",
+							},
+							{
+								Name:        "cursor",
+								Type:        "string",
+								Description: "Opaque cursor returned by the previous page.",
+								Format:      "<cursor>",
+							},
+						},
+
+						Successes: []describe.ResponseDescriptor{
+							{
+								Description: "A page of canaries matching the query.",
+								StatusCode:  http.StatusOK,
+								Headers: []describe.ParameterDescriptor{
+									jsonContentLengthHeader,
+									{
+										Name:        "Link",
+										Type:        "link",
+										Description: "RFC5988 compliant rel='next' with URL to the next page. Only present when more results are available.",
+										Format:      `<<url>?cursor=<cursor>>; rel="next"`,
+									},
+								},
+
+								Body: describe.BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      canaryListBody,
+								},
+							},
+						},
+
+						Failures: []describe.ResponseDescriptor{
+							{
+								Name:        "Invalid Query",
+								Description: "The query parameters were malformed.",
+								StatusCode:  http.StatusBadRequest,
+								Body: describe.BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeQueryInvalid,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+						},
+					},
+				},
+			},
 			{
 				Method:      "PUT",
 				Description: "",
+				Scopes:      []string{"registry:catalog:create"},
 				Requests: []describe.RequestDescriptor{
 					{
 						Headers: []describe.ParameterDescriptor{
@@ -223,9 +488,11 @@ var routeDescriptors = []describe.RouteDescriptor{
 								},
 								ErrorCodes: []errcode.ErrorCode{
 									ErrorCodeTTLInvalid,
+									ErrorCodeSignatureInvalid,
 								},
 							},
 							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
 						},
 					},
 				},
@@ -241,10 +508,11 @@ var routeDescriptors = []describe.RouteDescriptor{
 			{
 				Method:      "GET",
 				Description: "",
+				Scopes:      []string{"canary:<canary_id>:read"},
 				Requests: []describe.RequestDescriptor{
 					{
 						Name:        "Canary",
-						Description: "Return a canary",
+						Description: "Return a canary. Its owner is only shown to members of the canary and admins.",
 						Headers: []describe.ParameterDescriptor{
 							hostHeader,
 							authHeader,
@@ -279,6 +547,7 @@ var routeDescriptors = []describe.RouteDescriptor{
 			{
 				Method:      "HEAD",
 				Description: "",
+				Scopes:      []string{"canary:<canary_id>:read"},
 				Requests: []describe.RequestDescriptor{
 					{
 						Name:        "CanaryCheck",
@@ -328,9 +597,67 @@ var routeDescriptors = []describe.RouteDescriptor{
 					},
 				},
 			},
+			{
+				Method:      "POST",
+				Description: "Refresh a canary and optionally change its content. An empty body only refreshes the canary, otherwise the body is applied as a JSON merge patch and recorded as a new revision.",
+				Scopes:      []string{"canary:<canary_id>:read,write"},
+				Requests: []describe.RequestDescriptor{
+					{
+						Headers: []describe.ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+
+						PathParameters: []describe.ParameterDescriptor{
+							uuidParameter,
+						},
+
+						Body: describe.BodyDescriptor{
+							ContentType: "application/merge-patch+json",
+							Format:      canaryPatchBody,
+						},
+
+						Successes: []describe.ResponseDescriptor{
+							{
+								StatusCode: http.StatusOK,
+								Headers: []describe.ParameterDescriptor{
+									jsonContentLengthHeader,
+								},
+
+								Body: describe.BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      canaryBody,
+								},
+							},
+						},
+
+						Failures: []describe.ResponseDescriptor{
+							{
+								Name:        "Invalid Update",
+								Description: "The update token was stale or the patched canary was invalid.",
+								StatusCode:  http.StatusBadRequest,
+								Body: describe.BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeUpdateTokenInvalid,
+									ErrorCodeCanaryInvalid,
+									ErrorCodeSignatureInvalid,
+								},
+							},
+							deadResponseDescriptor,
+							canaryNotFoundResponseDescriptor,
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+						},
+					},
+				},
+			},
 			{
 				Method:      "DELETE",
 				Description: "",
+				Scopes:      []string{"canary:<canary_id>:kill,write"},
 				Requests: []describe.RequestDescriptor{
 					{
 						Headers: []describe.ParameterDescriptor{
@@ -355,6 +682,7 @@ var routeDescriptors = []describe.RouteDescriptor{
 							deadResponseDescriptor,
 							canaryNotFoundResponseDescriptor,
 							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
 						},
 					},
 				},
@@ -362,62 +690,801 @@ var routeDescriptors = []describe.RouteDescriptor{
 		},
 	},
 	{
-		Name:        RouteNameWebhooks,
-		Path:        "/v1/canary/{canary_id:" + IdRegex.String() + "}/hooks",
-		Entity:      "Webhook",
-		Description: "",
+		Name:        RouteNamePin,
+		Path:        "/v1/canary/{canary_id:" + IdRegex.String() + "}/pin",
+		Entity:      "CanaryPin",
+		Description: "The public key fingerprint pinned by the first signature that verified. A pinned canary must stay signed by the same key.",
 		Methods: []describe.MethodDescriptor{
 			{
-				Method:      "PUT",
-				Description: "",
+				Method:      "DELETE",
+				Description: "Unpin the public key so that the canary may be signed by another key or not at all. It takes the canary's current update token, leaves it as it is and records an unpinned revision. Only the owner may unpin a canary.",
+				Scopes:      []string{"canary:<canary_id>:read,write"},
 				Requests: []describe.RequestDescriptor{
 					{
-						Headers: []describe.ParameterDescriptor{},
+						Headers: []describe.ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+
+						PathParameters: []describe.ParameterDescriptor{
+							uuidParameter,
+						},
+
+						Successes: []describe.ResponseDescriptor{
+							{
+								StatusCode: http.StatusOK,
+								Headers: []describe.ParameterDescriptor{
+									jsonContentLengthHeader,
+								},
+
+								Body: describe.BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      canaryBody,
+								},
+							},
+						},
+
+						Failures: []describe.ResponseDescriptor{
+							{
+								Name:        "Invalid Update Token",
+								Description: "The update token was missing or stale.",
+								StatusCode:  http.StatusBadRequest,
+								Body: describe.BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeUpdateTokenInvalid,
+								},
+							},
+							deadResponseDescriptor,
+							canaryNotFoundResponseDescriptor,
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+						},
 					},
 				},
 			},
 		},
 	},
 	{
-		Name:        RouteNameWebhook,
-		Path:        "/v1/canary/{canary_id:" + IdRegex.String() + "}/hooks/{hook_id:" + IdRegex.String() + "}",
-		Entity:      "Webhook",
-		Description: "",
+		Name:        RouteNameHistory,
+		Path:        "/v1/canary/{canary_id:" + IdRegex.String() + "}/history",
+		Entity:      "CanaryHistory",
+		Description: "Immutable record of every create, refresh, update and kill of a canary, and of every change to who owns or collaborates on it.",
 		Methods: []describe.MethodDescriptor{
-			{
-				Method:      "DELETE",
-				Description: "",
-				Requests: []describe.RequestDescriptor{
-					{},
-				},
-			},
-			{
-				Method:      "PATCH",
-				Description: "",
-				Requests: []describe.RequestDescriptor{
-					{},
-				},
-			},
 			{
 				Method:      "GET",
-				Description: "",
+				Description: "List the revisions of a canary. Dead canaries keep their history. The actor, remote address and member of revisions are only shown to members of the canary and admins.",
+				Scopes:      []string{"canary:<canary_id>:read"},
 				Requests: []describe.RequestDescriptor{
-					{},
+					{
+						Headers: []describe.ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+
+						PathParameters: []describe.ParameterDescriptor{
+							uuidParameter,
+						},
+
+						QueryParameters: []describe.ParameterDescriptor{
+							{
+								Name:        "order",
+								Type:        "string",
+								Description: "Sequence order of the revisions.",
+								Format:      "asc|desc",
+							},
+							{
+								Name:        "limit",
+								Type:        "integer",
+								Description: "Maximum number of revisions to return.",
+								Format:      "<integer>",
+							},
+							{
+								Name:        "cursor",
+								Type:        "string",
+								Description: "Opaque cursor returned by the previous page.",
+								Format:      "<cursor>",
+							},
+						},
+
+						Successes: []describe.ResponseDescriptor{
+							{
+								Description: "A page of canary revisions.",
+								StatusCode:  http.StatusOK,
+								Headers: []describe.ParameterDescriptor{
+									jsonContentLengthHeader,
+									{
+										Name:        "Link",
+										Type:        "link",
+										Description: "RFC5988 compliant rel='next' with URL to the next page. Only present when more results are available.",
+										Format:      `<<url>?cursor=<cursor>>; rel="next"`,
+									},
+								},
+
+								Body: describe.BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      canaryHistoryBody,
+								},
+							},
+						},
+
+						Failures: []describe.ResponseDescriptor{
+							{
+								Name:        "Invalid Query",
+								Description: "The query parameters were malformed.",
+								StatusCode:  http.StatusBadRequest,
+								Body: describe.BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeQueryInvalid,
+								},
+							},
+							canaryNotFoundResponseDescriptor,
+							unauthorizedResponseDescriptor,
+						},
+					},
 				},
 			},
 		},
 	},
 	{
-		Name:        RouteNameWebhookTest,
-		Path:        "/v1/canary/{canary_id:" + IdRegex.String() + "}/hooks/{hook_id:" + IdRegex.String() + "}/ping",
-		Entity:      "Webhook",
-		Description: "",
+		Name:        RouteNameChain,
+		Path:        "/v1/canary/{canary_id:" + IdRegex.String() + "}/chain",
+		Entity:      "CanaryChain",
+		Description: "Hash chained log of every published state of a canary. Each entry's hash is the sha256 of the entry serialized as compact JSON with an empty hash field, and links to the hash of the entry before it.",
 		Methods: []describe.MethodDescriptor{
 			{
 				Method:      "GET",
-				Description: "",
+				Description: "List the entries of the chain a page at a time, with the hash of its latest entry.",
+				Scopes:      []string{"canary:<canary_id>:read"},
 				Requests: []describe.RequestDescriptor{
-					{},
+					{
+						Headers: []describe.ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+
+						PathParameters: []describe.ParameterDescriptor{
+							uuidParameter,
+						},
+
+						QueryParameters: []describe.ParameterDescriptor{
+							{
+								Name:        "order",
+								Type:        "string",
+								Description: "Sequence order of the entries.",
+								Format:      "asc|desc",
+							},
+							{
+								Name:        "limit",
+								Type:        "integer",
+								Description: "Maximum number of entries to return.",
+								Format:      "<integer>",
+							},
+							{
+								Name:        "cursor",
+								Type:        "string",
+								Description: "Opaque cursor returned by the previous page.",
+								Format:      "<cursor>",
+							},
+						},
+
+						Successes: []describe.ResponseDescriptor{
+							{
+								Description: "A page of chain entries.",
+								StatusCode:  http.StatusOK,
+								Headers: []describe.ParameterDescriptor{
+									jsonContentLengthHeader,
+									{
+										Name:        "X-Canary-Chain-Head",
+										Type:        "string",
+										Description: "Hash of the latest entry in the chain, whichever page is returned.",
+										Format:      "<sha256 hex>",
+									},
+									{
+										Name:        "Link",
+										Type:        "link",
+										Description: "RFC5988 compliant rel='next' with URL to the next page. Only present when more results are available.",
+										Format:      `<<url>?cursor=<cursor>>; rel="next"`,
+									},
+								},
+
+								Body: describe.BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      canaryChainBody,
+								},
+							},
+						},
+
+						Failures: []describe.ResponseDescriptor{
+							{
+								Name:        "Invalid Query",
+								Description: "The query parameters were malformed.",
+								StatusCode:  http.StatusBadRequest,
+								Body: describe.BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeQueryInvalid,
+								},
+							},
+							canaryNotFoundResponseDescriptor,
+							unauthorizedResponseDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameOwner,
+		Path:        "/v1/canary/{canary_id:" + IdRegex.String() + "}/owner",
+		Entity:      "CanaryOwner",
+		Description: "The user that owns a canary. Canaries record the user that created them as their owner when auth is enabled. Only the owner and collaborators may refresh, update or kill an owned canary and manage its hooks, only the owner may change who those are.",
+		Methods: []describe.MethodDescriptor{
+			{
+				Method:      "PUT",
+				Description: "Transfer the canary to another user. The previous owner keeps no access unless the new owner grants it. A canary created without auth has no owner, only admins may change it or give it one.",
+				Scopes:      []string{"canary:<canary_id>:read,write"},
+				Requests: []describe.RequestDescriptor{
+					{
+						Headers: []describe.ParameterDescriptor{
+							hostHeader,
+							authHeader,
+							jsonContentLengthHeader,
+						},
+
+						PathParameters: []describe.ParameterDescriptor{
+							uuidParameter,
+						},
+
+						Body: describe.BodyDescriptor{
+							ContentType: "application/json; charset=utf-8",
+							Format:      ownerBody,
+						},
+
+						Successes: []describe.ResponseDescriptor{
+							{
+								Description: "The canary was transferred.",
+								StatusCode:  http.StatusOK,
+								Headers: []describe.ParameterDescriptor{
+									jsonContentLengthHeader,
+								},
+
+								Body: describe.BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      collaboratorsBody,
+								},
+							},
+						},
+
+						Failures: []describe.ResponseDescriptor{
+							{
+								Name:        "Invalid Owner",
+								Description: "The new owner is not a valid user name.",
+								StatusCode:  http.StatusBadRequest,
+								Body: describe.BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeCanaryInvalid,
+								},
+							},
+							deadResponseDescriptor,
+							canaryNotFoundResponseDescriptor,
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameCollaborators,
+		Path:        "/v1/canary/{canary_id:" + IdRegex.String() + "}/collaborators",
+		Entity:      "CanaryCollaborators",
+		Description: "Users other than the owner that may refresh, update and kill a canary and manage its hooks.",
+		Methods: []describe.MethodDescriptor{
+			{
+				Method:      "GET",
+				Description: "Get the owner and collaborators of a canary. Only they may see the collaborators of an owned canary.",
+				Scopes:      []string{"canary:<canary_id>:read"},
+				Requests: []describe.RequestDescriptor{
+					{
+						Headers: []describe.ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+
+						PathParameters: []describe.ParameterDescriptor{
+							uuidParameter,
+						},
+
+						Successes: []describe.ResponseDescriptor{
+							{
+								StatusCode: http.StatusOK,
+								Headers: []describe.ParameterDescriptor{
+									jsonContentLengthHeader,
+								},
+
+								Body: describe.BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      collaboratorsBody,
+								},
+							},
+						},
+
+						Failures: []describe.ResponseDescriptor{
+							deadResponseDescriptor,
+							canaryNotFoundResponseDescriptor,
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameCollaborator,
+		Path:        "/v1/canary/{canary_id:" + IdRegex.String() + "}/collaborators/{user:" + UserNameRegex.String() + "}",
+		Entity:      "CanaryCollaborators",
+		Description: "A single collaborator of a canary. Only the owner may grant or revoke access.",
+		Methods: []describe.MethodDescriptor{
+			{
+				Method:      "PUT",
+				Description: "Grant a user access to the canary. Granting an existing collaborator again does nothing.",
+				Scopes:      []string{"canary:<canary_id>:read,write"},
+				Requests: []describe.RequestDescriptor{
+					{
+						Headers: []describe.ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+
+						PathParameters: []describe.ParameterDescriptor{
+							uuidParameter,
+						},
+
+						Successes: []describe.ResponseDescriptor{
+							{
+								StatusCode: http.StatusOK,
+								Headers: []describe.ParameterDescriptor{
+									jsonContentLengthHeader,
+								},
+
+								Body: describe.BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      collaboratorsBody,
+								},
+							},
+						},
+
+						Failures: []describe.ResponseDescriptor{
+							{
+								Name:        "Invalid Collaborator",
+								Description: "The user is the owner, the canary has no owner or it already has the maximum number of collaborators.",
+								StatusCode:  http.StatusBadRequest,
+								Body: describe.BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeCanaryInvalid,
+								},
+							},
+							deadResponseDescriptor,
+							canaryNotFoundResponseDescriptor,
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+						},
+					},
+				},
+			},
+			{
+				Method:      "DELETE",
+				Description: "Revoke a collaborator's access to the canary.",
+				Scopes:      []string{"canary:<canary_id>:read,write"},
+				Requests: []describe.RequestDescriptor{
+					{
+						Headers: []describe.ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+
+						PathParameters: []describe.ParameterDescriptor{
+							uuidParameter,
+						},
+
+						Successes: []describe.ResponseDescriptor{
+							{
+								StatusCode: http.StatusOK,
+								Headers: []describe.ParameterDescriptor{
+									jsonContentLengthHeader,
+								},
+
+								Body: describe.BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      collaboratorsBody,
+								},
+							},
+						},
+
+						Failures: []describe.ResponseDescriptor{
+							{
+								Name:        "Unknown Collaborator",
+								Description: "The user is not a collaborator of the canary.",
+								StatusCode:  http.StatusBadRequest,
+								Body: describe.BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeCanaryInvalid,
+								},
+							},
+							deadResponseDescriptor,
+							canaryNotFoundResponseDescriptor,
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameWebhooks,
+		Path:        "/v1/canary/{canary_id:" + IdRegex.String() + "}/hooks",
+		Entity:      "Webhook",
+		Description: "",
+		Methods: []describe.MethodDescriptor{
+			{
+				Method:      "GET",
+				Description: "List the hooks registered on a canary ordered by id. Secrets are never returned, only whether one is set.",
+				Scopes:      []string{"hook:<canary_id>:read"},
+				Requests: []describe.RequestDescriptor{
+					{
+						Headers: []describe.ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+
+						PathParameters: []describe.ParameterDescriptor{
+							uuidParameter,
+						},
+
+						QueryParameters: []describe.ParameterDescriptor{
+							{
+								Name:        "limit",
+								Type:        "integer",
+								Description: "Maximum number of hooks to return.",
+								Format:      "<integer>",
+							},
+							{
+								Name:        "cursor",
+								Type:        "string",
+								Description: "Opaque cursor returned by the previous page.",
+								Format:      "<cursor>",
+							},
+						},
+
+						Successes: []describe.ResponseDescriptor{
+							{
+								Description: "A page of hook summaries, each with the status of its most recent delivery.",
+								StatusCode:  http.StatusOK,
+								Headers: []describe.ParameterDescriptor{
+									jsonContentLengthHeader,
+									{
+										Name:        "Link",
+										Type:        "link",
+										Description: "RFC5988 compliant rel='next' with URL to the next page. Only present when more results are available.",
+										Format:      `<<url>?cursor=<cursor>>; rel="next"`,
+									},
+								},
+
+								Body: describe.BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      hookListBody,
+								},
+							},
+						},
+
+						Failures: []describe.ResponseDescriptor{
+							{
+								Name:        "Invalid Query",
+								Description: "The query parameters were malformed.",
+								StatusCode:  http.StatusBadRequest,
+								Body: describe.BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeQueryInvalid,
+								},
+							},
+							canaryNotFoundResponseDescriptor,
+							unauthorizedResponseDescriptor,
+						},
+					},
+				},
+			},
+			{
+				Method:      "PUT",
+				Description: "",
+				Scopes:      []string{"hook:<canary_id>:read,write"},
+				Requests: []describe.RequestDescriptor{
+					{
+						Headers: []describe.ParameterDescriptor{},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameWebhook,
+		Path:        "/v1/canary/{canary_id:" + IdRegex.String() + "}/hooks/{hook_id:" + IdRegex.String() + "}",
+		Entity:      "Webhook",
+		Description: "",
+		Methods: []describe.MethodDescriptor{
+			{
+				Method:      "DELETE",
+				Description: "",
+				Scopes:      []string{"hook:<canary_id>:read,write"},
+				Requests: []describe.RequestDescriptor{
+					{},
+				},
+			},
+			{
+				Method:      "PATCH",
+				Description: "",
+				Scopes:      []string{"hook:<canary_id>:read,write"},
+				Requests: []describe.RequestDescriptor{
+					{},
+				},
+			},
+			{
+				Method:      "GET",
+				Description: "",
+				Scopes:      []string{"hook:<canary_id>:read"},
+				Requests: []describe.RequestDescriptor{
+					{},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameWebhookTest,
+		Path:        "/v1/canary/{canary_id:" + IdRegex.String() + "}/hooks/{hook_id:" + IdRegex.String() + "}/ping",
+		Entity:      "Webhook",
+		Description: "",
+		Methods: []describe.MethodDescriptor{
+			{
+				Method:      "GET",
+				Description: "",
+				Scopes:      []string{"hook:<canary_id>:read,write"},
+				Requests: []describe.RequestDescriptor{
+					{},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameDeliveries,
+		Path:        "/v1/canary/{canary_id:" + IdRegex.String() + "}/hooks/{hook_id:" + IdRegex.String() + "}/deliveries",
+		Entity:      "Delivery",
+		Description: "Recorded deliveries of a hook, including every attempt made and the receiver's response. Finished deliveries are pruned according to the storage driver's deliveryretention and maxdeliveries parameters.",
+		Methods: []describe.MethodDescriptor{
+			{
+				Method:      "GET",
+				Description: "List the deliveries of a hook, newest first.",
+				Scopes:      []string{"hook:<canary_id>:read"},
+				Requests: []describe.RequestDescriptor{
+					{
+						Headers: []describe.ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+
+						PathParameters: []describe.ParameterDescriptor{
+							uuidParameter,
+						},
+
+						QueryParameters: []describe.ParameterDescriptor{
+							{
+								Name:        "limit",
+								Type:        "integer",
+								Description: "Maximum number of deliveries to return.",
+								Format:      "<integer>",
+							},
+							{
+								Name:        "cursor",
+								Type:        "string",
+								Description: "Opaque cursor returned by the previous page.",
+								Format:      "<cursor>",
+							},
+						},
+
+						Successes: []describe.ResponseDescriptor{
+							{
+								Description: "A page of deliveries.",
+								StatusCode:  http.StatusOK,
+								Headers: []describe.ParameterDescriptor{
+									jsonContentLengthHeader,
+									{
+										Name:        "Link",
+										Type:        "link",
+										Description: "RFC5988 compliant rel='next' with URL to the next page. Only present when more results are available.",
+										Format:      `<<url>?cursor=<cursor>>; rel="next"`,
+									},
+								},
+
+								Body: describe.BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      deliveryListBody,
+								},
+							},
+						},
+
+						Failures: []describe.ResponseDescriptor{
+							{
+								Name:        "Invalid Query",
+								Description: "The query parameters were malformed.",
+								StatusCode:  http.StatusBadRequest,
+								Body: describe.BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeQueryInvalid,
+								},
+							},
+							canaryNotFoundResponseDescriptor,
+							unauthorizedResponseDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameRedeliver,
+		Path:        "/v1/canary/{canary_id:" + IdRegex.String() + "}/hooks/{hook_id:" + IdRegex.String() + "}/deliveries/{delivery_id:" + IdRegex.String() + "}/redeliver",
+		Entity:      "Delivery",
+		Description: "Send the payload of an earlier delivery again.",
+		Methods: []describe.MethodDescriptor{
+			{
+				Method:      "POST",
+				Description: "Queue a new delivery of the original payload to the hook's current configuration. The new delivery references the original through redelivery_of.",
+				Scopes:      []string{"hook:<canary_id>:read,write"},
+				Requests: []describe.RequestDescriptor{
+					{
+						Headers: []describe.ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+
+						PathParameters: []describe.ParameterDescriptor{
+							uuidParameter,
+						},
+
+						Successes: []describe.ResponseDescriptor{
+							{
+								Description: "The redelivery was queued.",
+								StatusCode:  http.StatusAccepted,
+								Headers: []describe.ParameterDescriptor{
+									jsonContentLengthHeader,
+								},
+
+								Body: describe.BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      deliveryBody,
+								},
+							},
+						},
+
+						Failures: []describe.ResponseDescriptor{
+							{
+								Name:        "No Such Delivery Error",
+								Description: "The delivery is not known to the server.",
+								StatusCode:  http.StatusNotFound,
+								Body: describe.BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeDeliveryUnknown,
+								},
+							},
+							canaryNotFoundResponseDescriptor,
+							unauthorizedResponseDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameToken,
+		Path:        "/v1/token",
+		Entity:      "Token",
+		Description: "Issues tokens for the token auth strategy when the token server is enabled. This route authenticates clients itself and is not subject to the configured auth strategy.",
+		Methods: []describe.MethodDescriptor{
+			{
+				Method:      "GET",
+				Description: "Get a signed token for the scopes of a challenge. Only the part of each scope that the token server's policy allows for the account is granted.",
+				Requests: []describe.RequestDescriptor{
+					{
+						Headers: []describe.ParameterDescriptor{
+							hostHeader,
+							{
+								Name:        "Authorization",
+								Type:        "string",
+								Description: "The client's credentials, checked by the token server's backend.",
+								Format:      "Basic <credentials>",
+							},
+						},
+
+						QueryParameters: []describe.ParameterDescriptor{
+							{
+								Name:        "service",
+								Type:        "string",
+								Description: "The service from the challenge, it must match the token server's service.",
+								Format:      "<service>",
+							},
+							{
+								Name:        "scope",
+								Type:        "string",
+								Description: "A scope from the challenge. May be repeated or hold several space separated scopes.",
+								Format:      "<type>:<name>:<action>[,<action>...]",
+								Examples:    []string{"canary:1f07fe68-4161-4617-808b-52a0bcf41b39:read,write"},
+							},
+							{
+								Name:        "account",
+								Type:        "string",
+								Description: "The account the token is for, it must match the authenticated user.",
+								Format:      "<account>",
+							},
+						},
+
+						Successes: []describe.ResponseDescriptor{
+							{
+								Description: "The token was issued.",
+								StatusCode:  http.StatusOK,
+								Headers: []describe.ParameterDescriptor{
+									jsonContentLengthHeader,
+								},
+
+								Body: describe.BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      tokenBody,
+								},
+							},
+						},
+
+						Failures: []describe.ResponseDescriptor{
+							{
+								Name:        "Invalid Request",
+								Description: "A scope is malformed, or the service or account doesn't match.",
+								StatusCode:  http.StatusBadRequest,
+								Body: describe.BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeQueryInvalid,
+								},
+							},
+							unauthorizedResponseDescriptor,
+						},
+					},
 				},
 			},
 		},
@@ -44,10 +44,17 @@ var (
 		HttpStatusCode: http.StatusBadRequest,
 	})
 
+	ErrorCodeSignatureInvalid = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:          "SIGNATURE_INVALID",
+		Message:        "signature invalid",
+		Description:    "The canary's signature does not verify against its public key, the key could not be fetched, it does not match the key pinned by an earlier signature or the signature was removed while a key is pinned.",
+		HttpStatusCode: http.StatusBadRequest,
+	})
+
 	ErrorCodeWebhookSetupInvalid = errcode.Register(errGroup, errcode.ErrorDescriptor{
 		Value:          "WEBHOOK_INVALID",
-		Message:        "",
-		Description:    "",
+		Message:        "webhook invalid",
+		Description:    "The webhook configuration is malformed or its target is not allowed by the server's outbound policy.",
 		HttpStatusCode: http.StatusBadRequest,
 	})
 
@@ -64,4 +71,18 @@ var (
 		Description:    "",
 		HttpStatusCode: http.StatusAccepted,
 	})
+
+	ErrorCodeDeliveryUnknown = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:          "DELIVERY_UNKNOWN",
+		Message:        "delivery unknown",
+		Description:    "The delivery does not exist or does not belong to the hook.",
+		HttpStatusCode: http.StatusNotFound,
+	})
+
+	ErrorCodeQueryInvalid = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:          "QUERY_INVALID",
+		Message:        "",
+		Description:    "",
+		HttpStatusCode: http.StatusBadRequest,
+	})
 )
@@ -3,12 +3,21 @@ package v1
 import "github.com/gorilla/mux"
 
 const (
-	RouteNameBase        = "base"
-	RouteNameCanaries    = "canaries"
-	RouteNameCanary      = "canary"
-	RouteNameWebhook     = "webhook"
-	RouteNameWebhooks    = "webhooks"
-	RouteNameWebhookTest = "webhook-test"
+	RouteNameBase          = "base"
+	RouteNameCanaries      = "canaries"
+	RouteNameCanary        = "canary"
+	RouteNameHistory       = "canary-history"
+	RouteNameChain         = "canary-chain"
+	RouteNamePin           = "canary-pin"
+	RouteNameOwner         = "canary-owner"
+	RouteNameCollaborators = "canary-collaborators"
+	RouteNameCollaborator  = "canary-collaborator"
+	RouteNameWebhook       = "webhook"
+	RouteNameWebhooks      = "webhooks"
+	RouteNameWebhookTest   = "webhook-test"
+	RouteNameDeliveries    = "webhook-deliveries"
+	RouteNameRedeliver     = "webhook-redeliver"
+	RouteNameToken         = "token"
 )
 
 func Router() *mux.Router {
@@ -80,6 +80,20 @@ func (ub *URLBuilder) BuildBaseURL() (string, error) {
 	return baseUrl.String(), nil
 }
 
+func (ub *URLBuilder) BuildCanariesURL(values url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameCanaries)
+	canariesURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	if len(values) > 0 {
+		canariesURL.RawQuery = values.Encode()
+	}
+
+	return canariesURL.String(), nil
+}
+
 func (ub *URLBuilder) BuildCanaryURL(canaryID string) (string, error) {
 	route := ub.cloneRoute(RouteNameCanary)
 	canaryURL, err := route.URL("canary_id", canaryID)
@@ -90,6 +104,48 @@ func (ub *URLBuilder) BuildCanaryURL(canaryID string) (string, error) {
 	return canaryURL.String(), nil
 }
 
+func (ub *URLBuilder) BuildCanaryHistoryURL(canaryID string, values url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameHistory)
+	historyURL, err := route.URL("canary_id", canaryID)
+	if err != nil {
+		return "", err
+	}
+
+	if len(values) > 0 {
+		historyURL.RawQuery = values.Encode()
+	}
+
+	return historyURL.String(), nil
+}
+
+func (ub *URLBuilder) BuildCanaryChainURL(canaryID string, values url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameChain)
+	chainURL, err := route.URL("canary_id", canaryID)
+	if err != nil {
+		return "", err
+	}
+
+	if len(values) > 0 {
+		chainURL.RawQuery = values.Encode()
+	}
+
+	return chainURL.String(), nil
+}
+
+func (ub *URLBuilder) BuildCanaryHooksURL(canaryID string, values url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameWebhooks)
+	hooksURL, err := route.URL("canary_id", canaryID)
+	if err != nil {
+		return "", err
+	}
+
+	if len(values) > 0 {
+		hooksURL.RawQuery = values.Encode()
+	}
+
+	return hooksURL.String(), nil
+}
+
 func (ub *URLBuilder) BuildCanaryHookURL(canaryID string, hookID string) (string, error) {
 	route := ub.cloneRoute(RouteNameWebhook)
 	hookURL, err := route.URL("canary_id", canaryID, "hook_id", hookID)
@@ -100,6 +156,20 @@ func (ub *URLBuilder) BuildCanaryHookURL(canaryID string, hookID string) (string
 	return hookURL.String(), nil
 }
 
+func (ub *URLBuilder) BuildHookDeliveriesURL(canaryID string, hookID string, values url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameDeliveries)
+	deliveriesURL, err := route.URL("canary_id", canaryID, "hook_id", hookID)
+	if err != nil {
+		return "", err
+	}
+
+	if len(values) > 0 {
+		deliveriesURL.RawQuery = values.Encode()
+	}
+
+	return deliveriesURL.String(), nil
+}
+
 type clonedRoute struct {
 	*mux.Route
 
@@ -14,12 +14,22 @@ var (
 
 	ErrAuthenticationFailure = errors.New("authentication failure")
 	ErrInvalidCredential     = errors.New("invalid authorization credential")
+
+	// ErrAccessDenied is returned by strategies when an authenticated user
+	// isn't allowed the requested access.
+	ErrAccessDenied = errors.New("access denied")
 )
 
 type AuthStrategy interface {
 	Authorized(ctx context.Context, access ...Access) (context.Context, error)
 }
 
+// Authenticator is implemented by strategies that can check a username and
+// password on their own, outside of a request.
+type Authenticator interface {
+	AuthenticateUser(username string, password string) error
+}
+
 type UserInfo struct {
 	Name string
 }
@@ -13,9 +13,13 @@ import (
 type authStrategy struct {
 	realm    string
 	htpasswd *htpasswd
+	policy   auth.Policy
 }
 
-var _ auth.AuthStrategy = &authStrategy{}
+var (
+	_ auth.AuthStrategy  = &authStrategy{}
+	_ auth.Authenticator = &authStrategy{}
+)
 
 func newAuthStrategy(options map[string]interface{}) (auth.AuthStrategy, error) {
 	realm, found := options["realm"]
@@ -39,10 +43,18 @@ func newAuthStrategy(options map[string]interface{}) (auth.AuthStrategy, error)
 		return nil, err
 	}
 
-	return &authStrategy{
+	as := &authStrategy{
 		realm:    realm.(string),
 		htpasswd: h,
-	}, nil
+	}
+
+	if policy, found := options["policy"]; found {
+		if as.policy, err = auth.ParsePolicy(policy); err != nil {
+			return nil, err
+		}
+	}
+
+	return as, nil
 }
 
 func (as *authStrategy) Authorized(ctx context.Context, accessRecords ...auth.Access) (context.Context, error) {
@@ -67,9 +79,26 @@ func (as *authStrategy) Authorized(ctx context.Context, accessRecords ...auth.Ac
 		}
 	}
 
+	for _, access := range accessRecords {
+		if !as.allowed(username, access) {
+			context.GetLogger(ctx).Warnf("user %q denied %s on %s:%s", username, access.Action, access.Type, access.Name)
+			return nil, auth.ErrAccessDenied
+		}
+	}
+
 	return auth.WithUser(ctx, auth.UserInfo{Name: username}), nil
 }
 
+// allowed checks access against the policy. Without one, users may act on
+// canaries but not list or create them, nor act as admin.
+func (as *authStrategy) allowed(username string, access auth.Access) bool {
+	if as.policy == nil {
+		return access.Type != auth.CatalogResource.Type
+	}
+
+	return as.policy.Allowed(username, access)
+}
+
 func (as *authStrategy) AuthenticateUser(username string, password string) error {
 	return as.htpasswd.authenticateUser(username, password)
 }
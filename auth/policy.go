@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/go-yaml/yaml"
+
+	"github.com/danielkrainas/canaria-api/configuration"
+)
+
+const (
+	ActionRead   = "read"
+	ActionWrite  = "write"
+	ActionKill   = "kill"
+	ActionCreate = "create"
+	ActionList   = "list"
+	ActionAll    = "*"
+)
+
+var (
+	// CatalogResource guards the routes that aren't about a single canary:
+	// listing canaries and creating new ones.
+	CatalogResource = Resource{
+		Type: "registry",
+		Name: "catalog",
+	}
+
+	// AdminResource lets its holders act on canaries they don't own.
+	AdminResource = Resource{
+		Type: "registry",
+		Name: "admin",
+	}
+)
+
+// Policy grants accounts actions on resources, in the same form as the token
+// server's policy. A rule for the account * applies to everyone, names may be
+// patterns and the action * allows every action.
+type Policy []configuration.TokenPolicyRule
+
+// ParsePolicy reads a policy from strategy options. An empty policy allows
+// nothing.
+func ParsePolicy(v interface{}) (Policy, error) {
+	raw, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var p Policy
+	if err := yaml.Unmarshal(raw, &p); err != nil {
+		return nil, fmt.Errorf("invalid policy: %v", err)
+	} else if p == nil {
+		p = Policy{}
+	}
+
+	return p, nil
+}
+
+func (p Policy) Allowed(account string, access Access) bool {
+	for _, rule := range p {
+		if rule.Account != "*" && rule.Account != account {
+			continue
+		}
+
+		for _, granted := range rule.Access {
+			if granted.Type != access.Type || !MatchName(granted.Name, access.Name) {
+				continue
+			}
+
+			for _, action := range granted.Actions {
+				if action == ActionAll || action == access.Action {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// MatchName matches a resource name against a name that may be a pattern
+// like * or prefix-*.
+func MatchName(pattern string, name string) bool {
+	if pattern == "*" {
+		return true
+	}
+
+	ok, err := path.Match(pattern, name)
+	return err == nil && ok
+}
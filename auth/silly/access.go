@@ -68,7 +68,7 @@ func (as *authStrategy) Authorized(ctx context.Context, accessRecords ...auth.Ac
 		if len(accessRecords) > 0 {
 			var scopes []string
 			for _, access := range accessRecords {
-				scopes = append(scopes, fmt.Sprintf("%s:%s:%s", access.Type, access.Resource, access.Action))
+				scopes = append(scopes, fmt.Sprintf("%s:%s:%s", access.Type, access.Name, access.Action))
 			}
 
 			challenge.scope = strings.Join(scopes, " ")
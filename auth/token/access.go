@@ -3,20 +3,15 @@ package token
 import (
 	"crypto"
 	"crypto/x509"
-	"encoding/base64"
 	"encoding/json"
-	"encoding/pem"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/dgrijalva/jwt-go"
-	"github.com/docker/libtrust"
 
 	"github.com/danielkrainas/canaria-api/auth"
 	"github.com/danielkrainas/canaria-api/context"
@@ -44,8 +39,9 @@ type authStrategy struct {
 	realm       string
 	issuer      string
 	service     string
+	leeway      time.Duration
 	rootCerts   *x509.CertPool
-	trustedKeys map[string]libtrust.PublicKey
+	trustedKeys map[string]crypto.PublicKey
 }
 
 type tokenAccessOptions struct {
@@ -53,13 +49,20 @@ type tokenAccessOptions struct {
 	issuer         string
 	service        string
 	rootCertBundle string
+	jwks           string
+	leeway         time.Duration
 }
 
+// defaultLeeway is how far the clocks of the issuer and the api may drift
+// apart before tokens are refused for being expired or not yet valid.
+const defaultLeeway = time.Minute
+
 type VerifyOptions struct {
 	TrustedIssuers    []string
 	AcceptedAudiences []string
 	Roots             *x509.CertPool
-	TrustedKeys       map[string]libtrust.PublicKey
+	TrustedKeys       map[string]crypto.PublicKey
+	Leeway            time.Duration
 }
 
 func newAccessSet(accessItems ...auth.Access) accessSet {
@@ -83,9 +86,17 @@ func newAccessSet(accessItems ...auth.Access) accessSet {
 	return accessSet
 }
 
+// contains reports whether any entry of the set grants the access. Entry
+// names may be patterns, such as * for every resource of the type.
 func (s accessSet) contains(access auth.Access) bool {
-	if actionSet, ok := s[access.Resource]; ok {
-		return actionSet.contains(access.Action)
+	if actionSet, ok := s[access.Resource]; ok && actionSet.contains(access.Action) {
+		return true
+	}
+
+	for res, actionSet := range s {
+		if res.Type == access.Type && auth.MatchName(res.Name, access.Name) && actionSet.contains(access.Action) {
+			return true
+		}
 	}
 
 	return false
@@ -130,11 +141,11 @@ func (ac authChallenge) SetHeaders(w http.ResponseWriter) {
 
 func checkOptions(options map[string]interface{}) (tokenAccessOptions, error) {
 	var opts tokenAccessOptions
-	keys := []string{"realm", "issuer", "service", "rootcertbundle"}
+	keys := []string{"realm", "issuer", "service"}
 	vals := make([]string, 0, len(keys))
 	for _, key := range keys {
 		val, ok := options[key].(string)
-		if !ok {
+		if !ok || val == "" {
 			return opts, fmt.Errorf("token auth requires a valid option string: %q", key)
 		}
 
@@ -144,7 +155,37 @@ func checkOptions(options map[string]interface{}) (tokenAccessOptions, error) {
 	opts.realm = vals[0]
 	opts.issuer = vals[1]
 	opts.service = vals[2]
-	opts.rootCertBundle = vals[3]
+
+	for key, val := range map[string]*string{"rootcertbundle": &opts.rootCertBundle, "jwks": &opts.jwks} {
+		if v, ok := options[key]; ok {
+			if *val, ok = v.(string); !ok {
+				return opts, fmt.Errorf("token auth requires a valid option string: %q", key)
+			}
+		}
+	}
+
+	if opts.rootCertBundle == "" && opts.jwks == "" {
+		return opts, errors.New(`token auth requires "rootcertbundle" or "jwks" to verify tokens with`)
+	}
+
+	opts.leeway = defaultLeeway
+	switch v := options["leeway"].(type) {
+	case nil:
+	case string:
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return opts, fmt.Errorf("invalid leeway: %q", v)
+		}
+
+		opts.leeway = d
+
+	case int:
+		opts.leeway = time.Duration(v) * time.Second
+
+	default:
+		return opts, fmt.Errorf("invalid leeway: %v", v)
+	}
+
 	return opts, nil
 }
 
@@ -154,57 +195,40 @@ func newAuthStrategy(options map[string]interface{}) (auth.AuthStrategy, error)
 		return nil, err
 	}
 
-	fp, err := os.Open(config.rootCertBundle)
-	if err != nil {
-		return nil, fmt.Errorf("unable to open auth root certificate bundle file %q: %s", config.rootCertBundle, err)
-	}
-
-	defer fp.Close()
-	rawCertBundle, err := ioutil.ReadAll(fp)
-	if err != nil {
-		return nil, fmt.Errorf("unable to read token auth root certificate bundle file %q: %s", config.rootCertBundle, err)
+	as := &authStrategy{
+		realm:       config.realm,
+		issuer:      config.issuer,
+		service:     config.service,
+		leeway:      config.leeway,
+		trustedKeys: make(map[string]crypto.PublicKey),
 	}
 
-	var rootCerts []*x509.Certificate
-	pemBlock, rawCertBundle := pem.Decode(rawCertBundle)
-	for pemBlock != nil {
-		cert, err := x509.ParseCertificate(pemBlock.Bytes)
+	if config.rootCertBundle != "" {
+		rootCerts, err := loadRootCerts(config.rootCertBundle)
 		if err != nil {
-			return nil, fmt.Errorf("unable to parse token auth root certificate: %s", err)
+			return nil, err
 		}
 
-		rootCerts = append(rootCerts, cert)
-		pemBlock, rawCertBundle = pem.Decode(rawCertBundle)
-	}
+		if len(rootCerts) == 0 {
+			return nil, errors.New("token auth requires at least one token signing root certificate")
+		}
 
-	if len(rootCerts) == 0 {
-		return nil, errors.New("token auth requires at least one token signing root certificate")
+		as.rootCerts = x509.NewCertPool()
+		for _, rootCert := range rootCerts {
+			as.rootCerts.AddCert(rootCert)
+			if err := trustKey(as.trustedKeys, rootCert.PublicKey); err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	rootPool := x509.NewCertPool()
-	trustedKeys := make(map[string]libtrust.PublicKey, len(rootCerts))
-	for _, rootCert := range rootCerts {
-		rootPool.AddCert(rootCert)
-		pubKey, err := libtrust.FromCryptoPublicKey(crypto.PublicKey(rootCert.PublicKey))
-		if err != nil {
-			return nil, fmt.Errorf("unable to get public key from token auth root certificate: %s", err)
+	if config.jwks != "" {
+		if err := loadTrustedKeys(config.jwks, as.trustedKeys); err != nil {
+			return nil, err
 		}
-
-		trustedKeys[pubKey.KeyID()] = pubKey
 	}
 
-	return &authStrategy{
-		realm:       config.realm,
-		issuer:      config.issuer,
-		service:     config.service,
-		rootCerts:   rootPool,
-		trustedKeys: trustedKeys,
-	}, nil
-}
-
-func getAccessSet(c *RegistryClaims) accessSet {
-	accessSet := make(accessSet, len(c.Access))
-	return accessSet
+	return as, nil
 }
 
 func (ac *authStrategy) Authorized(ctx context.Context, accessItems ...auth.Access) (context.Context, error) {
@@ -225,34 +249,21 @@ func (ac *authStrategy) Authorized(ctx context.Context, accessItems ...auth.Acce
 		return nil, challenge
 	}
 
-	rawToken := parts[1]
-	claims := &RegistryClaims{}
-	token, err := jwt.ParseWithClaims(rawToken, *claims, func(token *jwt.Token) (interface{}, error) {
-		/*if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-		}*/
-
-		return nil, nil
-	})
-
-	if err != nil {
-		challenge.err = err
-		return nil, challenge
-	}
-
 	verifyOpts := VerifyOptions{
 		TrustedIssuers:    []string{ac.issuer},
 		AcceptedAudiences: []string{ac.service},
 		Roots:             ac.rootCerts,
 		TrustedKeys:       ac.trustedKeys,
+		Leeway:            ac.leeway,
 	}
 
-	if err = verify(token, *claims, verifyOpts); err != nil {
+	claims, err := Verify(parts[1], verifyOpts)
+	if err != nil {
 		challenge.err = err
 		return nil, challenge
 	}
 
-	accessSet := getAccessSet(claims)
+	accessSet := claims.accessSet()
 	for _, access := range accessItems {
 		if !accessSet.contains(access) {
 			challenge.err = ErrInsufficientScope
@@ -263,54 +274,103 @@ func (ac *authStrategy) Authorized(ctx context.Context, accessItems ...auth.Acce
 	return auth.WithUser(ctx, auth.UserInfo{Name: claims.Subject}), nil
 }
 
-func verify(token *jwt.Token, claims RegistryClaims, verifyOptions VerifyOptions) error {
+// Verify parses a raw token, checks its signature against the trusted keys
+// and root certificates and validates its claims.
+func Verify(rawToken string, verifyOptions VerifyOptions) (*RegistryClaims, error) {
+	claims := &RegistryClaims{}
+	parser := &jwt.Parser{
+		ValidMethods:         validMethods,
+		SkipClaimsValidation: true,
+	}
+
+	token, err := parser.ParseWithClaims(rawToken, claims, func(token *jwt.Token) (interface{}, error) {
+		key, err := verifySigningKey(token, verifyOptions)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := checkKeyType(token.Method.Alg(), key); err != nil {
+			return nil, err
+		}
+
+		return key, nil
+	})
+
+	if err != nil {
+		if verr, ok := err.(*jwt.ValidationError); ok && verr.Errors&jwt.ValidationErrorMalformed != 0 {
+			log.Errorf("malformed token: %s", err)
+			return nil, ErrMalformedToken
+		}
+
+		log.Errorf("unable to verify token: %s", err)
+		return nil, ErrInvalidToken
+	}
+
+	if err := verify(token, claims, verifyOptions); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func verify(token *jwt.Token, claims *RegistryClaims, verifyOptions VerifyOptions) error {
 	if !contains(verifyOptions.TrustedIssuers, claims.Issuer) {
 		log.Errorf("token from untrusted issuer: %q", claims.Issuer)
 		return ErrInvalidToken
 	}
 
-	if !contains(verifyOptions.AcceptedAudiences, claims.Audience) {
-		log.Errorf("token intended for another audience: %q", claims.Audience)
-		return ErrInvalidToken
+	audienceAccepted := false
+	for _, aud := range claims.Audience {
+		if contains(verifyOptions.AcceptedAudiences, aud) {
+			audienceAccepted = true
+			break
+		}
 	}
 
-	currentUnixTime := time.Now().Unix()
-	if !(claims.NotBefore <= currentUnixTime && currentUnixTime <= claims.ExpiresAt) {
-		log.Errorf("token not to be used before %d or after %d - currently %d", claims.NotBefore, claims.ExpiresAt, currentUnixTime)
+	if !audienceAccepted {
+		log.Errorf("token intended for another audience: %q", claims.Audience)
 		return ErrInvalidToken
 	}
 
-	if len(token.Signature) == 0 {
-		log.Errorf("token has no signature")
+	now := time.Now()
+	if claims.ExpiresAt == 0 {
+		log.Errorf("token has no expiration time")
 		return ErrInvalidToken
 	}
 
-	signingKey, err := verifySigningKey(token, verifyOptions)
-	if err != nil {
-		log.Error(err)
+	if now.Add(-verifyOptions.Leeway).Unix() > claims.ExpiresAt || (claims.NotBefore != 0 && now.Add(verifyOptions.Leeway).Unix() < claims.NotBefore) {
+		log.Errorf("token not to be used before %d or after %d - currently %d", claims.NotBefore, claims.ExpiresAt, now.Unix())
 		return ErrInvalidToken
 	}
 
-	if err = signingKey.Verify(strings.NewReader(token.Raw), token.Method.Alg(), []byte(token.Signature)); err != nil {
-		log.Errorf("unable to verify token signature: %s", err)
+	if len(token.Signature) == 0 {
+		log.Errorf("token has no signature")
 		return ErrInvalidToken
 	}
 
 	return nil
 }
 
-func verifySigningKey(token *jwt.Token, verifyOptions VerifyOptions) (libtrust.PublicKey, error) {
+func verifySigningKey(token *jwt.Token, verifyOptions VerifyOptions) (crypto.PublicKey, error) {
 	var err error
-	var signingKey libtrust.PublicKey
-	x5c, _ := token.Header["x5c"].([]string)
-	rawJWK, _ := token.Header["jwk"].(*json.RawMessage)
+	var signingKey crypto.PublicKey
+	x5c, err := headerStrings(token.Header["x5c"])
+	if err != nil {
+		return nil, err
+	}
+
+	rawJWK, _ := token.Header["jwk"].(map[string]interface{})
 	keyID, _ := token.Header["kid"].(string)
 
 	switch {
 	case len(x5c) > 0:
 		signingKey, err = parseAndVerifyCertChain(x5c, verifyOptions.Roots)
 	case rawJWK != nil:
-		signingKey, err = parseAndVerifyRawJWK(rawJWK, verifyOptions)
+		var raw []byte
+		if raw, err = json.Marshal(rawJWK); err == nil {
+			signingKey, err = parseAndVerifyRawJWK(raw, verifyOptions)
+		}
+
 	case len(keyID) > 0:
 		signingKey = verifyOptions.TrustedKeys[keyID]
 		if signingKey == nil {
@@ -324,95 +384,27 @@ func verifySigningKey(token *jwt.Token, verifyOptions VerifyOptions) (libtrust.P
 	return signingKey, err
 }
 
-func parseAndVerifyCertChain(x5c []string, roots *x509.CertPool) (libtrust.PublicKey, error) {
-	if len(x5c) == 0 {
-		return nil, errors.New("empty x509 certificate chain")
-	}
-
-	leafCertDer, err := base64.StdEncoding.DecodeString(x5c[0])
-	if err != nil {
-		return nil, fmt.Errorf("unable to decode leaf certificate: %s", err)
-	}
-
-	leafCert, err := x509.ParseCertificate(leafCertDer)
-	if err != nil {
-		return nil, fmt.Errorf("unable to parse leaf certificate: %s", err)
-	}
-
-	intermediates := x509.NewCertPool()
-	for i := 1; i < len(x5c); i++ {
-		intermediateCertDer, err := base64.StdEncoding.DecodeString(x5c[i])
-		if err != nil {
-			return nil, fmt.Errorf("unabel to decode intermediate certificate: %s", err)
-		}
-
-		intermediateCert, err := x509.ParseCertificate(intermediateCertDer)
-		if err != nil {
-			return nil, fmt.Errorf("unable to parse intermediate certificate: %s", err)
-		}
-
-		intermediates.AddCert(intermediateCert)
-	}
-
-	verifyOptions := x509.VerifyOptions{
-		Intermediates: intermediates,
-		Roots:         roots,
-		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
-	}
-
-	// TODO: check for revocations
-	if _, err := leafCert.Verify(verifyOptions); err != nil {
-		return nil, fmt.Errorf("unable to verify certificate chain: %s", err)
-	}
-
-	leafCryptoKey, ok := leafCert.PublicKey.(crypto.PublicKey)
-	if !ok {
-		return nil, errors.New("unable to get leaf cert public key value")
-	}
-
-	leafKey, err := libtrust.FromCryptoPublicKey(leafCryptoKey)
-	if err != nil {
-		return nil, fmt.Errorf("unable to make libtrust public key from leaf certificate: %s", err)
-	}
-
-	return leafKey, nil
-}
-
-func parseAndVerifyRawJWK(rawJWK *json.RawMessage, verifyOptions VerifyOptions) (libtrust.PublicKey, error) {
-	pubKey, err := libtrust.UnmarshalPublicKeyJWK([]byte(*rawJWK))
-	if err != nil {
-		return nil, fmt.Errorf("unable to decode raw JWK value: %s", err)
+func headerStrings(v interface{}) ([]string, error) {
+	if v == nil {
+		return nil, nil
 	}
 
-	x5cVal, ok := pubKey.GetExtendedField("x5c").([]interface{})
+	items, ok := v.([]interface{})
 	if !ok {
-		if _, trusted := verifyOptions.TrustedKeys[pubKey.KeyID()]; !trusted {
-			return nil, errors.New("untrusted JWK with no certificate chain")
-		}
-
-		return pubKey, nil
+		return nil, errors.New("malformed certificate chain")
 	}
 
-	x5c := make([]string, len(x5cVal))
-	for i, val := range x5cVal {
-		certString, ok := val.(string)
-		if !ok || len(certString) == 0 {
+	ss := make([]string, len(items))
+	for i, item := range items {
+		s, ok := item.(string)
+		if !ok || len(s) == 0 {
 			return nil, errors.New("malformed certificate chain")
 		}
 
-		x5c[i] = certString
+		ss[i] = s
 	}
 
-	leafKey, err := parseAndVerifyCertChain(x5c, verifyOptions.Roots)
-	if err != nil {
-		return nil, fmt.Errorf("could not verify JWK certificate chain: %s", err)
-	}
-
-	if pubKey.KeyID() != leafKey.KeyID() {
-		return nil, errors.New("leaf certificate public key ID does not match JWK key ID")
-	}
-
-	return pubKey, nil
+	return ss, nil
 }
 
 type ResourceActions struct {
@@ -422,23 +414,51 @@ type ResourceActions struct {
 }
 
 type RegistryClaims struct {
-	Audience  string `json:"aud,omitempty"`
-	ExpiresAt int64  `json:"exp,omitempty"`
-	Id        string `json:"jti,omitempty"`
-	IssuedAt  int64  `json:"iat,omitempty"`
-	Issuer    string `json:"iss,omitempty"`
-	NotBefore int64  `json:"nbf,omitempty"`
-	Subject   string `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	Id        string   `json:"jti,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
 
 	// Private claims
 	Access []*ResourceActions `json:"access,omitempty"`
 }
 
+// Valid is only there to satisfy jwt.Claims, the claims are checked by verify
+// which knows about the configured issuer, audience and leeway.
 func (c RegistryClaims) Valid() error {
-	// TODO
 	return nil
 }
 
+// Audience is the aud claim, which may be a single string or a list.
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+
+	*a = Audience(list)
+	return nil
+}
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+
+	return json.Marshal([]string(a))
+}
+
 func (c *RegistryClaims) accessSet() accessSet {
 	accessSet := make(accessSet, len(c.Access))
 	for _, resourceActions := range c.Access {
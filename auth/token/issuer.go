@@ -0,0 +1,261 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+
+	"github.com/danielkrainas/canaria-api/auth"
+	"github.com/danielkrainas/canaria-api/configuration"
+	"github.com/danielkrainas/canaria-api/uuid"
+)
+
+const defaultExpiration = 5 * time.Minute
+
+var ErrInvalidScope = errors.New("invalid scope")
+
+// Issuer hands out tokens in the format the token strategy verifies. Clients
+// authenticate with the configured backend and get whatever part of the
+// access they ask for the policy grants them.
+type Issuer struct {
+	issuer        string
+	service       string
+	expiration    time.Duration
+	key           crypto.Signer
+	method        jwt.SigningMethod
+	keyID         string
+	chain         []string
+	authenticator auth.Authenticator
+	policy        auth.Policy
+}
+
+func NewIssuer(config configuration.TokenServerConfig) (*Issuer, error) {
+	if config.Issuer == "" {
+		return nil, errors.New(`"issuer" must be set for the token server`)
+	} else if config.Service == "" {
+		return nil, errors.New(`"service" must be set for the token server`)
+	}
+
+	is := &Issuer{
+		issuer:     config.Issuer,
+		service:    config.Service,
+		expiration: config.Expiration,
+		policy:     auth.Policy(config.Policy),
+	}
+
+	if is.expiration <= 0 {
+		is.expiration = defaultExpiration
+	}
+
+	var err error
+	if is.key, err = loadSigningKey(config.SigningKey); err != nil {
+		return nil, err
+	}
+
+	if is.method, err = signingMethod(is.key.Public()); err != nil {
+		return nil, err
+	}
+
+	if is.keyID, err = KeyID(is.key.Public()); err != nil {
+		return nil, err
+	}
+
+	if config.Certificate != "" {
+		if is.chain, err = loadChain(config.Certificate, is.keyID); err != nil {
+			return nil, err
+		}
+	}
+
+	backend := config.Backend.Type()
+	if backend == "" {
+		return nil, errors.New(`"backend" must be set for the token server`)
+	}
+
+	strategy, err := auth.GetStrategy(backend, config.Backend.Parameters())
+	if err != nil {
+		return nil, err
+	}
+
+	authenticator, ok := strategy.(auth.Authenticator)
+	if !ok {
+		return nil, fmt.Errorf("%s auth can't be used as a token server backend", backend)
+	}
+
+	is.authenticator = authenticator
+	return is, nil
+}
+
+func (is *Issuer) Service() string {
+	return is.service
+}
+
+func (is *Issuer) Authenticate(username string, password string) error {
+	return is.authenticator.AuthenticateUser(username, password)
+}
+
+// Issue signs a token for the account carrying the requested access that the
+// policy allows. Access that isn't allowed is left out rather than refused,
+// the api decides whether what is left is enough.
+func (is *Issuer) Issue(account string, requested []*ResourceActions) (string, *RegistryClaims, error) {
+	now := time.Now()
+	claims := &RegistryClaims{
+		Issuer:    is.issuer,
+		Subject:   account,
+		Audience:  Audience{is.service},
+		Id:        uuid.Generate(),
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
+		ExpiresAt: now.Add(is.expiration).Unix(),
+		Access:    is.grant(account, requested),
+	}
+
+	token := jwt.NewWithClaims(is.method, claims)
+	if len(is.chain) > 0 {
+		token.Header["x5c"] = is.chain
+	} else {
+		token.Header["kid"] = is.keyID
+	}
+
+	signed, err := token.SignedString(is.key)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return signed, claims, nil
+}
+
+func (is *Issuer) grant(account string, requested []*ResourceActions) []*ResourceActions {
+	granted := make([]*ResourceActions, 0, len(requested))
+	for _, req := range requested {
+		resource := auth.Resource{
+			Type: req.Type,
+			Name: req.Name,
+		}
+
+		actions := newActionSet()
+		for _, action := range req.Actions {
+			if is.policy.Allowed(account, auth.Access{Resource: resource, Action: action}) {
+				actions.add(action)
+			}
+		}
+
+		if len(actions.stringSet) > 0 {
+			granted = append(granted, &ResourceActions{
+				Type:    req.Type,
+				Name:    req.Name,
+				Actions: actions.keys(),
+			})
+		}
+	}
+
+	return granted
+}
+
+// ParseScope parses a scope in the type:name:actions form used by challenges,
+// e.g. canary:<id>:read,write.
+func ParseScope(scope string) (*ResourceActions, error) {
+	first := strings.Index(scope, ":")
+	last := strings.LastIndex(scope, ":")
+	if first <= 0 || last == first || last == len(scope)-1 {
+		return nil, ErrInvalidScope
+	}
+
+	return &ResourceActions{
+		Type:    scope[:first],
+		Name:    scope[first+1 : last],
+		Actions: strings.Split(scope[last+1:], ","),
+	}, nil
+}
+
+func loadSigningKey(path string) (crypto.Signer, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read token signing key %q: %s", path, err)
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM encoded key in %q", path)
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse token signing key %q: %s", path, err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported token signing key type: %T", key)
+	}
+
+	return signer, nil
+}
+
+func signingMethod(pub crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, nil
+
+	case *ecdsa.PublicKey:
+		if k.Curve == elliptic.P256() {
+			return jwt.SigningMethodES256, nil
+		}
+
+	case ed25519.PublicKey:
+		return SigningMethodEdDSA, nil
+	}
+
+	return nil, fmt.Errorf("unsupported token signing key type: %T", pub)
+}
+
+// loadChain reads the signing key's certificate and its intermediates, leaf
+// first, in the form of an x5c header.
+func loadChain(path string, keyID string) ([]string, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read token signing certificate %q: %s", path, err)
+	}
+
+	var chain []string
+	for block, rest := pem.Decode(raw); block != nil; block, rest = pem.Decode(rest) {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse token signing certificate: %s", err)
+		}
+
+		if len(chain) == 0 {
+			if leafKeyID, err := KeyID(cert.PublicKey); err != nil || leafKeyID != keyID {
+				return nil, errors.New("token signing certificate does not match the signing key")
+			}
+		}
+
+		chain = append(chain, base64.StdEncoding.EncodeToString(cert.Raw))
+	}
+
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no certificates in %q", path)
+	}
+
+	return chain, nil
+}
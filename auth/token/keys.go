@@ -0,0 +1,351 @@
+package token
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Algorithms accepted for token signatures.
+const (
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgEdDSA = "EdDSA"
+)
+
+var validMethods = []string{AlgRS256, AlgES256, AlgEdDSA}
+
+// SigningMethodEdDSA signs and verifies tokens with Ed25519 keys, which the
+// jwt package doesn't know about.
+var SigningMethodEdDSA = &signingMethodEdDSA{}
+
+type signingMethodEdDSA struct{}
+
+func init() {
+	jwt.RegisterSigningMethod(AlgEdDSA, func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+func (m *signingMethodEdDSA) Alg() string {
+	return AlgEdDSA
+}
+
+func (m *signingMethodEdDSA) Verify(signingString string, signature string, key interface{}) error {
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(pub, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+
+	return nil
+}
+
+func (m *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+
+	return jwt.EncodeSegment(ed25519.Sign(priv, []byte(signingString))), nil
+}
+
+// checkKeyType makes sure a token's algorithm fits the key it is verified
+// with, so that a token can't pick a weaker interpretation of a trusted key.
+func checkKeyType(alg string, key crypto.PublicKey) error {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if alg == AlgRS256 {
+			return nil
+		}
+
+	case *ecdsa.PublicKey:
+		if alg == AlgES256 && k.Curve == elliptic.P256() {
+			return nil
+		}
+
+	case ed25519.PublicKey:
+		if alg == AlgEdDSA {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s signature can't be verified with a %T key", alg, key)
+}
+
+// KeyID is the libtrust style fingerprint of a public key: the first 240 bits
+// of the SHA-256 of its DER encoding, base32 encoded in groups of four.
+func KeyID(key crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(der)
+	s := strings.TrimRight(base32.StdEncoding.EncodeToString(sum[:30]), "=")
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i += 4 {
+		if i > 0 {
+			buf.WriteByte(':')
+		}
+
+		end := i + 4
+		if end > len(s) {
+			end = len(s)
+		}
+
+		buf.WriteString(s[i:end])
+	}
+
+	return buf.String(), nil
+}
+
+// JSONWebKey is a public key in JWK form. RSA, P-256 and Ed25519 keys are
+// supported.
+type JSONWebKey struct {
+	Kty string   `json:"kty"`
+	Kid string   `json:"kid,omitempty"`
+	Crv string   `json:"crv,omitempty"`
+	X   string   `json:"x,omitempty"`
+	Y   string   `json:"y,omitempty"`
+	N   string   `json:"n,omitempty"`
+	E   string   `json:"e,omitempty"`
+	X5c []string `json:"x5c,omitempty"`
+}
+
+type jsonWebKeySet struct {
+	Keys []*JSONWebKey `json:"keys"`
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	} else if len(b) == 0 {
+		return nil, errors.New("empty value")
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k *JSONWebKey) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA modulus: %v", err)
+		}
+
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve: %q", k.Crv)
+		}
+
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate: %v", err)
+		}
+
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate: %v", err)
+		}
+
+		curve := elliptic.P256()
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on curve")
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve: %q", k.Crv)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(k.X, "="))
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type: %q", k.Kty)
+}
+
+// loadRootCerts reads a bundle of PEM encoded certificates.
+func loadRootCerts(path string) ([]*x509.Certificate, error) {
+	rawCertBundle, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read token auth root certificate bundle file %q: %s", path, err)
+	}
+
+	var rootCerts []*x509.Certificate
+	pemBlock, rawCertBundle := pem.Decode(rawCertBundle)
+	for pemBlock != nil {
+		cert, err := x509.ParseCertificate(pemBlock.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse token auth root certificate: %s", err)
+		}
+
+		rootCerts = append(rootCerts, cert)
+		pemBlock, rawCertBundle = pem.Decode(rawCertBundle)
+	}
+
+	return rootCerts, nil
+}
+
+// loadTrustedKeys reads a JWK set, returning its keys by their fingerprint
+// and, when set, by their own key id.
+func loadTrustedKeys(path string, keys map[string]crypto.PublicKey) error {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read token auth key set %q: %s", path, err)
+	}
+
+	var set jsonWebKeySet
+	if err := json.Unmarshal(raw, &set); err != nil {
+		return fmt.Errorf("unable to parse token auth key set %q: %s", path, err)
+	}
+
+	for i, jwk := range set.Keys {
+		pub, err := jwk.PublicKey()
+		if err != nil {
+			return fmt.Errorf("invalid key %d in %q: %s", i, path, err)
+		}
+
+		if err := trustKey(keys, pub); err != nil {
+			return err
+		}
+
+		if jwk.Kid != "" {
+			keys[jwk.Kid] = pub
+		}
+	}
+
+	return nil
+}
+
+func trustKey(keys map[string]crypto.PublicKey, pub crypto.PublicKey) error {
+	keyID, err := KeyID(pub)
+	if err != nil {
+		return fmt.Errorf("unable to get key id of trusted key: %s", err)
+	}
+
+	keys[keyID] = pub
+	return nil
+}
+
+func parseAndVerifyCertChain(x5c []string, roots *x509.CertPool) (crypto.PublicKey, error) {
+	if len(x5c) == 0 {
+		return nil, errors.New("empty x509 certificate chain")
+	} else if roots == nil {
+		return nil, errors.New("no root certificates to verify the x509 certificate chain with")
+	}
+
+	leafCertDer, err := base64.StdEncoding.DecodeString(x5c[0])
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode leaf certificate: %s", err)
+	}
+
+	leafCert, err := x509.ParseCertificate(leafCertDer)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse leaf certificate: %s", err)
+	}
+
+	intermediates := x509.NewCertPool()
+	for i := 1; i < len(x5c); i++ {
+		intermediateCertDer, err := base64.StdEncoding.DecodeString(x5c[i])
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode intermediate certificate: %s", err)
+		}
+
+		intermediateCert, err := x509.ParseCertificate(intermediateCertDer)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse intermediate certificate: %s", err)
+		}
+
+		intermediates.AddCert(intermediateCert)
+	}
+
+	verifyOptions := x509.VerifyOptions{
+		Intermediates: intermediates,
+		Roots:         roots,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+
+	// TODO: check for revocations
+	if _, err := leafCert.Verify(verifyOptions); err != nil {
+		return nil, fmt.Errorf("unable to verify certificate chain: %s", err)
+	}
+
+	return leafCert.PublicKey, nil
+}
+
+func parseAndVerifyRawJWK(rawJWK []byte, verifyOptions VerifyOptions) (crypto.PublicKey, error) {
+	var jwk JSONWebKey
+	if err := json.Unmarshal(rawJWK, &jwk); err != nil {
+		return nil, fmt.Errorf("unable to decode raw JWK value: %s", err)
+	}
+
+	pubKey, err := jwk.PublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode raw JWK value: %s", err)
+	}
+
+	keyID, err := KeyID(pubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(jwk.X5c) == 0 {
+		if _, trusted := verifyOptions.TrustedKeys[keyID]; !trusted {
+			return nil, errors.New("untrusted JWK with no certificate chain")
+		}
+
+		return pubKey, nil
+	}
+
+	leafKey, err := parseAndVerifyCertChain(jwk.X5c, verifyOptions.Roots)
+	if err != nil {
+		return nil, fmt.Errorf("could not verify JWK certificate chain: %s", err)
+	}
+
+	if leafKeyID, err := KeyID(leafKey); err != nil || leafKeyID != keyID {
+		return nil, errors.New("leaf certificate public key ID does not match JWK key ID")
+	}
+
+	return pubKey, nil
+}
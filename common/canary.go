@@ -1,32 +1,198 @@
 package common
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 )
 
+const (
+	// MaxLabels and MaxLabelLength keep labels, which hook templates get to
+	// range over, small.
+	MaxLabels      = 64
+	MaxLabelLength = 256
+)
+
+const (
+	StateAlive  = "alive"
+	StateZombie = "zombie"
+	StateDead   = "dead"
+)
+
 var (
 	HeaderCanaryUpdateToken     = "X-Canary-Update-Token"
 	HeaderCanaryNextUpdateToken = "X-Canary-Next-Update-Token"
 	HeaderCanaryID              = "X-Canary-ID"
+	HeaderCanaryChainHead       = "X-Canary-Chain-Head"
 )
 
 type Canary struct {
-	ID           string   `json:"id"`
+	ID                   string   `json:"id"`
+	TimeToLive           int64    `json:"ttl"`
+	UpdatedAt            int64    `json:"updated_at"`
+	Title                string   `json:"title"`
+	Message              string   `json:"message"`
+	Labels               []string `json:"labels"`
+	Signature            string   `json:"signature"`
+	PublicKey            string   `json:"pubkey"`
+	PublicKeyUrl         string   `json:"pubkey_url"`
+	PublicKeyFingerprint string   `json:"pubkey_fingerprint,omitempty"`
+	Verified             bool     `json:"verified"`
+	Revision             int64    `json:"revision"`
+	Warnings             []int64  `json:"warnings"`
+	Owner                string   `json:"owner,omitempty"`
+	Collaborators        []string `json:"-"`
+	WarningsSent         []int64  `json:"-"`
+	UpdateToken          string   `json:"-"`
+}
+
+// MarshalJSON adds the absolute and remaining expiry of live canaries.
+func (c Canary) MarshalJSON() ([]byte, error) {
+	type canary Canary
+	v := struct {
+		canary
+		ExpiresAt *int64 `json:"expires_at,omitempty"`
+		ExpiresIn *int64 `json:"expires_in,omitempty"`
+	}{canary: canary(c)}
+
+	if !c.IsDead() {
+		expiresAt := c.ExpiresAt()
+		expiresIn := expiresAt - time.Now().Unix()
+		if expiresIn < 0 {
+			expiresIn = 0
+		}
+
+		v.ExpiresAt = &expiresAt
+		v.ExpiresIn = &expiresIn
+	}
+
+	return json.Marshal(&v)
+}
+
+type canaryContent struct {
 	TimeToLive   int64    `json:"ttl"`
-	UpdatedAt    int64    `json:"updated_at"`
 	Title        string   `json:"title"`
 	Message      string   `json:"message"`
 	Labels       []string `json:"labels"`
 	Signature    string   `json:"signature"`
 	PublicKey    string   `json:"pubkey"`
 	PublicKeyUrl string   `json:"pubkey_url"`
-	UpdateToken  string   `json:"-"`
+	Warnings     []int64  `json:"warnings"`
+}
+
+func (c *Canary) content() *canaryContent {
+	labels := c.Labels
+	if labels == nil {
+		labels = []string{}
+	}
+
+	warnings := c.Warnings
+	if warnings == nil {
+		warnings = []int64{}
+	}
+
+	return &canaryContent{
+		TimeToLive:   c.TimeToLive,
+		Title:        c.Title,
+		Message:      c.Message,
+		Labels:       labels,
+		Signature:    c.Signature,
+		PublicKey:    c.PublicKey,
+		PublicKeyUrl: c.PublicKeyUrl,
+		Warnings:     warnings,
+	}
+}
+
+// Patch applies a JSON merge patch to the user editable fields of the canary
+// and reports whether any of them changed.
+func (c *Canary) Patch(patch []byte) (bool, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &fields); err != nil || fields == nil {
+		return false, ErrPatchInvalid
+	}
+
+	for k := range fields {
+		switch k {
+		case "ttl", "title", "message", "labels", "signature", "pubkey", "pubkey_url", "warnings":
+		default:
+			return false, fmt.Errorf("field %q cannot be patched", k)
+		}
+	}
+
+	current, err := json.Marshal(c.content())
+	if err != nil {
+		return false, err
+	}
+
+	merged, err := MergePatch(current, patch)
+	if err != nil {
+		return false, err
+	}
+
+	next := &canaryContent{}
+	if err := json.Unmarshal(merged, next); err != nil {
+		return false, err
+	}
+
+	c.TimeToLive = next.TimeToLive
+	c.Title = next.Title
+	c.Message = next.Message
+	c.Labels = next.Labels
+	if c.Labels == nil {
+		c.Labels = []string{}
+	}
+
+	c.Signature = next.Signature
+	c.PublicKey = next.PublicKey
+	c.PublicKeyUrl = next.PublicKeyUrl
+	c.Warnings = next.Warnings
+
+	updated, err := json.Marshal(c.content())
+	if err != nil {
+		return false, err
+	}
+
+	return !bytes.Equal(current, updated), nil
+}
+
+// SignatureChanged reports whether the signature of c has to be verified again
+// after changing from prev, because the signed content, the signature or the
+// key it is checked against differ.
+func (c *Canary) SignatureChanged(prev *Canary) bool {
+	return c.Signature != prev.Signature || c.PublicKey != prev.PublicKey || c.PublicKeyUrl != prev.PublicKeyUrl ||
+		!bytes.Equal(c.SignedContent(), prev.SignedContent())
+}
+
+type signedContent struct {
+	Title      string   `json:"title"`
+	Message    string   `json:"message"`
+	Labels     []string `json:"labels"`
+	TimeToLive int64    `json:"ttl"`
+}
+
+// SignedContent is the canonical form covered by a canary signature: a
+// compact JSON object with the keys title, message, labels and ttl in that
+// order.
+func (c *Canary) SignedContent() []byte {
+	labels := c.Labels
+	if labels == nil {
+		labels = []string{}
+	}
+
+	content, _ := json.Marshal(&signedContent{
+		Title:      c.Title,
+		Message:    c.Message,
+		Labels:     labels,
+		TimeToLive: c.TimeToLive,
+	})
+
+	return content
 }
 
 func (c *Canary) Refresh(lastUpdateToken string) {
@@ -34,6 +200,7 @@ func (c *Canary) Refresh(lastUpdateToken string) {
 	hasher := sha256.New()
 	hasher.Write([]byte(lastUpdateToken + strconv.Itoa(int(c.UpdatedAt)) + c.ID))
 	c.UpdateToken = base64.URLEncoding.EncodeToString(hasher.Sum(nil))
+	c.WarningsSent = nil
 }
 
 func (c *Canary) Kill() {
@@ -43,24 +210,50 @@ func (c *Canary) Kill() {
 	c.Message = ""
 	c.Labels = []string{}
 	c.Signature = ""
+	c.Verified = false
+	c.WarningsSent = nil
 	c.UpdateToken = ""
 }
 
+func (c *Canary) ExpiresAt() int64 {
+	return c.UpdatedAt + c.TimeToLive
+}
+
+// IsZombie reports whether the canary missed its deadline. Like the storage
+// drivers it compares whole seconds, a canary is alive through the second it
+// expires at.
 func (c *Canary) IsZombie() bool {
-	t := time.Unix(c.UpdatedAt+c.TimeToLive, 0)
-	return time.Now().After(t)
+	return time.Now().Unix() > c.ExpiresAt()
 }
 
 func (c *Canary) IsDead() bool {
 	return c.TimeToLive < 0
 }
 
+func (c *Canary) State() string {
+	if c.IsDead() {
+		return StateDead
+	} else if c.IsZombie() {
+		return StateZombie
+	}
+
+	return StateAlive
+}
+
 func (c *Canary) Validate() error {
-	if c.TimeToLive < -1 {
+	if c.TimeToLive < 1 {
 		return errors.New("time to live must be greater than 0")
+	} else if len(c.Labels) > MaxLabels {
+		return fmt.Errorf("at most %d labels are allowed", MaxLabels)
 	}
 
-	return nil
+	for _, label := range c.Labels {
+		if len(label) > MaxLabelLength {
+			return fmt.Errorf("labels can't be longer than %d bytes", MaxLabelLength)
+		}
+	}
+
+	return validateWarnings(c.Warnings)
 }
 
 func ServeCanaryJSON(w http.ResponseWriter, c *Canary, status int) error {
@@ -72,3 +265,18 @@ func ServeCanaryJSON(w http.ResponseWriter, c *Canary, status int) error {
 
 	return nil
 }
+
+type CanaryList struct {
+	Canaries []*Canary `json:"canaries"`
+	Next     string    `json:"next,omitempty"`
+}
+
+func ServeCanaryListJSON(w http.ResponseWriter, l *CanaryList, status int) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(l); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newPatchCanary() *Canary {
+	return &Canary{
+		ID:         "canary",
+		TimeToLive: 600,
+		Title:      "title",
+		Message:    "message",
+		Labels:     []string{"env=prod"},
+		Warnings:   []int64{60},
+	}
+}
+
+func TestCanaryPatch(t *testing.T) {
+	tests := []struct {
+		patch    string
+		changed  bool
+		expected func(c *Canary)
+	}{
+		{`{}`, false, func(c *Canary) {}},
+		{`{"title":"title"}`, false, func(c *Canary) {}},
+		{`{"title":"new"}`, true, func(c *Canary) { c.Title = "new" }},
+		{`{"ttl":60,"message":"m"}`, true, func(c *Canary) { c.TimeToLive, c.Message = 60, "m" }},
+		{`{"labels":["a","b"]}`, true, func(c *Canary) { c.Labels = []string{"a", "b"} }},
+		{`{"labels":null}`, true, func(c *Canary) { c.Labels = []string{} }},
+		{`{"warnings":null}`, true, func(c *Canary) { c.Warnings = nil }},
+		{`{"message":null}`, true, func(c *Canary) { c.Message = "" }},
+		{`{"signature":"sig","pubkey":"key"}`, true, func(c *Canary) { c.Signature, c.PublicKey = "sig", "key" }},
+	}
+
+	for _, test := range tests {
+		c := newPatchCanary()
+		changed, err := c.Patch([]byte(test.patch))
+		if err != nil {
+			t.Errorf("%s: %v", test.patch, err)
+			continue
+		}
+
+		expected := newPatchCanary()
+		test.expected(expected)
+		if changed != test.changed {
+			t.Errorf("%s: expected changed %v, got %v", test.patch, test.changed, changed)
+		}
+
+		if !reflect.DeepEqual(c, expected) {
+			t.Errorf("%s: expected %+v, got %+v", test.patch, expected, c)
+		}
+	}
+}
+
+func TestCanaryPatchInvalid(t *testing.T) {
+	tests := []string{
+		``,
+		`null`,
+		`["title"]`,
+		`"title"`,
+		`{"id":"other"}`,
+		`{"update_token":"token"}`,
+		`{"owner":"mallory"}`,
+		`{"ttl":"forever"}`,
+	}
+
+	for _, patch := range tests {
+		c := newPatchCanary()
+		if _, err := c.Patch([]byte(patch)); err == nil {
+			t.Errorf("%s: expected an error", patch)
+		}
+	}
+}
+
+func TestCanarySignatureChanged(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(c *Canary)
+		changed bool
+	}{
+		{"refresh", func(c *Canary) { c.UpdatedAt++ }, false},
+		{"warnings", func(c *Canary) { c.Warnings = []int64{300} }, false},
+		{"title", func(c *Canary) { c.Title = "new" }, true},
+		{"labels", func(c *Canary) { c.Labels = []string{"env=dev"} }, true},
+		{"ttl", func(c *Canary) { c.TimeToLive = 60 }, true},
+		{"signature", func(c *Canary) { c.Signature = "other" }, true},
+		{"key", func(c *Canary) { c.PublicKey = "other" }, true},
+		{"key url", func(c *Canary) { c.PublicKeyUrl = "https://example.com/key" }, true},
+	}
+
+	for _, test := range tests {
+		prev := newPatchCanary()
+		prev.Signature, prev.PublicKey = "sig", "key"
+		c := *prev
+		test.change(&c)
+		if changed := c.SignatureChanged(prev); changed != test.changed {
+			t.Errorf("%s: expected %v, got %v", test.name, test.changed, changed)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package common
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/danielkrainas/canaria-api/uuid"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+type Delivery struct {
+	ID            string             `json:"id"`
+	Event         string             `json:"event"`
+	HookID        string             `json:"hook_id"`
+	CanaryID      string             `json:"canary_id"`
+	Hook          *WebHook           `json:"-"`
+	Canary        *Canary            `json:"canary"`
+	Subject       *WebHook           `json:"subject,omitempty"`
+	Status        string             `json:"status"`
+	Attempts      int                `json:"attempts"`
+	CreatedAt     int64              `json:"created_at"`
+	LastAttemptAt int64              `json:"last_attempt_at"`
+	NextAttemptAt int64              `json:"next_attempt_at"`
+	LastError     string             `json:"last_error,omitempty"`
+	RedeliveryOf  string             `json:"redelivery_of,omitempty"`
+	History       []*DeliveryAttempt `json:"history"`
+}
+
+// DeliveryAttempt records a single request made for a delivery and what the
+// receiver answered.
+type DeliveryAttempt struct {
+	Attempt        int                 `json:"attempt"`
+	Timestamp      int64               `json:"timestamp"`
+	Url            string              `json:"url"`
+	RequestHeaders map[string][]string `json:"request_headers"`
+	RequestBody    string              `json:"request_body"`
+	ResponseStatus int                 `json:"response_status,omitempty"`
+	ResponseBody   string              `json:"response_body,omitempty"`
+	Duration       int64               `json:"duration_ms"`
+	Error          string              `json:"error,omitempty"`
+}
+
+// DeliverySummary is the outcome of a delivery without its payload or
+// history.
+type DeliverySummary struct {
+	ID            string `json:"id"`
+	Event         string `json:"event"`
+	Status        string `json:"status"`
+	Attempts      int    `json:"attempts"`
+	CreatedAt     int64  `json:"created_at"`
+	LastAttemptAt int64  `json:"last_attempt_at"`
+	LastError     string `json:"last_error,omitempty"`
+}
+
+func NewDelivery(wh *WebHook, c *Canary, eventType string) *Delivery {
+	now := time.Now().Unix()
+	hook := *wh
+	d := &Delivery{
+		ID:            uuid.Generate(),
+		Event:         eventType,
+		HookID:        wh.ID,
+		CanaryID:      wh.CanaryID,
+		Hook:          &hook,
+		Status:        DeliveryPending,
+		CreatedAt:     now,
+		NextAttemptAt: now,
+	}
+
+	if c != nil {
+		canary := *c
+		d.Canary = &canary
+	}
+
+	return d
+}
+
+func (d *Delivery) Notification() *WebHookNotification {
+	return &WebHookNotification{
+		Action: d.Event,
+		Canary: d.Canary,
+		Hook:   d.Subject,
+	}
+}
+
+// About records the hook a hook.* event is about. Its secrets are never sent.
+func (d *Delivery) About(wh *WebHook) {
+	subject := *wh
+	subject.Secret = ""
+	subject.PreviousSecret = ""
+	subject.UpdateToken = ""
+	d.Subject = &subject
+}
+
+// Redeliver queues the original payload again for the hook as it is now.
+func (d *Delivery) Redeliver(wh *WebHook) *Delivery {
+	r := NewDelivery(wh, d.Canary, d.Event)
+	r.Subject = d.Subject
+	r.RedeliveryOf = d.ID
+	return r
+}
+
+func (d *Delivery) Record(a *DeliveryAttempt) {
+	d.History = append(d.History, a)
+}
+
+func (d *Delivery) Summary() *DeliverySummary {
+	return &DeliverySummary{
+		ID:            d.ID,
+		Event:         d.Event,
+		Status:        d.Status,
+		Attempts:      d.Attempts,
+		CreatedAt:     d.CreatedAt,
+		LastAttemptAt: d.LastAttemptAt,
+		LastError:     d.LastError,
+	}
+}
+
+func (d *Delivery) IsPending() bool {
+	return d.Status == DeliveryPending
+}
+
+func (d *Delivery) IsDue(now time.Time) bool {
+	return d.IsPending() && d.NextAttemptAt <= now.Unix()
+}
+
+func (d *Delivery) Succeeded() {
+	d.Attempts++
+	d.LastAttemptAt = time.Now().Unix()
+	d.LastError = ""
+	d.Status = DeliveryDelivered
+}
+
+func (d *Delivery) Failed(err error, retryAfter time.Duration, maxAttempts int) {
+	d.Attempts++
+	d.LastAttemptAt = time.Now().Unix()
+	d.LastError = err.Error()
+	if maxAttempts > 0 && d.Attempts >= maxAttempts {
+		d.Status = DeliveryFailed
+		d.NextAttemptAt = 0
+		return
+	}
+
+	d.NextAttemptAt = time.Now().Add(retryAfter).Unix()
+}
+
+func ServeDeliveryJSON(w http.ResponseWriter, d *Delivery, status int) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(d); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+type DeliveryList struct {
+	Deliveries []*Delivery `json:"deliveries"`
+	Next       string      `json:"next,omitempty"`
+}
+
+func ServeDeliveryListJSON(w http.ResponseWriter, l *DeliveryList, status int) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(l); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package common
+
+const (
+	EventCreated      = "created"
+	EventRefreshed    = "refreshed"
+	EventUpdated      = "updated"
+	EventExpiring     = "expiring"
+	EventZombie       = "zombie"
+	EventDead         = "dead"
+	EventHookCreated  = "hook.created"
+	EventHookUpdated  = "hook.updated"
+	EventHookDeleted  = "hook.deleted"
+	EventHookDisabled = "hook.disabled"
+	EventWildcard     = "*"
+
+	// EventPing is only sent on request and is not subscribable.
+	EventPing = "ping"
+)
+
+var EventCatalog = []string{
+	EventCreated,
+	EventRefreshed,
+	EventUpdated,
+	EventExpiring,
+	EventZombie,
+	EventDead,
+	EventHookCreated,
+	EventHookUpdated,
+	EventHookDeleted,
+	EventHookDisabled,
+	EventWildcard,
+}
+
+func IsKnownEvent(name string) bool {
+	for _, e := range EventCatalog {
+		if e == name {
+			return true
+		}
+	}
+
+	return false
+}
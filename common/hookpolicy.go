@@ -0,0 +1,138 @@
+package common
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// DefaultDeniedNetworks are the loopback, private, link-local and otherwise
+// special purpose ranges that hooks may not reach unless explicitly allowed.
+var DefaultDeniedNetworks = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.0.2.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+}
+
+var DefaultHookSchemes = []string{"http", "https"}
+
+// HookPolicy restricts where hooks may deliver to. An address is refused when
+// it is in a denied network and not in an allowed one.
+type HookPolicy struct {
+	Schemes           []string
+	Allow             []*net.IPNet
+	Deny              []*net.IPNet
+	ForbidInsecureSSL bool
+}
+
+func NewHookPolicy(schemes []string, allow []string, deny []string, forbidInsecureSSL bool) (*HookPolicy, error) {
+	p := &HookPolicy{
+		Schemes:           schemes,
+		ForbidInsecureSSL: forbidInsecureSSL,
+	}
+
+	if len(p.Schemes) == 0 {
+		p.Schemes = DefaultHookSchemes
+	}
+
+	if deny == nil {
+		deny = DefaultDeniedNetworks
+	}
+
+	var err error
+	if p.Allow, err = parseNetworks(allow); err != nil {
+		return nil, err
+	} else if p.Deny, err = parseNetworks(deny); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func parseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %v", cidr, err)
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *HookPolicy) CheckIP(ip net.IP) error {
+	if p == nil {
+		return nil
+	}
+
+	if containsIP(p.Deny, ip) && !containsIP(p.Allow, ip) {
+		return fmt.Errorf("address %s is not allowed", ip)
+	}
+
+	return nil
+}
+
+func (p *HookPolicy) CheckScheme(scheme string) error {
+	if p == nil {
+		return nil
+	}
+
+	for _, s := range p.Schemes {
+		if strings.EqualFold(s, scheme) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("url scheme %q is not allowed", scheme)
+}
+
+// CheckURL validates what can be known about a target before connecting.
+// Host names are checked again against every address they resolve to when
+// the connection is made.
+func (p *HookPolicy) CheckURL(rawurl string) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return fmt.Errorf("invalid url: %v", err)
+	} else if !u.IsAbs() || u.Hostname() == "" {
+		return fmt.Errorf("url must be absolute: %q", rawurl)
+	} else if err := p.CheckScheme(u.Scheme); err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(u.Hostname()); ip != nil {
+		return p.CheckIP(ip)
+	}
+
+	return nil
+}
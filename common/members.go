@@ -0,0 +1,136 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+const MaxCollaborators = 50
+
+var (
+	ErrInvalidUserName        = errors.New("invalid user name")
+	ErrTooManyCollaborators   = fmt.Errorf("canaries can't have more than %d collaborators", MaxCollaborators)
+	ErrCollaboratorIsOwner    = errors.New("the owner can't also be a collaborator")
+	ErrCanaryHasNoOwner       = errors.New("canary has no owner")
+	ErrCollaboratorNotGranted = errors.New("user is not a collaborator")
+
+	UserNameRegexp = regexp.MustCompile(`^[A-Za-z0-9._@+-]{1,128}$`)
+)
+
+// CanaryMembers are the users allowed to change a canary and manage its hooks.
+type CanaryMembers struct {
+	Owner         string   `json:"owner"`
+	Collaborators []string `json:"collaborators"`
+}
+
+func ValidateUserName(name string) error {
+	if !UserNameRegexp.MatchString(name) {
+		return ErrInvalidUserName
+	}
+
+	return nil
+}
+
+func (c *Canary) Members() *CanaryMembers {
+	m := &CanaryMembers{
+		Owner:         c.Owner,
+		Collaborators: c.Collaborators,
+	}
+
+	if m.Collaborators == nil {
+		m.Collaborators = []string{}
+	}
+
+	return m
+}
+
+// IsOwner reports whether user owns the canary. Canaries created without auth
+// have no owner, nobody owns them.
+func (c *Canary) IsOwner(user string) bool {
+	return c.Owner != "" && c.Owner == user
+}
+
+// IsMember reports whether user is the owner or one of the collaborators.
+func (c *Canary) IsMember(user string) bool {
+	if c.IsOwner(user) {
+		return true
+	}
+
+	for _, name := range c.Collaborators {
+		if name == user {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Redacted returns a copy of the canary without its owner, for clients that
+// aren't members of it.
+func (c *Canary) Redacted() *Canary {
+	redacted := *c
+	redacted.Owner = ""
+	return &redacted
+}
+
+// Grant adds user to the collaborators. Granting an existing collaborator
+// again does nothing.
+func (c *Canary) Grant(user string) error {
+	if err := ValidateUserName(user); err != nil {
+		return err
+	} else if c.Owner == "" {
+		return ErrCanaryHasNoOwner
+	} else if user == c.Owner {
+		return ErrCollaboratorIsOwner
+	} else if c.IsMember(user) {
+		return nil
+	} else if len(c.Collaborators) >= MaxCollaborators {
+		return ErrTooManyCollaborators
+	}
+
+	collaborators := make([]string, len(c.Collaborators), len(c.Collaborators)+1)
+	copy(collaborators, c.Collaborators)
+	c.Collaborators = append(collaborators, user)
+	return nil
+}
+
+func (c *Canary) Revoke(user string) error {
+	collaborators := make([]string, 0, len(c.Collaborators))
+	for _, name := range c.Collaborators {
+		if name != user {
+			collaborators = append(collaborators, name)
+		}
+	}
+
+	if len(collaborators) == len(c.Collaborators) {
+		return ErrCollaboratorNotGranted
+	}
+
+	c.Collaborators = collaborators
+	return nil
+}
+
+// Transfer makes user the owner. A collaborator that becomes the owner is
+// dropped from the collaborators, the previous owner keeps no access.
+func (c *Canary) Transfer(user string) error {
+	if err := ValidateUserName(user); err != nil {
+		return err
+	}
+
+	c.Revoke(user)
+	c.Owner = user
+	return nil
+}
+
+func ServeCanaryMembersJSON(w http.ResponseWriter, m *CanaryMembers, status int) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(m); err != nil {
+		return err
+	}
+
+	return nil
+}
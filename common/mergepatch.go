@@ -0,0 +1,47 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+var ErrPatchInvalid = errors.New("merge patch must be a json object")
+
+// MergePatch applies a JSON merge patch (RFC 7396) to doc.
+func MergePatch(doc []byte, patch []byte) ([]byte, error) {
+	var target interface{}
+	if len(doc) > 0 {
+		if err := json.Unmarshal(doc, &target); err != nil {
+			return nil, err
+		}
+	}
+
+	var p interface{}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(mergeValue(target, p))
+}
+
+func mergeValue(target interface{}, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = mergeValue(t[k], v)
+		}
+	}
+
+	return t
+}
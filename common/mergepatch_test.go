@@ -0,0 +1,68 @@
+package common
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// the examples of RFC 7396 appendix A.
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		doc      string
+		patch    string
+		expected string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+		{``, `{"a":"b"}`, `{"a":"b"}`},
+	}
+
+	for _, test := range tests {
+		merged, err := MergePatch([]byte(test.doc), []byte(test.patch))
+		if err != nil {
+			t.Errorf("%s + %s: %v", test.doc, test.patch, err)
+			continue
+		}
+
+		var got, expected interface{}
+		if err := json.Unmarshal(merged, &got); err != nil {
+			t.Fatal(err)
+		} else if err := json.Unmarshal([]byte(test.expected), &expected); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s + %s: expected %s, got %s", test.doc, test.patch, test.expected, merged)
+		}
+	}
+}
+
+func TestMergePatchInvalid(t *testing.T) {
+	tests := []struct {
+		doc   string
+		patch string
+	}{
+		{`{"a":"b"}`, `{"a":`},
+		{`{"a":`, `{"a":"b"}`},
+	}
+
+	for _, test := range tests {
+		if _, err := MergePatch([]byte(test.doc), []byte(test.patch)); err == nil {
+			t.Errorf("%s + %s: expected an error", test.doc, test.patch)
+		}
+	}
+}
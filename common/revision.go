@@ -0,0 +1,203 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/danielkrainas/canaria-api/uuid"
+)
+
+const (
+	RevisionCreated   = "created"
+	RevisionRefreshed = "refreshed"
+	RevisionUpdated   = "updated"
+	RevisionKilled    = "killed"
+	RevisionUnpinned  = "unpinned"
+
+	RevisionTransferred = "transferred"
+	RevisionGranted     = "granted"
+	RevisionRevoked     = "revoked"
+)
+
+type CanaryRevision struct {
+	ID                string `json:"id"`
+	CanaryID          string `json:"canary_id"`
+	Sequence          int64  `json:"sequence"`
+	Action            string `json:"action"`
+	Revision          int64  `json:"revision"`
+	Timestamp         int64  `json:"timestamp"`
+	TimeToLive        int64  `json:"ttl"`
+	Actor             string `json:"actor,omitempty"`
+	RemoteAddr        string `json:"remote_addr,omitempty"`
+	Member            string `json:"member,omitempty"`
+	ContentHash       string `json:"content_hash"`
+	PreviousTokenHash string `json:"previous_token_hash,omitempty"`
+
+	Title        string   `json:"title"`
+	Message      string   `json:"message"`
+	Labels       []string `json:"labels"`
+	UpdatedAt    int64    `json:"updated_at"`
+	Signature    string   `json:"signature"`
+	PreviousHash string   `json:"previous_hash"`
+	Hash         string   `json:"hash"`
+}
+
+func NewCanaryRevision(c *Canary, action string, lastUpdateToken string) *CanaryRevision {
+	r := &CanaryRevision{
+		ID:          uuid.Generate(),
+		CanaryID:    c.ID,
+		Action:      action,
+		Revision:    c.Revision,
+		Timestamp:   time.Now().Unix(),
+		TimeToLive:  c.TimeToLive,
+		ContentHash: hashHex(c.SignedContent()),
+		Title:       c.Title,
+		Message:     c.Message,
+		Labels:      c.Labels,
+		UpdatedAt:   c.UpdatedAt,
+		Signature:   c.Signature,
+	}
+
+	if r.Labels == nil {
+		r.Labels = []string{}
+	}
+
+	if lastUpdateToken != "" {
+		r.PreviousTokenHash = hashHex([]byte(lastUpdateToken))
+	}
+
+	return r
+}
+
+// Anonymous returns a copy of the revision without who made it, from where and
+// who it granted or took access from, for clients that aren't members of the
+// canary.
+func (r *CanaryRevision) Anonymous() *CanaryRevision {
+	anon := *r
+	anon.Actor = ""
+	anon.RemoteAddr = ""
+	anon.Member = ""
+	return &anon
+}
+
+// Chain links the revision to the one before it, prevHash is empty for the
+// first revision of a canary. Storage drivers call it while appending so that
+// sequence numbers and hashes are assigned together.
+func (r *CanaryRevision) Chain(prevSequence int64, prevHash string) {
+	r.Sequence = prevSequence + 1
+	r.PreviousHash = prevHash
+	r.Hash = r.ChainEntry().ComputeHash()
+}
+
+func (r *CanaryRevision) ChainEntry() *ChainEntry {
+	return &ChainEntry{
+		CanaryID:     r.CanaryID,
+		Sequence:     r.Sequence,
+		Action:       r.Action,
+		Title:        r.Title,
+		Message:      r.Message,
+		Labels:       r.Labels,
+		TimeToLive:   r.TimeToLive,
+		UpdatedAt:    r.UpdatedAt,
+		Signature:    r.Signature,
+		PreviousHash: r.PreviousHash,
+		Hash:         r.Hash,
+	}
+}
+
+var ErrChainBroken = errors.New("canary chain is broken")
+
+// ChainEntry is the published form of a revision. Its hash covers every
+// field except the hash itself.
+type ChainEntry struct {
+	CanaryID     string   `json:"canary_id"`
+	Sequence     int64    `json:"sequence"`
+	Action       string   `json:"action"`
+	Title        string   `json:"title"`
+	Message      string   `json:"message"`
+	Labels       []string `json:"labels"`
+	TimeToLive   int64    `json:"ttl"`
+	UpdatedAt    int64    `json:"updated_at"`
+	Signature    string   `json:"signature"`
+	PreviousHash string   `json:"previous_hash"`
+	Hash         string   `json:"hash"`
+}
+
+func (e *ChainEntry) ComputeHash() string {
+	unsealed := *e
+	unsealed.Hash = ""
+	if unsealed.Labels == nil {
+		unsealed.Labels = []string{}
+	}
+
+	data, _ := json.Marshal(&unsealed)
+	return hashHex(data)
+}
+
+// VerifyChain checks that entries form an unbroken chain for the canary that
+// starts at the first revision and ends at head.
+func VerifyChain(canaryID string, entries []*ChainEntry, head string) error {
+	prevHash := ""
+	for i, e := range entries {
+		switch {
+		case e.CanaryID != canaryID:
+			return fmt.Errorf("%v: entry %d belongs to canary %q", ErrChainBroken, i, e.CanaryID)
+		case e.Sequence != int64(i)+1:
+			return fmt.Errorf("%v: entry %d has sequence %d", ErrChainBroken, i, e.Sequence)
+		case e.PreviousHash != prevHash:
+			return fmt.Errorf("%v: entry %d does not link to its predecessor", ErrChainBroken, e.Sequence)
+		case e.ComputeHash() != e.Hash:
+			return fmt.Errorf("%v: entry %d does not match its hash", ErrChainBroken, e.Sequence)
+		}
+
+		prevHash = e.Hash
+	}
+
+	if prevHash != head {
+		return fmt.Errorf("%v: chain does not end at head %q", ErrChainBroken, head)
+	}
+
+	return nil
+}
+
+type CanaryChain struct {
+	CanaryID string        `json:"canary_id"`
+	Head     string        `json:"head"`
+	Entries  []*ChainEntry `json:"entries"`
+	Next     string        `json:"next,omitempty"`
+}
+
+func ServeCanaryChainJSON(w http.ResponseWriter, c *CanaryChain, status int) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(c); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+type CanaryHistory struct {
+	Revisions []*CanaryRevision `json:"revisions"`
+	Next      string            `json:"next,omitempty"`
+}
+
+func ServeCanaryHistoryJSON(w http.ResponseWriter, h *CanaryHistory, status int) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(h); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package common
+
+import (
+	"strings"
+	"testing"
+)
+
+func newTestChain(canaryID string) []*ChainEntry {
+	c := &Canary{
+		ID:         canaryID,
+		TimeToLive: 600,
+		Title:      "title",
+		Message:    "message",
+		Labels:     []string{"env=prod"},
+		UpdatedAt:  1000,
+	}
+
+	var entries []*ChainEntry
+	prevHash := ""
+	for i, action := range []string{RevisionCreated, RevisionRefreshed, RevisionUpdated} {
+		c.UpdatedAt += 60
+		r := NewCanaryRevision(c, action, "")
+		r.Chain(int64(i), prevHash)
+		prevHash = r.Hash
+		entries = append(entries, r.ChainEntry())
+	}
+
+	return entries
+}
+
+func TestVerifyChain(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(entries []*ChainEntry) ([]*ChainEntry, string)
+		err    string
+	}{
+		{"intact", func(entries []*ChainEntry) ([]*ChainEntry, string) {
+			return entries, entries[2].Hash
+		}, ""},
+		{"empty", func(entries []*ChainEntry) ([]*ChainEntry, string) {
+			return nil, ""
+		}, ""},
+		{"rewritten message", func(entries []*ChainEntry) ([]*ChainEntry, string) {
+			entries[1].Message = "all is well"
+			return entries, entries[2].Hash
+		}, "does not match its hash"},
+		{"rewritten and rehashed", func(entries []*ChainEntry) ([]*ChainEntry, string) {
+			entries[1].Message = "all is well"
+			entries[1].Hash = entries[1].ComputeHash()
+			return entries, entries[2].Hash
+		}, "does not link to its predecessor"},
+		{"dropped entry", func(entries []*ChainEntry) ([]*ChainEntry, string) {
+			return []*ChainEntry{entries[0], entries[2]}, entries[2].Hash
+		}, "has sequence"},
+		{"truncated", func(entries []*ChainEntry) ([]*ChainEntry, string) {
+			return entries[:2], entries[2].Hash
+		}, "does not end at head"},
+		{"missing start", func(entries []*ChainEntry) ([]*ChainEntry, string) {
+			return entries[1:], entries[2].Hash
+		}, "has sequence"},
+		{"other canary", func(entries []*ChainEntry) ([]*ChainEntry, string) {
+			entries[0].CanaryID = "other"
+			return entries, entries[2].Hash
+		}, "belongs to canary"},
+		{"wrong head", func(entries []*ChainEntry) ([]*ChainEntry, string) {
+			return entries, entries[1].Hash
+		}, "does not end at head"},
+	}
+
+	for _, test := range tests {
+		entries, head := test.tamper(newTestChain("canary"))
+		err := VerifyChain("canary", entries, head)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%s: %v", test.name, err)
+			}
+
+			continue
+		}
+
+		if err == nil || !strings.Contains(err.Error(), test.err) || !strings.Contains(err.Error(), ErrChainBroken.Error()) {
+			t.Errorf("%s: expected %q, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestChainEntryHash(t *testing.T) {
+	entries := newTestChain("canary")
+	e := *entries[0]
+	if e.ComputeHash() != e.Hash {
+		t.Fatal("expected the hash to be reproducible")
+	}
+
+	tests := []struct {
+		field  string
+		change func(e *ChainEntry)
+	}{
+		{"action", func(e *ChainEntry) { e.Action = RevisionKilled }},
+		{"title", func(e *ChainEntry) { e.Title = "other" }},
+		{"labels", func(e *ChainEntry) { e.Labels = []string{} }},
+		{"ttl", func(e *ChainEntry) { e.TimeToLive = -1 }},
+		{"updated_at", func(e *ChainEntry) { e.UpdatedAt++ }},
+		{"signature", func(e *ChainEntry) { e.Signature = "sig" }},
+		{"previous_hash", func(e *ChainEntry) { e.PreviousHash = "00" }},
+	}
+
+	for _, test := range tests {
+		changed := e
+		test.change(&changed)
+		if changed.ComputeHash() == e.Hash {
+			t.Errorf("%s: expected the hash to cover the field", test.field)
+		}
+	}
+}
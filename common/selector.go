@@ -0,0 +1,184 @@
+package common
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	SelectorExists    = "exists"
+	SelectorNotExists = "!"
+	SelectorEquals    = "="
+	SelectorNotEquals = "!="
+	SelectorIn        = "in"
+	SelectorNotIn     = "notin"
+)
+
+var (
+	setRequirementRegex = regexp.MustCompile(`^([^\s=!(),]+)\s+(in|notin)\s*\(([^()]*)\)$`)
+	labelKeyRegex       = regexp.MustCompile(`^[^\s=!(),]+$`)
+)
+
+type Requirement struct {
+	Key      string
+	Operator string
+	Values   []string
+}
+
+type Selector []Requirement
+
+func ParseSelector(s string) (Selector, error) {
+	selector := Selector{}
+	for _, term := range splitSelector(s) {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		r, err := parseRequirement(term)
+		if err != nil {
+			return nil, err
+		}
+
+		selector = append(selector, r)
+	}
+
+	return selector, nil
+}
+
+func splitSelector(s string) []string {
+	var terms []string
+	depth := 0
+	start := 0
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				terms = append(terms, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(terms, s[start:])
+}
+
+func parseRequirement(term string) (Requirement, error) {
+	r := Requirement{}
+	if m := setRequirementRegex.FindStringSubmatch(term); m != nil {
+		r.Key = m[1]
+		r.Operator = m[2]
+		for _, v := range strings.Split(m[3], ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				r.Values = append(r.Values, v)
+			}
+		}
+
+		if len(r.Values) == 0 {
+			return r, fmt.Errorf("selector %q: %s requires at least one value", term, r.Operator)
+		}
+
+		return r, nil
+	}
+
+	switch {
+	case strings.Contains(term, "!="):
+		parts := strings.SplitN(term, "!=", 2)
+		r.Key, r.Operator, r.Values = parts[0], SelectorNotEquals, []string{parts[1]}
+
+	case strings.Contains(term, "=="):
+		parts := strings.SplitN(term, "==", 2)
+		r.Key, r.Operator, r.Values = parts[0], SelectorEquals, []string{parts[1]}
+
+	case strings.Contains(term, "="):
+		parts := strings.SplitN(term, "=", 2)
+		r.Key, r.Operator, r.Values = parts[0], SelectorEquals, []string{parts[1]}
+
+	case strings.HasPrefix(term, "!"):
+		r.Key, r.Operator = term[1:], SelectorNotExists
+
+	default:
+		r.Key, r.Operator = term, SelectorExists
+	}
+
+	r.Key = strings.TrimSpace(r.Key)
+	for i, v := range r.Values {
+		r.Values[i] = strings.TrimSpace(v)
+	}
+
+	if !labelKeyRegex.MatchString(r.Key) {
+		return r, fmt.Errorf("selector %q: invalid label key", term)
+	}
+
+	return r, nil
+}
+
+// SplitLabel splits a label into the key and value selectors match on. Labels
+// are stored as plain strings, a "key=value" label is split on the first "="
+// and a bare label is treated as a key with an empty value.
+func SplitLabel(label string) (string, string) {
+	parts := strings.SplitN(label, "=", 2)
+	if len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+
+	return parts[0], ""
+}
+
+func labelSet(labels []string) map[string][]string {
+	set := make(map[string][]string, len(labels))
+	for _, label := range labels {
+		key, value := SplitLabel(label)
+		set[key] = append(set[key], value)
+	}
+
+	return set
+}
+
+func (r Requirement) matches(set map[string][]string) bool {
+	values, found := set[r.Key]
+	switch r.Operator {
+	case SelectorExists:
+		return found
+	case SelectorNotExists:
+		return !found
+	case SelectorEquals, SelectorIn:
+		return containsAny(values, r.Values)
+	case SelectorNotEquals, SelectorNotIn:
+		return !containsAny(values, r.Values)
+	}
+
+	return false
+}
+
+func (s Selector) Matches(labels []string) bool {
+	if len(s) == 0 {
+		return true
+	}
+
+	set := labelSet(labels)
+	for _, r := range s {
+		if !r.matches(set) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func containsAny(values []string, candidates []string) bool {
+	for _, v := range values {
+		for _, c := range candidates {
+			if v == c {
+				return true
+			}
+		}
+	}
+
+	return false
+}
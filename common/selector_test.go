@@ -0,0 +1,110 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		selector string
+		expected Selector
+		err      bool
+	}{
+		{"", Selector{}, false},
+		{"beta", Selector{{Key: "beta", Operator: SelectorExists}}, false},
+		{"!beta", Selector{{Key: "beta", Operator: SelectorNotExists}}, false},
+		{"env=prod", Selector{{Key: "env", Operator: SelectorEquals, Values: []string{"prod"}}}, false},
+		{"env==prod", Selector{{Key: "env", Operator: SelectorEquals, Values: []string{"prod"}}}, false},
+		{"env != prod", Selector{{Key: "env", Operator: SelectorNotEquals, Values: []string{"prod"}}}, false},
+		{"env in (prod, staging)", Selector{{Key: "env", Operator: SelectorIn, Values: []string{"prod", "staging"}}}, false},
+		{"env notin (dev)", Selector{{Key: "env", Operator: SelectorNotIn, Values: []string{"dev"}}}, false},
+		{"env in (prod,staging),beta,!legacy", Selector{
+			{Key: "env", Operator: SelectorIn, Values: []string{"prod", "staging"}},
+			{Key: "beta", Operator: SelectorExists},
+			{Key: "legacy", Operator: SelectorNotExists},
+		}, false},
+		{"env=", Selector{{Key: "env", Operator: SelectorEquals, Values: []string{""}}}, false},
+		{"env in ()", nil, true},
+		{"=prod", nil, true},
+		{"!", nil, true},
+		{"env in (prod", nil, true},
+	}
+
+	for _, test := range tests {
+		selector, err := ParseSelector(test.selector)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %+v", test.selector, selector)
+			}
+
+			continue
+		} else if err != nil {
+			t.Errorf("%q: %v", test.selector, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(selector, test.expected) {
+			t.Errorf("%q: expected %+v, got %+v", test.selector, test.expected, selector)
+		}
+	}
+}
+
+func TestSelectorMatches(t *testing.T) {
+	labels := []string{"env=prod", "team=core", "team=ops", "beta"}
+	tests := []struct {
+		selector string
+		matches  bool
+	}{
+		{"", true},
+		{"beta", true},
+		{"!beta", false},
+		{"legacy", false},
+		{"!legacy", true},
+		{"env=prod", true},
+		{"env=dev", false},
+		{"env!=dev", true},
+		{"env!=prod", false},
+		{"legacy!=yes", true},
+		{"team=ops", true},
+		{"team!=ops", false},
+		{"env in (dev, prod)", true},
+		{"env in (dev, staging)", false},
+		{"env notin (dev, staging)", true},
+		{"team notin (ops)", false},
+		{"beta=", true},
+		{"env=prod,beta", true},
+		{"env=prod,!beta", false},
+	}
+
+	for _, test := range tests {
+		selector, err := ParseSelector(test.selector)
+		if err != nil {
+			t.Errorf("%q: %v", test.selector, err)
+			continue
+		}
+
+		if matches := selector.Matches(labels); matches != test.matches {
+			t.Errorf("%q: expected %v, got %v", test.selector, test.matches, matches)
+		}
+	}
+}
+
+func TestSplitLabel(t *testing.T) {
+	tests := []struct {
+		label string
+		key   string
+		value string
+	}{
+		{"beta", "beta", ""},
+		{"env=prod", "env", "prod"},
+		{"url=http://x/?a=b", "url", "http://x/?a=b"},
+		{"env=", "env", ""},
+	}
+
+	for _, test := range tests {
+		if key, value := SplitLabel(test.label); key != test.key || value != test.value {
+			t.Errorf("%q: expected %q, %q, got %q, %q", test.label, test.key, test.value, key, value)
+		}
+	}
+}
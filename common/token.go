@@ -0,0 +1,26 @@
+package common
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// TokenResponse is what the token endpoint answers with. The token is
+// repeated as access_token for OAuth2 clients.
+type TokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+	IssuedAt    string `json:"issued_at"`
+}
+
+func ServeTokenJSON(w http.ResponseWriter, t *TokenResponse, status int) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(t); err != nil {
+		return err
+	}
+
+	return nil
+}
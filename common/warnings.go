@@ -0,0 +1,79 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+)
+
+const MaxWarnings = 10
+
+func (c *Canary) warned(offset int64) bool {
+	for _, sent := range c.WarningsSent {
+		if sent == offset {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NextWarningAt returns when the earliest pending warning of the current
+// refresh cycle is due, or 0 if there is none.
+func (c *Canary) NextWarningAt() int64 {
+	if c.IsDead() {
+		return 0
+	}
+
+	next := int64(0)
+	for _, offset := range c.Warnings {
+		if c.warned(offset) {
+			continue
+		}
+
+		if at := c.ExpiresAt() - offset; next == 0 || at < next {
+			next = at
+		}
+	}
+
+	return next
+}
+
+// DueWarnings returns the pending warning offsets whose time has come while
+// the canary is still alive.
+func (c *Canary) DueWarnings(now int64) []int64 {
+	if c.IsDead() || now >= c.ExpiresAt() {
+		return nil
+	}
+
+	var due []int64
+	for _, offset := range c.Warnings {
+		if !c.warned(offset) && c.ExpiresAt()-offset <= now {
+			due = append(due, offset)
+		}
+	}
+
+	return due
+}
+
+func (c *Canary) MarkWarned(offsets []int64) {
+	c.WarningsSent = append(c.WarningsSent, offsets...)
+}
+
+func validateWarnings(warnings []int64) error {
+	if len(warnings) > MaxWarnings {
+		return fmt.Errorf("at most %d warnings are allowed", MaxWarnings)
+	}
+
+	seen := make(map[int64]bool, len(warnings))
+	for _, offset := range warnings {
+		if offset <= 0 {
+			return errors.New("warning offsets must be greater than 0")
+		} else if seen[offset] {
+			return fmt.Errorf("duplicate warning offset: %d", offset)
+		}
+
+		seen[offset] = true
+	}
+
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strconv"
 	"time"
@@ -12,11 +13,19 @@ import (
 )
 
 const (
-	JsonContent = "json"
-	FormContent = "form"
+	HookTypeWebhook = "webhook"
 
-	EventDead = "dead"
-	EventPing = "ping"
+	JsonContent        = "json"
+	FormContent        = "form"
+	TemplateContent    = "template"
+	SlackContent       = "slack"
+	DiscordContent     = "discord"
+	PagerDutyContent   = "pagerduty"
+	CloudEventsContent = "cloudevents"
+
+	SignatureSHA1   = "sha1"
+	SignatureSHA256 = "sha256"
+	SignatureSHA512 = "sha512"
 )
 
 var (
@@ -26,22 +35,33 @@ var (
 )
 
 type WebHook struct {
-	ID          string   `json:"id"`
-	Name        string   `json:"name"`
-	ContentType string   `json:"content_type"`
-	Secret      string   `json:"secret"`
-	InsecureSSL bool     `json:"insecure_ssl"`
-	Url         string   `json:"url"`
-	Events      []string `json:"events"`
-	Active      bool     `json:"active"`
-	CanaryID    string   `json:"-"`
-	UpdateToken string   `json:"-"`
-	UpdatedAt   int64    `json:"updated_at"`
+	ID                      string            `json:"id"`
+	Name                    string            `json:"name"`
+	Type                    string            `json:"type"`
+	Options                 map[string]string `json:"options,omitempty"`
+	ContentType             string            `json:"content_type"`
+	Template                string            `json:"template,omitempty"`
+	Secret                  string            `json:"-"`
+	SecretFingerprint       string            `json:"secret_fingerprint,omitempty"`
+	PreviousSecret          string            `json:"-"`
+	PreviousSecretExpiresAt int64             `json:"previous_secret_expires_at,omitempty"`
+	SignatureAlgorithm      string            `json:"signature_algorithm"`
+	InsecureSSL             bool              `json:"insecure_ssl"`
+	Url                     string            `json:"url"`
+	Events                  []string          `json:"events"`
+	Active                  bool              `json:"active"`
+	ConsecutiveFailures     int               `json:"consecutive_failures"`
+	LastError               string            `json:"last_error,omitempty"`
+	DisabledAt              int64             `json:"disabled_at,omitempty"`
+	CanaryID                string            `json:"-"`
+	UpdateToken             string            `json:"-"`
+	UpdatedAt               int64             `json:"updated_at"`
 }
 
 type EditHookRequest struct {
 	Name   string      `json:"name"`
-	Config *HookConfig `json:"config, omitempty"`
+	Type   string      `json:"type,omitempty"`
+	Config *HookConfig `json:"config,omitempty"`
 	Events []string    `json:"events"`
 	Active bool        `json:"active"`
 }
@@ -49,35 +69,117 @@ type EditHookRequest struct {
 func (r *EditHookRequest) Hook() *WebHook {
 	hook := NewWebHook()
 	hook.Name = r.Name
-	hook.Update(r, "")
+	hook.Update(r, "", 0)
 	return hook
 }
 
 type HookConfig struct {
-	Url         string `json:"url, omitempty"`
-	ContentType string `json:"content_type, omitempty"`
-	Secret      string `json:"secret, omitempty"`
-	InsecureSSL bool   `json:"insecure_ssl, omitempty"`
+	Url                string  `json:"url,omitempty"`
+	ContentType        string  `json:"content_type,omitempty"`
+	Template           string  `json:"template,omitempty"`
+	Secret             *string `json:"secret,omitempty"`
+	SignatureAlgorithm string  `json:"signature_algorithm,omitempty"`
+	InsecureSSL        bool    `json:"insecure_ssl,omitempty"`
+
+	// Options configure notifiers other than webhooks, see the notifier
+	// packages for what each accepts.
+	Options map[string]string `json:"options,omitempty"`
 }
 
 type WebHookNotification struct {
-	Action string  `json:"action"`
-	Canary *Canary `json:"canary"`
+	Action string   `json:"action"`
+	Canary *Canary  `json:"canary"`
+	Hook   *WebHook `json:"hook,omitempty"`
 }
 
 func NewWebHook() *WebHook {
 	return &WebHook{
 		ID:     uuid.Generate(),
+		Type:   HookTypeWebhook,
 		Events: []string{},
 	}
 }
 
+// Validate checks the parts of the hook shared by every notifier. The target
+// itself is validated by the hook's notifier.
 func (h *WebHook) Validate() error {
+	switch h.SignatureAlgorithm {
+	case "", SignatureSHA1, SignatureSHA256, SignatureSHA512:
+	default:
+		return fmt.Errorf("unsupported signature algorithm: %q", h.SignatureAlgorithm)
+	}
+
+	for _, e := range h.Events {
+		if !IsKnownEvent(e) {
+			return fmt.Errorf("unknown event: %q", e)
+		}
+	}
+
 	return nil
 }
 
-func (h *WebHook) Deactivate() {
-	h.Active = false
+// Subscribed reports whether the hook wants to be notified of the event. A
+// hook without any events is subscribed to everything.
+func (h *WebHook) Subscribed(event string) bool {
+	if event == EventPing || len(h.Events) == 0 {
+		return true
+	}
+
+	for _, e := range h.Events {
+		if e == EventWildcard || e == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RecordFailure counts a failed delivery attempt and disables the hook once
+// threshold attempts in a row have failed. It reports whether the hook was
+// disabled by this failure.
+func (h *WebHook) RecordFailure(err error, threshold int) bool {
+	h.ConsecutiveFailures++
+	h.LastError = err.Error()
+	if threshold > 0 && h.Active && h.ConsecutiveFailures >= threshold {
+		h.Active = false
+		h.DisabledAt = time.Now().Unix()
+		return true
+	}
+
+	return false
+}
+
+// RecordSuccess resets the failure count and reports whether it changed.
+func (h *WebHook) RecordSuccess() bool {
+	if h.ConsecutiveFailures == 0 {
+		return false
+	}
+
+	h.ConsecutiveFailures = 0
+	return true
+}
+
+// IsDisabled reports whether the hook was switched off after failing, as
+// opposed to being deactivated by its owner.
+func (h *WebHook) IsDisabled() bool {
+	return !h.Active && h.DisabledAt != 0
+}
+
+func (h *WebHook) Enable() {
+	h.Active = true
+	h.ConsecutiveFailures = 0
+	h.LastError = ""
+	h.DisabledAt = 0
+}
+
+// NotifierType is the type of notifier that delivers the hook's events. Hooks
+// stored before notifiers were pluggable are webhooks.
+func (h *WebHook) NotifierType() string {
+	if h.Type == "" {
+		return HookTypeWebhook
+	}
+
+	return h.Type
 }
 
 func (h *WebHook) generateNextToken(lastUpdateToken string) {
@@ -86,12 +188,34 @@ func (h *WebHook) generateNextToken(lastUpdateToken string) {
 	h.UpdateToken = base64.URLEncoding.EncodeToString(hasher.Sum(nil))
 }
 
-func (h *WebHook) Update(edit *EditHookRequest, lastUpdateToken string) {
+// Update applies the edit to the hook. Secrets are write-only so a config
+// without a secret keeps the current one, an empty secret removes it.
+func (h *WebHook) Update(edit *EditHookRequest, lastUpdateToken string, secretOverlap time.Duration) {
 	if edit.Config != nil {
 		h.ContentType = edit.Config.ContentType
-		h.Secret = edit.Config.Secret
+		h.Template = edit.Config.Template
+		if edit.Config.Secret != nil {
+			h.RotateSecret(*edit.Config.Secret, secretOverlap)
+		}
+
+		h.SignatureAlgorithm = edit.Config.SignatureAlgorithm
 		h.Url = edit.Config.Url
 		h.InsecureSSL = edit.Config.InsecureSSL
+		h.Options = nil
+		if len(edit.Config.Options) > 0 {
+			h.Options = make(map[string]string, len(edit.Config.Options))
+			for k, v := range edit.Config.Options {
+				h.Options[k] = v
+			}
+		}
+	}
+
+	if edit.Type != "" {
+		h.Type = edit.Type
+	}
+
+	if edit.Active && !h.Active {
+		h.Enable()
 	}
 
 	h.Active = edit.Active
@@ -106,6 +230,68 @@ func (h *WebHook) Update(edit *EditHookRequest, lastUpdateToken string) {
 	h.generateNextToken(lastUpdateToken)
 }
 
+// RotateSecret replaces the secret. The old secret keeps signing deliveries
+// alongside the new one until the overlap has passed so that receivers can be
+// updated without dropping deliveries.
+func (h *WebHook) RotateSecret(secret string, overlap time.Duration) {
+	if secret == h.Secret {
+		return
+	}
+
+	h.PreviousSecret = ""
+	h.PreviousSecretExpiresAt = 0
+	if h.Secret != "" && secret != "" && overlap > 0 {
+		h.PreviousSecret = h.Secret
+		h.PreviousSecretExpiresAt = time.Now().Add(overlap).Unix()
+	}
+
+	h.Secret = secret
+	h.SecretFingerprint = ""
+	if secret != "" {
+		h.SecretFingerprint = uuid.Generate()
+	}
+}
+
+// SigningSecrets returns the secrets deliveries must currently be signed with,
+// newest first.
+func (h *WebHook) SigningSecrets(now time.Time) []string {
+	secrets := make([]string, 0, 2)
+	if h.Secret != "" {
+		secrets = append(secrets, h.Secret)
+	}
+
+	if h.PreviousSecret != "" && now.Unix() < h.PreviousSecretExpiresAt {
+		secrets = append(secrets, h.PreviousSecret)
+	}
+
+	return secrets
+}
+
+// HasSecret reports whether the hook signs its deliveries. Delivery snapshots
+// drop the secret but keep its fingerprint.
+func (h *WebHook) HasSecret() bool {
+	return h.Secret != "" || h.SecretFingerprint != ""
+}
+
+// MarshalJSON reports whether a secret is set, the secret itself is never
+// serialized.
+func (h WebHook) MarshalJSON() ([]byte, error) {
+	type webHook WebHook
+	v := struct {
+		webHook
+		HasSecret bool `json:"has_secret"`
+	}{
+		webHook:   webHook(h),
+		HasSecret: h.HasSecret(),
+	}
+
+	if v.PreviousSecretExpiresAt <= time.Now().Unix() {
+		v.PreviousSecretExpiresAt = 0
+	}
+
+	return json.Marshal(&v)
+}
+
 func ServeWebHookJSON(w http.ResponseWriter, h *WebHook, status int) error {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.WriteHeader(status)
@@ -115,3 +301,73 @@ func ServeWebHookJSON(w http.ResponseWriter, h *WebHook, status int) error {
 
 	return nil
 }
+
+// HookSummary is the listing view of a hook. Its config never includes the
+// secret, only whether one is set.
+type HookSummary struct {
+	ID                  string             `json:"id"`
+	Name                string             `json:"name"`
+	Type                string             `json:"type"`
+	Config              *HookConfigSummary `json:"config"`
+	Events              []string           `json:"events"`
+	Active              bool               `json:"active"`
+	ConsecutiveFailures int                `json:"consecutive_failures"`
+	DisabledAt          int64              `json:"disabled_at,omitempty"`
+	UpdatedAt           int64              `json:"updated_at"`
+	LastDelivery        *DeliverySummary   `json:"last_delivery,omitempty"`
+}
+
+type HookConfigSummary struct {
+	Url                string            `json:"url"`
+	ContentType        string            `json:"content_type"`
+	Template           string            `json:"template,omitempty"`
+	SignatureAlgorithm string            `json:"signature_algorithm"`
+	InsecureSSL        bool              `json:"insecure_ssl"`
+	HasSecret          bool              `json:"has_secret"`
+	SecretFingerprint  string            `json:"secret_fingerprint,omitempty"`
+	Options            map[string]string `json:"options,omitempty"`
+}
+
+func (h *WebHook) Summary(lastDelivery *Delivery) *HookSummary {
+	s := &HookSummary{
+		ID:   h.ID,
+		Name: h.Name,
+		Type: h.NotifierType(),
+		Config: &HookConfigSummary{
+			Url:                h.Url,
+			ContentType:        h.ContentType,
+			Template:           h.Template,
+			SignatureAlgorithm: h.SignatureAlgorithm,
+			InsecureSSL:        h.InsecureSSL,
+			HasSecret:          h.HasSecret(),
+			SecretFingerprint:  h.SecretFingerprint,
+			Options:            h.Options,
+		},
+		Events:              h.Events,
+		Active:              h.Active,
+		ConsecutiveFailures: h.ConsecutiveFailures,
+		DisabledAt:          h.DisabledAt,
+		UpdatedAt:           h.UpdatedAt,
+	}
+
+	if lastDelivery != nil {
+		s.LastDelivery = lastDelivery.Summary()
+	}
+
+	return s
+}
+
+type HookList struct {
+	Hooks []*HookSummary `json:"hooks"`
+	Next  string         `json:"next,omitempty"`
+}
+
+func ServeHookListJSON(w http.ResponseWriter, l *HookList, status int) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(l); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"reflect"
 	"strings"
+	"time"
 )
 
 func (version *Version) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -62,13 +63,13 @@ func (storage Storage) setParameter(key string, value interface{}) {
 func (storage *Storage) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	var storageMap map[string]Parameters
 	err := unmarshal(&storageMap)
-	if err == nil && len(storageMap) > 1 {
-		types := make([]string, 0, len(storageMap))
-		for k := range storageMap {
-			types = append(types, k)
-		}
+	if err == nil {
+		if len(storageMap) > 1 {
+			types := make([]string, 0, len(storageMap))
+			for k := range storageMap {
+				types = append(types, k)
+			}
 
-		if len(types) > 1 {
 			return fmt.Errorf("Must provide exactly one storage type. provided: %v", types)
 		}
 
@@ -148,13 +149,24 @@ func (auth Auth) MarshalYAML() (interface{}, error) {
 	return map[string]Parameters(auth), nil
 }
 
+// Notifiers enables notifiers other than webhooks, keyed by the hook type
+// they handle.
+type Notifiers map[string]Parameters
+
 type HTTPConfig struct {
 	Addr         string
 	Net          string
 	Host         string
 	Headers      http.Header
-	RelativeURLs bool      `yaml:"relativeurls"`
-	TLS          TLSConfig `yaml:"tls,omitempty"`
+	RelativeURLs bool        `yaml:"relativeurls"`
+	TLS          TLSConfig   `yaml:"tls,omitempty"`
+	Debug        DebugConfig `yaml:"debug,omitempty"`
+}
+
+// DebugConfig enables a separate listener serving /debug/vars, which
+// includes the delivery queue's stats.
+type DebugConfig struct {
+	Addr string `yaml:"addr,omitempty"`
 }
 
 type TLSConfig struct {
@@ -195,11 +207,101 @@ type LogConfig struct {
 	Fields    map[string]interface{} `yaml:"fields,omitempty"`
 }
 
+type NotificationsConfig struct {
+	MaxAttempts int           `yaml:"maxattempts,omitempty"`
+	Backoff     time.Duration `yaml:"backoff,omitempty"`
+	MaxBackoff  time.Duration `yaml:"maxbackoff,omitempty"`
+	Interval    time.Duration `yaml:"interval,omitempty"`
+
+	// FailureThreshold is the number of delivery attempts in a row that may
+	// fail before a hook is disabled.
+	FailureThreshold int `yaml:"failurethreshold,omitempty"`
+
+	// Workers is the number of deliveries attempted at the same time, at most
+	// PerHostLimit of them to the same destination host.
+	Workers      int `yaml:"workers,omitempty"`
+	PerHostLimit int `yaml:"perhostlimit,omitempty"`
+
+	// QueueSize bounds the due deliveries handed to the workers. Deliveries
+	// that don't fit stay pending in storage until there is room.
+	QueueSize int `yaml:"queuesize,omitempty"`
+
+	// DrainTimeout is how long a shutdown waits for due deliveries to go out.
+	DrainTimeout time.Duration `yaml:"draintimeout,omitempty"`
+}
+
+type ReaperConfig struct {
+	Interval time.Duration `yaml:"interval,omitempty"`
+}
+
+type SignaturesConfig struct {
+	KeyCacheTTL  time.Duration `yaml:"keycachettl,omitempty"`
+	FetchTimeout time.Duration `yaml:"fetchtimeout,omitempty"`
+}
+
+type SecretsConfig struct {
+	Key             string        `yaml:"key,omitempty"`
+	RotationOverlap time.Duration `yaml:"rotationoverlap,omitempty"`
+}
+
+// TokenServerConfig enables the token endpoint, which issues the tokens the
+// token auth strategy asks for.
+type TokenServerConfig struct {
+	Issuer  string `yaml:"issuer"`
+	Service string `yaml:"service"`
+
+	// Expiration is how long issued tokens are valid for.
+	Expiration time.Duration `yaml:"expiration,omitempty"`
+
+	// SigningKey is a PEM encoded RSA, P-256 or Ed25519 private key. When
+	// Certificate is set, its chain is sent along with the tokens, otherwise
+	// the key has to be among the token strategy's trusted keys.
+	SigningKey  string `yaml:"signingkey"`
+	Certificate string `yaml:"certificate,omitempty"`
+
+	// Backend is the auth strategy that checks the credentials of clients
+	// asking for tokens. It has to support usernames and passwords.
+	Backend Auth `yaml:"backend"`
+
+	Policy []TokenPolicyRule `yaml:"policy,omitempty"`
+}
+
+// TokenPolicyRule grants access to an account, or to every account when it
+// is *. Names in the access entries may be patterns.
+type TokenPolicyRule struct {
+	Account string        `yaml:"account"`
+	Access  []TokenAccess `yaml:"access"`
+}
+
+type TokenAccess struct {
+	Type    string   `yaml:"type"`
+	Name    string   `yaml:"name"`
+	Actions []string `yaml:"actions"`
+}
+
+func (tc TokenServerConfig) Enabled() bool {
+	return tc.SigningKey != ""
+}
+
+type WebhooksConfig struct {
+	Schemes           []string `yaml:"schemes,omitempty"`
+	Allow             []string `yaml:"allow,omitempty"`
+	Deny              []string `yaml:"deny,omitempty"`
+	ForbidInsecureSSL bool     `yaml:"forbidinsecuressl,omitempty"`
+}
+
 type Config struct {
-	Log     LogConfig  `yaml:"log"`
-	Storage Storage    `yaml:"storage"`
-	Auth    Auth       `yaml:"auth,omitempty"`
-	HTTP    HTTPConfig `yaml:"http"`
+	Log           LogConfig           `yaml:"log"`
+	Storage       Storage             `yaml:"storage"`
+	Auth          Auth                `yaml:"auth,omitempty"`
+	HTTP          HTTPConfig          `yaml:"http"`
+	Notifications NotificationsConfig `yaml:"notifications,omitempty"`
+	Reaper        ReaperConfig        `yaml:"reaper,omitempty"`
+	Signatures    SignaturesConfig    `yaml:"signatures,omitempty"`
+	Secrets       SecretsConfig       `yaml:"secrets,omitempty"`
+	Webhooks      WebhooksConfig      `yaml:"webhooks,omitempty"`
+	Notifiers     Notifiers           `yaml:"notifiers,omitempty"`
+	TokenServer   TokenServerConfig   `yaml:"tokenserver,omitempty"`
 }
 
 type v0_1Config Config
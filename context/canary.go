@@ -19,3 +19,7 @@ func GetCanary(ctx Context) *common.Canary {
 func GetCanaryID(ctx Context) string {
 	return GetStringValue(ctx, "vars.canary_id")
 }
+
+func GetCollaboratorName(ctx Context) string {
+	return GetStringValue(ctx, "vars.user")
+}
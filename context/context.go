@@ -2,6 +2,7 @@ package context
 
 import (
 	"sync"
+	"time"
 
 	"golang.org/x/net/context"
 
@@ -59,6 +60,18 @@ func WithValue(parent Context, key interface{}, val interface{}) Context {
 	return context.WithValue(parent, key, val)
 }
 
+var DeadlineExceeded = context.DeadlineExceeded
+
+type CancelFunc = context.CancelFunc
+
+func WithCancel(parent Context) (Context, CancelFunc) {
+	return context.WithCancel(parent)
+}
+
+func WithTimeout(parent Context, timeout time.Duration) (Context, context.CancelFunc) {
+	return context.WithTimeout(parent, timeout)
+}
+
 func (ctx stringMapContext) Value(key interface{}) interface{} {
 	if ks, ok := key.(string); ok {
 		if v, ok := ctx.vals[ks]; ok {
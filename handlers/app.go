@@ -1,14 +1,21 @@
 package handlers
 
 import (
+	"encoding/base64"
+	"expvar"
 	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/danielkrainas/canaria-api/actions"
 	"github.com/danielkrainas/canaria-api/api/errcode"
 	"github.com/danielkrainas/canaria-api/api/v1"
 	"github.com/danielkrainas/canaria-api/auth"
+	"github.com/danielkrainas/canaria-api/auth/token"
+	"github.com/danielkrainas/canaria-api/common"
 	"github.com/danielkrainas/canaria-api/configuration"
 	"github.com/danielkrainas/canaria-api/context"
+	"github.com/danielkrainas/canaria-api/notifier"
 	"github.com/danielkrainas/canaria-api/storage"
 	"github.com/danielkrainas/canaria-api/storage/factory"
 
@@ -26,8 +33,20 @@ type App struct {
 
 	storage storage.StorageDriver
 
+	deliveries *actions.DeliveryQueue
+
+	reaper *actions.Reaper
+
+	keys *actions.KeyResolver
+
+	secretOverlap time.Duration
+
+	notifiers notifier.Set
+
 	authStrategy auth.AuthStrategy
 
+	issuer *token.Issuer
+
 	readOnly bool
 }
 
@@ -82,9 +101,17 @@ func NewApp(ctx context.Context, config *configuration.Config) *App {
 
 	app.register(v1.RouteNameCanaries, canariesDispatcher)
 	app.register(v1.RouteNameCanary, canaryDispatcher)
+	app.register(v1.RouteNameHistory, historyDispatcher)
+	app.register(v1.RouteNameChain, chainDispatcher)
+	app.register(v1.RouteNamePin, pinDispatcher)
+	app.register(v1.RouteNameOwner, ownerDispatcher)
+	app.register(v1.RouteNameCollaborators, collaboratorsDispatcher)
+	app.register(v1.RouteNameCollaborator, collaboratorDispatcher)
 	app.register(v1.RouteNameWebhook, webhookDispatcher)
 	app.register(v1.RouteNameWebhooks, webhooksDispatcher)
 	app.register(v1.RouteNameWebhookTest, webhookTestDispatcher)
+	app.register(v1.RouteNameDeliveries, deliveriesDispatcher)
+	app.register(v1.RouteNameRedeliver, redeliverDispatcher)
 
 	storageParams := config.Storage.Parameters()
 	if storageParams == nil {
@@ -97,6 +124,15 @@ func NewApp(ctx context.Context, config *configuration.Config) *App {
 	}
 
 	context.GetLogger(app).Debugf("using %q storage driver", config.Storage.Type())
+	storage, err = sealSecrets(app, storage, config.Secrets)
+	if err != nil {
+		panic(fmt.Sprintf("unable to configure secret encryption: %v", err))
+	}
+
+	app.secretOverlap = config.Secrets.RotationOverlap
+	if app.secretOverlap <= 0 {
+		app.secretOverlap = defaultSecretRotationOverlap
+	}
 
 	authType := config.Auth.Type()
 	if authType != "" {
@@ -109,10 +145,102 @@ func NewApp(ctx context.Context, config *configuration.Config) *App {
 		context.GetLogger(app).Debugf("using %q access strategy", authType)
 	}
 
+	if config.TokenServer.Enabled() {
+		issuer, err := token.NewIssuer(config.TokenServer)
+		if err != nil {
+			panic(fmt.Sprintf("unable to configure token server: %v", err))
+		}
+
+		app.issuer = issuer
+		app.register(v1.RouteNameToken, tokenDispatcher)
+		context.GetLogger(app).Infof("issuing tokens for %q", config.TokenServer.Service)
+	}
+
+	policy, err := common.NewHookPolicy(config.Webhooks.Schemes, config.Webhooks.Allow, config.Webhooks.Deny, config.Webhooks.ForbidInsecureSSL)
+	if err != nil {
+		panic(fmt.Sprintf("invalid webhook policy: %v", err))
+	}
+
 	app.storage = storage
+	app.keys = actions.NewKeyResolver(config.Signatures, policy)
+	app.notifiers, err = configureNotifiers(app, config, policy)
+	if err != nil {
+		panic(fmt.Sprintf("unable to configure notifiers: %v", err))
+	}
+
+	app.deliveries = actions.NewDeliveryQueue(app, storage, app.notifiers, config.Notifications)
+	app.deliveries.Start()
+	expvar.Publish("deliveries", expvar.Func(func() interface{} {
+		return app.deliveries.Stats()
+	}))
+
+	app.reaper = actions.NewReaper(app, storage, app.deliveries, config.Reaper)
+	app.reaper.Start()
 	return app
 }
 
+// configureNotifiers sets up the webhook notifier, which is always available,
+// and any other notifiers enabled in the configuration.
+func configureNotifiers(ctx context.Context, config *configuration.Config, policy *common.HookPolicy) (notifier.Set, error) {
+	ns := notifier.Set{
+		common.HookTypeWebhook: actions.NewWebhookNotifier(policy),
+	}
+
+	for name, params := range config.Notifiers {
+		if name == common.HookTypeWebhook {
+			return nil, fmt.Errorf("%s notifier is configured through the webhooks section", name)
+		}
+
+		n, err := notifier.GetNotifier(name, params)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+
+		ns[name] = n
+		context.GetLogger(ctx).Debugf("using %q notifier", name)
+	}
+
+	return ns, nil
+}
+
+const defaultSecretRotationOverlap = 24 * time.Hour
+
+// sealSecrets wraps the driver so that webhook secrets are encrypted at rest
+// with the configured key, a base64 encoded 32 byte AES key.
+func sealSecrets(ctx context.Context, d storage.StorageDriver, config configuration.SecretsConfig) (storage.StorageDriver, error) {
+	if config.Key == "" {
+		context.GetLogger(ctx).Warnf("no secrets key configured, webhook secrets will be stored unencrypted")
+		return storage.WithSecretCipher(d, nil), nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(config.Key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secrets key: %v", err)
+	}
+
+	sc, err := storage.NewSecretCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.WithSecretCipher(d, sc), nil
+}
+
+const defaultDrainTimeout = 30 * time.Second
+
+// Shutdown stops the reaper and gives due deliveries until the configured
+// drain timeout to go out.
+func (app *App) Shutdown() {
+	app.reaper.Stop()
+
+	timeout := app.Config.Notifications.DrainTimeout
+	if timeout <= 0 {
+		timeout = defaultDrainTimeout
+	}
+
+	app.deliveries.Drain(timeout)
+}
+
 func (app *App) loadWebhook(ctx *appRequestContext) error {
 	canary := context.GetCanary(ctx)
 	if canary != nil {
@@ -123,6 +251,13 @@ func (app *App) loadWebhook(ctx *appRequestContext) error {
 			return v1.ErrorCodeWebhookUnknown
 		}
 
+		// hook ids are global, a hook of another canary must not be reachable
+		// through this one's routes.
+		if hook.CanaryID != canary.ID {
+			context.GetLogger(ctx).Warnf("hook %s does not belong to canary %s", hook.ID, canary.ID)
+			return v1.ErrorCodeWebhookUnknown
+		}
+
 		ctx.Context = context.WithCanaryHook(ctx.Context, hook)
 		ctx.Context = context.WithLogger(ctx.Context, context.GetLoggerWithField(ctx.Context, "hook.id", hook.ID))
 	} else {
@@ -133,8 +268,9 @@ func (app *App) loadWebhook(ctx *appRequestContext) error {
 	return nil
 }
 
-func (app *App) loadCanary(ctx *appRequestContext) error {
-	if canary, err := app.storage.Canaries().Get(ctx, context.GetCanaryID(ctx)); err != nil {
+func (app *App) loadCanary(ctx *appRequestContext, r *http.Request) error {
+	canary, err := app.storage.Canaries().Get(ctx, context.GetCanaryID(ctx))
+	if err != nil {
 		context.GetLogger(ctx).Errorf("error resolving canary: %v", err)
 		// TODO: come back to this, append unknown or invalid error
 		/*switch err := err.(type) {
@@ -147,18 +283,18 @@ func (app *App) loadCanary(ctx *appRequestContext) error {
 			context.GetLogger(ctx).Warnf("requested canary is dead: %s", canary.ID)
 		} else {
 			context.GetLoggerWithField(ctx, "canary.id", canary.ID).Warnf("killing zombie")
-			canary.Kill()
-			if err := app.storage.Canaries().Store(ctx, canary); err != nil {
+			if err := actions.KillZombie(ctx, app.storage, app.deliveries, canary); err != nil {
 				context.GetLogger(ctx).Errorf("error killing zombie canary: %v", err)
 			}
 		}
 
-		return v1.ErrorCodeCanaryDead
-	} else {
-		ctx.Context = context.WithCanary(ctx.Context, canary)
-		ctx.Context = context.WithLogger(ctx.Context, context.GetLoggerWithField(ctx.Context, "canary.id", canary.ID))
+		if !app.deadCanaryAllowed(r) {
+			return v1.ErrorCodeCanaryDead
+		}
 	}
 
+	ctx.Context = context.WithCanary(ctx.Context, canary)
+	ctx.Context = context.WithLogger(ctx.Context, context.GetLoggerWithField(ctx.Context, "canary.id", canary.ID))
 	return nil
 }
 
@@ -181,7 +317,11 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 		ctx.Context = context.WithErrors(ctx.Context, make(errcode.Errors, 0))
 
 		if app.canaryIdRequired(r) {
-			err := app.loadCanary(ctx)
+			err := app.loadCanary(ctx, r)
+			if err == nil {
+				err = app.permitted(ctx, r)
+			}
+
 			if err == nil && app.hookIdRequired(r) {
 				err = app.loadWebhook(ctx)
 			}
@@ -246,10 +386,16 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, ctx *appReque
 		return nil
 	}
 
+	// clients come to the token route for the credentials the strategy asks
+	// for, it authenticates them itself.
+	if route := mux.CurrentRoute(r); route != nil && route.GetName() == v1.RouteNameToken {
+		return nil
+	}
+
 	var accessRecords []auth.Access
 	canaryId := context.GetCanaryID(ctx)
 	if canaryId != "" {
-		accessRecords = appendAccessRecords(accessRecords, r.Method, canaryId)
+		accessRecords = appendAccessRecords(accessRecords, r, canaryId)
 	} else {
 		if app.canaryIdRequired(r) {
 			if err := errcode.ServeJSON(w, errcode.ErrorCodeUnauthorized); err != nil {
@@ -273,6 +419,15 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, ctx *appReque
 			}
 
 		default:
+			if err == auth.ErrAccessDenied {
+				errResult := errcode.ErrorCodeDenied.WithDetail(accessRecords)
+				if err := errcode.ServeJSON(w, errResult); err != nil {
+					context.GetLogger(ctx).Errorf("error serving error json: %v (from %v)", err, errResult)
+				}
+
+				break
+			}
+
 			context.GetLogger(ctx).Errorf("error checking authorization: %v", err)
 			w.WriteHeader(http.StatusBadRequest)
 		}
@@ -284,13 +439,27 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, ctx *appReque
 	return nil
 }
 
-func appendAccessRecords(records []auth.Access, method string, canaryId string) []auth.Access {
+func appendAccessRecords(records []auth.Access, r *http.Request, canaryId string) []auth.Access {
 	resource := auth.Resource{
 		Type: "canary",
 		Name: canaryId,
 	}
 
-	switch method {
+	switch routeName(r) {
+	case v1.RouteNameWebhook, v1.RouteNameWebhooks, v1.RouteNameWebhookTest, v1.RouteNameDeliveries, v1.RouteNameRedeliver:
+		return appendHookAccessRecords(records, r, canaryId)
+
+	case v1.RouteNameOwner, v1.RouteNameCollaborator, v1.RouteNamePin:
+		return append(records, auth.Access{
+			Resource: resource,
+			Action:   "read",
+		}, auth.Access{
+			Resource: resource,
+			Action:   "write",
+		})
+	}
+
+	switch r.Method {
 	case "GET", "HEAD":
 		records = append(records, auth.Access{
 			Resource: resource,
@@ -319,7 +488,161 @@ func appendAccessRecords(records []auth.Access, method string, canaryId string)
 	return records
 }
 
+// hooks are a resource of their own so that access to a canary's hooks can be
+// given without the right to refresh or kill the canary itself.
+func appendHookAccessRecords(records []auth.Access, r *http.Request, canaryId string) []auth.Access {
+	resource := auth.Resource{
+		Type: "hook",
+		Name: canaryId,
+	}
+
+	records = append(records, auth.Access{
+		Resource: resource,
+		Action:   "read",
+	})
+
+	// pinging a hook sends a delivery, so it counts as a write.
+	if (r.Method != "GET" && r.Method != "HEAD") || routeName(r) == v1.RouteNameWebhookTest {
+		records = append(records, auth.Access{
+			Resource: resource,
+			Action:   "write",
+		})
+	}
+
+	return records
+}
+
+const (
+	roleAny = iota
+	roleMember
+	roleOwner
+)
+
+// requiredRole is who, of the people the strategy lets through, may use the
+// route on an owned canary.
+func requiredRole(r *http.Request) int {
+	switch routeName(r) {
+	case v1.RouteNameCanary:
+		if r.Method == "POST" || r.Method == "DELETE" {
+			return roleMember
+		}
+
+	case v1.RouteNameCollaborators, v1.RouteNameWebhook, v1.RouteNameWebhooks, v1.RouteNameWebhookTest, v1.RouteNameDeliveries, v1.RouteNameRedeliver:
+		return roleMember
+
+	case v1.RouteNameOwner, v1.RouteNameCollaborator, v1.RouteNamePin:
+		return roleOwner
+	}
+
+	return roleAny
+}
+
+// permitted checks the user against the owner and collaborators of the
+// loaded canary. Strategies only decide what a user may do to canaries in
+// general, apart from letting admins past these checks.
+func (app *App) permitted(ctx context.Context, r *http.Request) error {
+	c := context.GetCanary(ctx)
+	if app.authStrategy == nil || c == nil {
+		return nil
+	}
+
+	user := context.GetStringValue(ctx, auth.UserNameKey)
+	switch requiredRole(r) {
+	case roleMember:
+		if c.IsMember(user) {
+			return nil
+		}
+
+	case roleOwner:
+		if c.IsOwner(user) {
+			return nil
+		}
+
+	default:
+		return nil
+	}
+
+	if app.admin(ctx) {
+		context.GetLogger(ctx).Infof("admin %q acting on canary owned by %q", user, c.Owner)
+		return nil
+	}
+
+	return errcode.ErrorCodeDenied
+}
+
+// member reports whether the user is a member of the loaded canary or an
+// admin. Without auth nobody is, there are no users to tell apart.
+func (app *App) member(ctx context.Context) bool {
+	return app.memberOf(ctx, context.GetCanary(ctx))
+}
+
+// memberOf reports whether the user is a member of c or an admin.
+func (app *App) memberOf(ctx context.Context, c *common.Canary) bool {
+	if app.authStrategy == nil || c == nil {
+		return false
+	}
+
+	return c.IsMember(context.GetStringValue(ctx, auth.UserNameKey)) || app.admin(ctx)
+}
+
+// readable asks the strategy whether the user may read the canary.
+func (app *App) readable(ctx context.Context, c *common.Canary) bool {
+	if app.authStrategy == nil {
+		return true
+	}
+
+	_, err := app.authStrategy.Authorized(ctx, auth.Access{
+		Resource: auth.Resource{
+			Type: "canary",
+			Name: c.ID,
+		},
+		Action: "read",
+	})
+
+	return err == nil
+}
+
+// admin asks the strategy whether the user may act on canaries they don't
+// own.
+func (app *App) admin(ctx context.Context) bool {
+	_, err := app.authStrategy.Authorized(ctx, auth.Access{
+		Resource: auth.AdminResource,
+		Action:   auth.ActionAll,
+	})
+
+	return err == nil
+}
+
+// appendCatalogAccessRecords covers the routes that aren't about a single
+// canary. The base route counts as listing, it tells clients what the server
+// has to offer.
 func appendCatalogAccessRecords(accessRecords []auth.Access, r *http.Request) []auth.Access {
+	switch routeName(r) {
+	case v1.RouteNameBase:
+		return append(accessRecords, auth.Access{
+			Resource: auth.CatalogResource,
+			Action:   auth.ActionList,
+		})
+
+	case v1.RouteNameCanaries:
+	default:
+		return accessRecords
+	}
+
+	switch r.Method {
+	case "GET", "HEAD":
+		accessRecords = append(accessRecords, auth.Access{
+			Resource: auth.CatalogResource,
+			Action:   auth.ActionList,
+		})
+
+	case "PUT":
+		accessRecords = append(accessRecords, auth.Access{
+			Resource: auth.CatalogResource,
+			Action:   auth.ActionCreate,
+		})
+	}
+
 	return accessRecords
 }
 
@@ -339,16 +662,34 @@ func (app *App) register(routeName string, dispatch dispatchFunc) {
 	app.router.GetRoute(routeName).Handler(app.dispatcher(dispatch))
 }
 
+func routeName(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		return route.GetName()
+	}
+
+	return ""
+}
+
 func (app *App) canaryIdRequired(r *http.Request) bool {
 	route := mux.CurrentRoute(r)
 	routeName := route.GetName()
-	return route == nil || (routeName != v1.RouteNameBase && routeName != v1.RouteNameCanaries)
+	return route == nil || (routeName != v1.RouteNameBase && routeName != v1.RouteNameCanaries && routeName != v1.RouteNameToken)
+}
+
+func (app *App) deadCanaryAllowed(r *http.Request) bool {
+	route := mux.CurrentRoute(r)
+	return route != nil && (route.GetName() == v1.RouteNameHistory || route.GetName() == v1.RouteNameChain)
 }
 
 func (app *App) hookIdRequired(r *http.Request) bool {
 	route := mux.CurrentRoute(r)
 	routeName := route.GetName()
-	return route == nil || routeName == v1.RouteNameWebhook || routeName == v1.RouteNameWebhookTest
+	switch routeName {
+	case v1.RouteNameWebhook, v1.RouteNameWebhookTest, v1.RouteNameDeliveries, v1.RouteNameRedeliver:
+		return true
+	}
+
+	return route == nil
 }
 
 func (app *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
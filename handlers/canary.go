@@ -1,16 +1,23 @@
 package handlers
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/gorilla/handlers"
 
 	"github.com/danielkrainas/canaria-api/actions"
 	"github.com/danielkrainas/canaria-api/api/errcode"
 	"github.com/danielkrainas/canaria-api/api/v1"
+	"github.com/danielkrainas/canaria-api/auth"
 	"github.com/danielkrainas/canaria-api/common"
 	"github.com/danielkrainas/canaria-api/context"
+	"github.com/danielkrainas/canaria-api/storage"
 	"github.com/danielkrainas/canaria-api/uuid"
 )
 
@@ -31,12 +38,23 @@ func canaryDispatcher(ctx context.Context, r *http.Request) http.Handler {
 	}
 }
 
+func pinDispatcher(ctx context.Context, r *http.Request) http.Handler {
+	ch := &canaryHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		"DELETE": http.HandlerFunc(ch.UnpinCanary),
+	}
+}
+
 func canariesDispatcher(ctx context.Context, r *http.Request) http.Handler {
 	ch := &canaryHandler{
 		Context: ctx,
 	}
 
 	return handlers.MethodHandler{
+		"GET": http.HandlerFunc(ch.ListCanaries),
 		"PUT": http.HandlerFunc(ch.StoreCanary),
 	}
 }
@@ -49,52 +67,141 @@ type canaryRequest struct {
 	Labels       []string `json:"labels"`
 	PublicKey    string   `json:"pubkey"`
 	PublicKeyUrl string   `json:"pubkey_url"`
+	Warnings     []int64  `json:"warnings"`
 }
 
 func (r *canaryRequest) Canary() *common.Canary {
 	d := &common.Canary{
-		ID:         uuid.Generate(),
-		TimeToLive: r.TimeToLive,
-		Title:      r.Title,
-		Message:    r.Message,
-		UpdatedAt:  0,
-		Labels:     r.Labels,
-		Signature:  r.Signature,
+		ID:           uuid.Generate(),
+		TimeToLive:   r.TimeToLive,
+		Title:        r.Title,
+		Message:      r.Message,
+		UpdatedAt:    0,
+		Labels:       r.Labels,
+		Signature:    r.Signature,
+		PublicKey:    r.PublicKey,
+		PublicKeyUrl: r.PublicKeyUrl,
+		Warnings:     r.Warnings,
+		Revision:     1,
 	}
 
 	d.Refresh("")
 	return d
 }
 
-func (ch *canaryHandler) KillCanary(w http.ResponseWriter, r *http.Request) {
-	context.GetLogger(ch).Debug("KillCanary")
-	c := context.GetCanary(ch)
+func parseCanaryQuery(r *http.Request) (*storage.CanaryQuery, error) {
+	values := r.URL.Query()
+	q := &storage.CanaryQuery{
+		SortBy: storage.SortUpdatedAt,
+		Cursor: values.Get("cursor"),
+	}
 
-	context.GetLogger(ch).Warn("killing canary")
-	c.Kill()
-	if err := getApp(ch).storage.Canaries().Store(ch, c); err != nil {
-		ch.Context = context.AppendError(ch.Context, errcode.ErrorCodeUnknown.WithDetail(err))
-		return
+	if states := values.Get("state"); states != "" {
+		for _, state := range strings.Split(states, ",") {
+			switch state {
+			case common.StateAlive, common.StateZombie, common.StateDead:
+				q.States = append(q.States, state)
+			default:
+				return nil, fmt.Errorf("unknown state: %q", state)
+			}
+		}
 	}
 
-	hooks, err := getApp(ch).storage.Hooks().GetForCanary(ch, c.ID)
+	selector, err := common.ParseSelector(values.Get("labels"))
 	if err != nil {
-		ch.Context = context.AppendError(ch.Context, errcode.ErrorCodeUnknown.WithDetail(err))
-		return
+		return nil, err
 	}
 
-	for _, wh := range hooks {
-		context.GetLogger(ch).Infof("notifying %s of event %s", wh.ID, common.EventDead)
-		go actions.Notify(ch, wh, c, common.EventDead)
+	q.Selector = selector
+	switch sortBy := values.Get("sort"); sortBy {
+	case "":
+	case storage.SortUpdatedAt, storage.SortExpiresAt:
+		q.SortBy = sortBy
+	default:
+		return nil, fmt.Errorf("unsupported sort: %q", sortBy)
 	}
 
-	if _, err := getApp(ch).storage.Hooks().DeleteForCanary(ch, c.ID); err != nil {
+	switch order := values.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		q.Descending = true
+	default:
+		return nil, fmt.Errorf("unsupported order: %q", order)
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid limit: %q", limit)
+		}
+
+		q.Limit = n
+	}
+
+	return q, nil
+}
+
+func (ch *canaryHandler) ListCanaries(w http.ResponseWriter, r *http.Request) {
+	context.GetLogger(ch).Debug("ListCanaries")
+
+	q, err := parseCanaryQuery(r)
+	if err != nil {
+		ch.Context = context.AppendError(ch.Context, v1.ErrorCodeQueryInvalid.WithDetail(err))
+		return
+	}
+
+	page, err := getApp(ch).storage.Canaries().List(ch, q)
+	if err == storage.ErrInvalidCursor {
+		ch.Context = context.AppendError(ch.Context, v1.ErrorCodeQueryInvalid.WithDetail(err))
+		return
+	} else if err != nil {
 		ch.Context = context.AppendError(ch.Context, errcode.ErrorCodeUnknown.WithDetail(err))
 		return
 	}
 
-	for _, wh := range hooks {
-		context.GetLogger(ch).Infof("hook removed: %s", wh.ID)
+	// the catalog scope only lets clients find the canaries they could read
+	// one by one, and who owns them is for members only.
+	list := &common.CanaryList{
+		Canaries: make([]*common.Canary, 0, len(page.Canaries)),
+	}
+
+	for _, c := range page.Canaries {
+		if !getApp(ch).readable(ch, c) {
+			continue
+		} else if !getApp(ch).memberOf(ch, c) {
+			c = c.Redacted()
+		}
+
+		list.Canaries = append(list.Canaries, c)
+	}
+
+	if page.NextCursor != "" {
+		values := r.URL.Query()
+		values.Set("cursor", page.NextCursor)
+		nextURL, err := getURLBuilder(ch).BuildCanariesURL(values)
+		if err != nil {
+			context.GetLogger(ch).Errorf("error building canaries url: %v", err)
+			ch.Context = context.AppendError(ch.Context, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+
+		list.Next = page.NextCursor
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", nextURL))
+	}
+
+	if err := common.ServeCanaryListJSON(w, list, http.StatusOK); err != nil {
+		context.GetLogger(ch).Errorf("error sending canary list json: %v", err)
+	}
+}
+
+func (ch *canaryHandler) KillCanary(w http.ResponseWriter, r *http.Request) {
+	context.GetLogger(ch).Debug("KillCanary")
+	c := context.GetCanary(ch)
+
+	context.GetLogger(ch).Warn("killing canary")
+	if err := actions.KillCanary(ch, getApp(ch).storage, getApp(ch).deliveries, c); err != nil {
+		ch.Context = context.AppendError(ch.Context, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
 	}
 
 	// TODO: set location header for canary
@@ -111,9 +218,39 @@ func (ch *canaryHandler) UpdateCanary(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	c.Refresh(updateToken)
+	patch, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		ch.Context = context.AppendError(ch.Context, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	prev := *c
+	changed := false
+	if len(bytes.TrimSpace(patch)) > 0 {
+		if changed, err = c.Patch(patch); err != nil {
+			ch.Context = context.AppendError(ch.Context, v1.ErrorCodeCanaryInvalid.WithDetail(err))
+			return
+		} else if err = c.Validate(); err != nil {
+			ch.Context = context.AppendError(ch.Context, v1.ErrorCodeCanaryInvalid.WithDetail(err))
+			return
+		}
+	}
+
+	// a plain refresh keeps the stored verification, it must not depend on
+	// the key url being reachable.
+	if c.SignatureChanged(&prev) {
+		if err := actions.VerifyCanary(ch, getApp(ch).keys, c); err != nil {
+			ch.Context = context.AppendError(ch.Context, v1.ErrorCodeSignatureInvalid.WithDetail(err))
+			return
+		}
+	}
+
 	context.GetLogger(ch).Info("update canary")
-	if err := getApp(ch).storage.Canaries().Store(ch, c); err != nil {
+	err = actions.UpdateCanary(ch, getApp(ch).storage, getApp(ch).deliveries, c, updateToken, changed)
+	if err == storage.ErrConflict {
+		ch.Context = context.AppendError(ch.Context, v1.ErrorCodeUpdateTokenInvalid.WithDetail(err))
+		return
+	} else if err != nil {
 		ch.Context = context.AppendError(ch.Context, errcode.ErrorCodeUnknown.WithDetail(err))
 		return
 	}
@@ -123,9 +260,38 @@ func (ch *canaryHandler) UpdateCanary(w http.ResponseWriter, r *http.Request) {
 	common.ServeCanaryJSON(w, c, http.StatusOK)
 }
 
+func (ch *canaryHandler) UnpinCanary(w http.ResponseWriter, r *http.Request) {
+	context.GetLogger(ch).Debug("UnpinCanary")
+	c := context.GetCanary(ch)
+
+	updateToken := r.Header.Get(common.HeaderCanaryUpdateToken)
+	if updateToken == "" || updateToken != c.UpdateToken {
+		ch.Context = context.AppendError(ch.Context, v1.ErrorCodeUpdateTokenInvalid.WithDetail(""))
+		return
+	}
+
+	fingerprint := c.PublicKeyFingerprint
+	err := actions.UnpinCanary(ch, getApp(ch).storage, c, updateToken)
+	if err == storage.ErrConflict {
+		ch.Context = context.AppendError(ch.Context, v1.ErrorCodeUpdateTokenInvalid.WithDetail(err))
+		return
+	} else if err != nil {
+		ch.Context = context.AppendError(ch.Context, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	context.GetLoggerWithField(ch, "fingerprint", fingerprint).Warn("canary public key unpinned")
+	w.Header().Set(common.HeaderCanaryID, c.ID)
+	common.ServeCanaryJSON(w, c, http.StatusOK)
+}
+
 func (ch *canaryHandler) GetCanary(w http.ResponseWriter, r *http.Request) {
 	context.GetLogger(ch).Debug("GetCanary")
 	c := context.GetCanary(ch)
+	if !getApp(ch).member(ch) {
+		c = c.Redacted()
+	}
+
 	w.Header().Set(common.HeaderCanaryID, c.ID)
 	if r.Method == http.MethodHead {
 		w.WriteHeader(http.StatusNoContent)
@@ -145,10 +311,14 @@ func (ch *canaryHandler) StoreCanary(w http.ResponseWriter, r *http.Request) {
 	}
 
 	c := cr.Canary()
+	c.Owner = context.GetStringValue(ch, auth.UserNameKey)
 	if err := c.Validate(); err != nil {
 		ch.Context = context.AppendError(ch.Context, v1.ErrorCodeCanaryInvalid.WithDetail(err))
 		return
-	} else if err = getApp(ch).storage.Canaries().Store(ch, c); err != nil {
+	} else if err = actions.VerifyCanary(ch, getApp(ch).keys, c); err != nil {
+		ch.Context = context.AppendError(ch.Context, v1.ErrorCodeSignatureInvalid.WithDetail(err))
+		return
+	} else if err = actions.CreateCanary(ch, getApp(ch).storage, getApp(ch).deliveries, c); err != nil {
 		ch.Context = context.AppendError(ch.Context, errcode.ErrorCodeUnknown.WithDetail(err))
 		return
 	}
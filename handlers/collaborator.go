@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/handlers"
+
+	"github.com/danielkrainas/canaria-api/actions"
+	"github.com/danielkrainas/canaria-api/api/errcode"
+	"github.com/danielkrainas/canaria-api/api/v1"
+	"github.com/danielkrainas/canaria-api/common"
+	"github.com/danielkrainas/canaria-api/context"
+)
+
+type collaboratorHandler struct {
+	context.Context
+}
+
+func collaboratorsDispatcher(ctx context.Context, r *http.Request) http.Handler {
+	ch := &collaboratorHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		"GET": http.HandlerFunc(ch.GetCollaborators),
+	}
+}
+
+func collaboratorDispatcher(ctx context.Context, r *http.Request) http.Handler {
+	ch := &collaboratorHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		"PUT":    http.HandlerFunc(ch.GrantCollaborator),
+		"DELETE": http.HandlerFunc(ch.RevokeCollaborator),
+	}
+}
+
+func ownerDispatcher(ctx context.Context, r *http.Request) http.Handler {
+	ch := &collaboratorHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		"PUT": http.HandlerFunc(ch.TransferCanary),
+	}
+}
+
+type ownerRequest struct {
+	Owner string `json:"owner"`
+}
+
+func (ch *collaboratorHandler) GetCollaborators(w http.ResponseWriter, r *http.Request) {
+	context.GetLogger(ch).Debug("GetCollaborators")
+	c := context.GetCanary(ch)
+	if err := common.ServeCanaryMembersJSON(w, c.Members(), http.StatusOK); err != nil {
+		context.GetLogger(ch).Errorf("error sending canary collaborators json: %v", err)
+	}
+}
+
+func (ch *collaboratorHandler) GrantCollaborator(w http.ResponseWriter, r *http.Request) {
+	context.GetLogger(ch).Debug("GrantCollaborator")
+	user := context.GetCollaboratorName(ch)
+	changed := ch.changeMembers(w, common.RevisionGranted, user, func(c *common.Canary) error {
+		return c.Grant(user)
+	})
+
+	if changed {
+		context.GetLoggerWithField(ch, "collaborator", user).Info("collaborator granted")
+	}
+}
+
+func (ch *collaboratorHandler) RevokeCollaborator(w http.ResponseWriter, r *http.Request) {
+	context.GetLogger(ch).Debug("RevokeCollaborator")
+	user := context.GetCollaboratorName(ch)
+	changed := ch.changeMembers(w, common.RevisionRevoked, user, func(c *common.Canary) error {
+		return c.Revoke(user)
+	})
+
+	if changed {
+		context.GetLoggerWithField(ch, "collaborator", user).Info("collaborator revoked")
+	}
+}
+
+func (ch *collaboratorHandler) TransferCanary(w http.ResponseWriter, r *http.Request) {
+	context.GetLogger(ch).Debug("TransferCanary")
+	or := &ownerRequest{}
+	if err := json.NewDecoder(r.Body).Decode(or); err != nil {
+		ch.Context = context.AppendError(ch.Context, v1.ErrorCodeCanaryInvalid.WithMessage(err.Error()))
+		return
+	}
+
+	changed := ch.changeMembers(w, common.RevisionTransferred, or.Owner, func(c *common.Canary) error {
+		return c.Transfer(or.Owner)
+	})
+
+	if changed {
+		context.GetLoggerWithField(ch, "owner", or.Owner).Info("canary transferred")
+	}
+}
+
+func (ch *collaboratorHandler) changeMembers(w http.ResponseWriter, action string, member string, change func(c *common.Canary) error) bool {
+	var invalid error
+	c, err := actions.ChangeMembers(ch, getApp(ch).storage, context.GetCanary(ch), action, member, func(c *common.Canary) error {
+		invalid = change(c)
+		return invalid
+	})
+
+	if err != nil && err == invalid {
+		ch.Context = context.AppendError(ch.Context, v1.ErrorCodeCanaryInvalid.WithMessage(err.Error()))
+		return false
+	} else if err != nil {
+		ch.Context = context.AppendError(ch.Context, errcode.ErrorCodeUnknown.WithDetail(err))
+		return false
+	}
+
+	if err := common.ServeCanaryMembersJSON(w, c.Members(), http.StatusOK); err != nil {
+		context.GetLogger(ch).Errorf("error sending canary collaborators json: %v", err)
+	}
+
+	return true
+}
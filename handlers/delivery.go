@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/handlers"
+
+	"github.com/danielkrainas/canaria-api/api/errcode"
+	"github.com/danielkrainas/canaria-api/api/v1"
+	"github.com/danielkrainas/canaria-api/common"
+	"github.com/danielkrainas/canaria-api/context"
+	"github.com/danielkrainas/canaria-api/storage"
+)
+
+type deliveryHandler struct {
+	context.Context
+}
+
+func deliveriesDispatcher(ctx context.Context, r *http.Request) http.Handler {
+	dh := &deliveryHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		"GET": http.HandlerFunc(dh.GetHookDeliveries),
+	}
+}
+
+func redeliverDispatcher(ctx context.Context, r *http.Request) http.Handler {
+	dh := &deliveryHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		"POST": http.HandlerFunc(dh.Redeliver),
+	}
+}
+
+func parseDeliveryQuery(r *http.Request, hookID string) (*storage.DeliveryQuery, error) {
+	values := r.URL.Query()
+	q := &storage.DeliveryQuery{
+		HookID: hookID,
+		Cursor: values.Get("cursor"),
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid limit: %q", limit)
+		}
+
+		q.Limit = n
+	}
+
+	return q, nil
+}
+
+func (dh *deliveryHandler) GetHookDeliveries(w http.ResponseWriter, r *http.Request) {
+	context.GetLogger(dh).Debug("GetHookDeliveries")
+	c := context.GetCanary(dh)
+	hook := context.GetCanaryHook(dh)
+
+	q, err := parseDeliveryQuery(r, hook.ID)
+	if err != nil {
+		dh.Context = context.AppendError(dh.Context, v1.ErrorCodeQueryInvalid.WithDetail(err))
+		return
+	}
+
+	page, err := getApp(dh).storage.Deliveries().List(dh, q)
+	if err == storage.ErrInvalidCursor {
+		dh.Context = context.AppendError(dh.Context, v1.ErrorCodeQueryInvalid.WithDetail(err))
+		return
+	} else if err != nil {
+		dh.Context = context.AppendError(dh.Context, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	list := &common.DeliveryList{
+		Deliveries: page.Deliveries,
+	}
+
+	if page.NextCursor != "" {
+		values := r.URL.Query()
+		values.Set("cursor", page.NextCursor)
+		nextURL, err := getURLBuilder(dh).BuildHookDeliveriesURL(c.ID, hook.ID, values)
+		if err != nil {
+			context.GetLogger(dh).Errorf("error building hook deliveries url: %v", err)
+			dh.Context = context.AppendError(dh.Context, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+
+		list.Next = page.NextCursor
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", nextURL))
+	}
+
+	if err := common.ServeDeliveryListJSON(w, list, http.StatusOK); err != nil {
+		context.GetLogger(dh).Errorf("error sending delivery list json: %v", err)
+	}
+}
+
+func (dh *deliveryHandler) Redeliver(w http.ResponseWriter, r *http.Request) {
+	context.GetLogger(dh).Debug("Redeliver")
+	hook := context.GetCanaryHook(dh)
+	app := getApp(dh)
+
+	d, err := app.storage.Deliveries().Get(dh, context.GetStringValue(dh, "vars.delivery_id"))
+	if err != nil || d.HookID != hook.ID {
+		dh.Context = context.AppendError(dh.Context, v1.ErrorCodeDeliveryUnknown)
+		return
+	}
+
+	redelivery := d.Redeliver(hook)
+	if err := app.deliveries.Enqueue(dh, redelivery); err != nil {
+		dh.Context = context.AppendError(dh.Context, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	context.GetLoggerWithField(dh, "delivery.id", d.ID).Infof("redelivery queued: %s", redelivery.ID)
+	if err := common.ServeDeliveryJSON(w, redelivery, http.StatusAccepted); err != nil {
+		context.GetLogger(dh).Errorf("error sending delivery json: %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/handlers"
+
+	"github.com/danielkrainas/canaria-api/api/errcode"
+	"github.com/danielkrainas/canaria-api/api/v1"
+	"github.com/danielkrainas/canaria-api/common"
+	"github.com/danielkrainas/canaria-api/context"
+	"github.com/danielkrainas/canaria-api/storage"
+)
+
+type historyHandler struct {
+	context.Context
+}
+
+func historyDispatcher(ctx context.Context, r *http.Request) http.Handler {
+	hh := &historyHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		"GET": http.HandlerFunc(hh.GetCanaryHistory),
+	}
+}
+
+func chainDispatcher(ctx context.Context, r *http.Request) http.Handler {
+	hh := &historyHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		"GET": http.HandlerFunc(hh.GetCanaryChain),
+	}
+}
+
+func parseRevisionQuery(r *http.Request, canaryID string) (*storage.RevisionQuery, error) {
+	values := r.URL.Query()
+	q := &storage.RevisionQuery{
+		CanaryID: canaryID,
+		Cursor:   values.Get("cursor"),
+	}
+
+	switch order := values.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		q.Descending = true
+	default:
+		return nil, fmt.Errorf("unsupported order: %q", order)
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid limit: %q", limit)
+		}
+
+		q.Limit = n
+	}
+
+	return q, nil
+}
+
+func (hh *historyHandler) GetCanaryHistory(w http.ResponseWriter, r *http.Request) {
+	context.GetLogger(hh).Debug("GetCanaryHistory")
+	c := context.GetCanary(hh)
+
+	q, err := parseRevisionQuery(r, c.ID)
+	if err != nil {
+		hh.Context = context.AppendError(hh.Context, v1.ErrorCodeQueryInvalid.WithDetail(err))
+		return
+	}
+
+	page, err := getApp(hh).storage.Revisions().List(hh, q)
+	if err == storage.ErrInvalidCursor {
+		hh.Context = context.AppendError(hh.Context, v1.ErrorCodeQueryInvalid.WithDetail(err))
+		return
+	} else if err != nil {
+		hh.Context = context.AppendError(hh.Context, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	history := &common.CanaryHistory{
+		Revisions: page.Revisions,
+	}
+
+	// who made a revision and from where is for members only.
+	if !getApp(hh).member(hh) {
+		history.Revisions = make([]*common.CanaryRevision, len(page.Revisions))
+		for i, rev := range page.Revisions {
+			history.Revisions[i] = rev.Anonymous()
+		}
+	}
+
+	if page.NextCursor != "" {
+		values := r.URL.Query()
+		values.Set("cursor", page.NextCursor)
+		nextURL, err := getURLBuilder(hh).BuildCanaryHistoryURL(c.ID, values)
+		if err != nil {
+			context.GetLogger(hh).Errorf("error building canary history url: %v", err)
+			hh.Context = context.AppendError(hh.Context, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+
+		history.Next = page.NextCursor
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", nextURL))
+	}
+
+	w.Header().Set(common.HeaderCanaryID, c.ID)
+	if err := common.ServeCanaryHistoryJSON(w, history, http.StatusOK); err != nil {
+		context.GetLogger(hh).Errorf("error sending canary history json: %v", err)
+	}
+}
+
+func (hh *historyHandler) GetCanaryChain(w http.ResponseWriter, r *http.Request) {
+	context.GetLogger(hh).Debug("GetCanaryChain")
+	c := context.GetCanary(hh)
+
+	q, err := parseRevisionQuery(r, c.ID)
+	if err != nil {
+		hh.Context = context.AppendError(hh.Context, v1.ErrorCodeQueryInvalid.WithDetail(err))
+		return
+	}
+
+	revisions := getApp(hh).storage.Revisions()
+	page, err := revisions.List(hh, q)
+	if err == storage.ErrInvalidCursor {
+		hh.Context = context.AppendError(hh.Context, v1.ErrorCodeQueryInvalid.WithDetail(err))
+		return
+	} else if err != nil {
+		hh.Context = context.AppendError(hh.Context, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	// the head is the latest entry of the whole chain, not of this page.
+	latest, err := revisions.List(hh, &storage.RevisionQuery{CanaryID: c.ID, Descending: true, Limit: 1})
+	if err != nil {
+		hh.Context = context.AppendError(hh.Context, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	chain := &common.CanaryChain{
+		CanaryID: c.ID,
+		Entries:  make([]*common.ChainEntry, 0, len(page.Revisions)),
+	}
+
+	for _, rev := range page.Revisions {
+		chain.Entries = append(chain.Entries, rev.ChainEntry())
+	}
+
+	if len(latest.Revisions) > 0 {
+		chain.Head = latest.Revisions[0].Hash
+	}
+
+	if page.NextCursor != "" {
+		values := r.URL.Query()
+		values.Set("cursor", page.NextCursor)
+		nextURL, err := getURLBuilder(hh).BuildCanaryChainURL(c.ID, values)
+		if err != nil {
+			context.GetLogger(hh).Errorf("error building canary chain url: %v", err)
+			hh.Context = context.AppendError(hh.Context, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+
+		chain.Next = page.NextCursor
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", nextURL))
+	}
+
+	w.Header().Set(common.HeaderCanaryID, c.ID)
+	w.Header().Set(common.HeaderCanaryChainHead, chain.Head)
+	if err := common.ServeCanaryChainJSON(w, chain, http.StatusOK); err != nil {
+		context.GetLogger(hh).Errorf("error sending canary chain json: %v", err)
+	}
+}
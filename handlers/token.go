@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/handlers"
+
+	"github.com/danielkrainas/canaria-api/api/errcode"
+	"github.com/danielkrainas/canaria-api/api/v1"
+	"github.com/danielkrainas/canaria-api/auth/token"
+	"github.com/danielkrainas/canaria-api/common"
+	"github.com/danielkrainas/canaria-api/context"
+)
+
+type tokenHandler struct {
+	context.Context
+}
+
+func tokenDispatcher(ctx context.Context, r *http.Request) http.Handler {
+	th := &tokenHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		"GET": http.HandlerFunc(th.GetToken),
+	}
+}
+
+func parseScopes(r *http.Request) ([]*token.ResourceActions, error) {
+	var scopes []*token.ResourceActions
+	for _, param := range r.URL.Query()["scope"] {
+		for _, raw := range strings.Fields(param) {
+			scope, err := token.ParseScope(raw)
+			if err != nil {
+				return nil, fmt.Errorf("invalid scope: %q", raw)
+			}
+
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return scopes, nil
+}
+
+func (th *tokenHandler) GetToken(w http.ResponseWriter, r *http.Request) {
+	context.GetLogger(th).Debug("GetToken")
+	issuer := getApp(th).issuer
+
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", issuer.Service()))
+		th.Context = context.AppendError(th.Context, errcode.ErrorCodeUnauthorized)
+		return
+	}
+
+	if err := issuer.Authenticate(username, password); err != nil {
+		context.GetLogger(th).Warnf("token requested with invalid credentials for %q: %v", username, err)
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", issuer.Service()))
+		th.Context = context.AppendError(th.Context, errcode.ErrorCodeUnauthorized)
+		return
+	}
+
+	values := r.URL.Query()
+	if service := values.Get("service"); service != "" && service != issuer.Service() {
+		th.Context = context.AppendError(th.Context, v1.ErrorCodeQueryInvalid.WithMessage(fmt.Sprintf("unknown service: %q", service)))
+		return
+	}
+
+	if account := values.Get("account"); account != "" && account != username {
+		th.Context = context.AppendError(th.Context, v1.ErrorCodeQueryInvalid.WithMessage("account does not match the authenticated user"))
+		return
+	}
+
+	scopes, err := parseScopes(r)
+	if err != nil {
+		th.Context = context.AppendError(th.Context, v1.ErrorCodeQueryInvalid.WithMessage(err.Error()))
+		return
+	}
+
+	signed, claims, err := issuer.Issue(username, scopes)
+	if err != nil {
+		context.GetLogger(th).Errorf("error issuing token: %v", err)
+		th.Context = context.AppendError(th.Context, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	context.GetLoggerWithField(th, "token.id", claims.Id).Infof("issued token for %q with %d of %d requested scopes", username, len(claims.Access), len(scopes))
+	res := &common.TokenResponse{
+		Token:       signed,
+		AccessToken: signed,
+		ExpiresIn:   claims.ExpiresAt - claims.IssuedAt,
+		IssuedAt:    time.Unix(claims.IssuedAt, 0).UTC().Format(time.RFC3339),
+	}
+
+	if err := common.ServeTokenJSON(w, res, http.StatusOK); err != nil {
+		context.GetLogger(th).Errorf("error sending token json: %v", err)
+	}
+}
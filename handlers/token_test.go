@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/danielkrainas/canaria-api/common"
+	"github.com/danielkrainas/canaria-api/configuration"
+	"github.com/danielkrainas/canaria-api/context"
+
+	_ "github.com/danielkrainas/canaria-api/auth/htpasswd"
+	_ "github.com/danielkrainas/canaria-api/auth/token"
+	_ "github.com/danielkrainas/canaria-api/storage/memory"
+)
+
+// bcrypt hashes of alicepw and bobpw.
+const testHTPasswd = `alice:$2a$04$B.iCzQ1CB76W3hCIm2SK5.awhJ1uFYhdqj6XWfhhYXcaadUpl5SOu
+bob:$2a$04$69WqHORhxnRNstSs4NhFlequODdmLhAw2EaXx85IV7Wk/p7mU3Cry
+`
+
+const testTokenConfig = `version: 0.1
+log:
+  level: 'error'
+storage: 'memory'
+auth:
+  token:
+    realm: '%[1]s'
+    service: 'canaria'
+    issuer: 'canaria-tokens'
+    jwks: '%[2]s/jwks.json'
+tokenserver:
+  issuer: 'canaria-tokens'
+  service: 'canaria'
+  signingkey: '%[2]s/ed.pem'
+  backend:
+    htpasswd:
+      realm: 'canaria'
+      path: '%[2]s/htpasswd'
+  policy:
+    - account: alice
+      access:
+        - {type: registry, name: catalog, actions: [list]}
+`
+
+var challengeParamRegexp = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+func writeTokenFiles(t *testing.T, dir string) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks := fmt.Sprintf(`{"keys":[{"kty":"OKP","crv":"Ed25519","x":%q}]}`, base64.RawURLEncoding.EncodeToString(pub))
+	files := map[string][]byte{
+		"ed.pem":    pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}),
+		"jwks.json": []byte(jwks),
+		"htpasswd":  []byte(testHTPasswd),
+	}
+
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), content, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// newTokenServer starts the api with token auth and the token server. Only
+// one can run per test binary, the app publishes its expvars globally.
+func newTokenServer(t *testing.T) *httptest.Server {
+	dir := t.TempDir()
+	writeTokenFiles(t, dir)
+
+	// the realm has to be known before the app is configured, so the
+	// listener comes first.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	realm := "http://" + l.Addr().String() + "/v1/token"
+	config, err := configuration.Parse(strings.NewReader(fmt.Sprintf(testTokenConfig, realm, dir)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewApp(context.Background(), config)
+	srv := httptest.NewUnstartedServer(app)
+	srv.Listener.Close()
+	srv.Listener = l
+	srv.Start()
+	t.Cleanup(func() {
+		srv.Close()
+		app.Shutdown()
+	})
+
+	return srv
+}
+
+func challengeParams(t *testing.T, res *http.Response) map[string]string {
+	header := res.Header.Get("WWW-Authenticate")
+	if !strings.HasPrefix(header, "Bearer ") {
+		t.Fatalf("expected a bearer challenge, got %q", header)
+	}
+
+	params := make(map[string]string)
+	for _, m := range challengeParamRegexp.FindAllStringSubmatch(header, -1) {
+		params[m[1]] = m[2]
+	}
+
+	return params
+}
+
+func fetchToken(t *testing.T, params map[string]string, username string, password string) string {
+	q := url.Values{}
+	q.Set("service", params["service"])
+	q.Set("scope", params["scope"])
+	req, err := http.NewRequest(http.MethodGet, params["realm"]+"?"+q.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.SetBasicAuth(username, password)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("token request for %q: expected 200, got %d", username, res.StatusCode)
+	}
+
+	tr := &common.TokenResponse{}
+	if err := json.NewDecoder(res.Body).Decode(tr); err != nil {
+		t.Fatal(err)
+	}
+
+	if tr.Token == "" {
+		t.Fatalf("token request for %q: no token in response", username)
+	}
+
+	return tr.Token
+}
+
+func listCanaries(t *testing.T, srv *httptest.Server, token string) *http.Response {
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/v1/canaries", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res.Body.Close()
+	return res
+}
+
+func TestTokenFlow(t *testing.T) {
+	srv := newTokenServer(t)
+
+	res := listCanaries(t, srv, "")
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a token, got %d", res.StatusCode)
+	}
+
+	params := challengeParams(t, res)
+	if params["realm"] != srv.URL+"/v1/token" {
+		t.Fatalf("unexpected realm %q", params["realm"])
+	} else if params["service"] != "canaria" {
+		t.Fatalf("unexpected service %q", params["service"])
+	} else if params["scope"] != "registry:catalog:list" {
+		t.Fatalf("unexpected scope %q", params["scope"])
+	}
+
+	req, err := http.NewRequest(http.MethodGet, params["realm"], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.SetBasicAuth("alice", "wrong")
+	if res, err := http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	} else if res.Body.Close(); res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a wrong password, got %d", res.StatusCode)
+	} else if !strings.HasPrefix(res.Header.Get("WWW-Authenticate"), "Basic ") {
+		t.Fatalf("expected a basic challenge, got %q", res.Header.Get("WWW-Authenticate"))
+	}
+
+	token := fetchToken(t, params, "alice", "alicepw")
+	if res := listCanaries(t, srv, token); res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 with alice's token, got %d", res.StatusCode)
+	}
+
+	// the policy grants bob nothing, his token comes without the scope.
+	token = fetchToken(t, params, "bob", "bobpw")
+	res = listCanaries(t, srv, token)
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with bob's token, got %d", res.StatusCode)
+	} else if params := challengeParams(t, res); params["error"] != "insufficient_scope" {
+		t.Fatalf("expected an insufficient_scope challenge, got %q", res.Header.Get("WWW-Authenticate"))
+	}
+}
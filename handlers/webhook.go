@@ -2,7 +2,9 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/gorilla/handlers"
 
@@ -11,6 +13,7 @@ import (
 	"github.com/danielkrainas/canaria-api/api/v1"
 	"github.com/danielkrainas/canaria-api/common"
 	"github.com/danielkrainas/canaria-api/context"
+	"github.com/danielkrainas/canaria-api/storage"
 )
 
 type webhookHandler struct {
@@ -35,6 +38,7 @@ func webhooksDispatcher(ctx context.Context, r *http.Request) http.Handler {
 	}
 
 	return handlers.MethodHandler{
+		"GET": http.HandlerFunc(wh.GetCanaryHooks),
 		"PUT": http.HandlerFunc(wh.CreateCanaryHook),
 	}
 }
@@ -55,11 +59,23 @@ func (wh *webhookHandler) PingCanaryHook(w http.ResponseWriter, r *http.Request)
 	hook := context.GetCanaryHook(wh)
 
 	context.GetLogger(wh).Infof("pinging hook: %s", hook.Url)
-	if err := actions.Notify(wh, hook, c, common.EventPing); err != nil {
+	app := getApp(wh)
+	if err := actions.Notify(wh, app.notifiers, app.storage.Deliveries(), hook, c, common.EventPing); err != nil {
 		wh.Context = context.AppendError(wh.Context, v1.ErrorCodeWebhookFailed.WithDetail(err))
 		return
 	}
 
+	// a successful ping is how a hook disabled after failing is brought back.
+	if hook.IsDisabled() {
+		hook.Enable()
+		if err := app.storage.Hooks().Store(wh, hook); err != nil {
+			wh.Context = context.AppendError(wh.Context, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+
+		context.GetLogger(wh).Infof("hook re-enabled")
+	}
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -71,16 +87,94 @@ func (wh *webhookHandler) GetCanaryHook(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+func parseHookQuery(r *http.Request, canaryID string) (*storage.HookQuery, error) {
+	values := r.URL.Query()
+	q := &storage.HookQuery{
+		CanaryID: canaryID,
+		Cursor:   values.Get("cursor"),
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid limit: %q", limit)
+		}
+
+		q.Limit = n
+	}
+
+	return q, nil
+}
+
+func (wh *webhookHandler) GetCanaryHooks(w http.ResponseWriter, r *http.Request) {
+	context.GetLogger(wh).Debug("GetCanaryHooks")
+	c := context.GetCanary(wh)
+
+	q, err := parseHookQuery(r, c.ID)
+	if err != nil {
+		wh.Context = context.AppendError(wh.Context, v1.ErrorCodeQueryInvalid.WithDetail(err))
+		return
+	}
+
+	app := getApp(wh)
+	page, err := app.storage.Hooks().List(wh, q)
+	if err == storage.ErrInvalidCursor {
+		wh.Context = context.AppendError(wh.Context, v1.ErrorCodeQueryInvalid.WithDetail(err))
+		return
+	} else if err != nil {
+		wh.Context = context.AppendError(wh.Context, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	list := &common.HookList{
+		Hooks: make([]*common.HookSummary, 0, len(page.Hooks)),
+	}
+
+	for _, hook := range page.Hooks {
+		deliveries, err := app.storage.Deliveries().List(wh, &storage.DeliveryQuery{HookID: hook.ID, Limit: 1})
+		if err != nil {
+			wh.Context = context.AppendError(wh.Context, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+
+		var last *common.Delivery
+		if len(deliveries.Deliveries) > 0 {
+			last = deliveries.Deliveries[0]
+		}
+
+		list.Hooks = append(list.Hooks, hook.Summary(last))
+	}
+
+	if page.NextCursor != "" {
+		values := r.URL.Query()
+		values.Set("cursor", page.NextCursor)
+		nextURL, err := getURLBuilder(wh).BuildCanaryHooksURL(c.ID, values)
+		if err != nil {
+			context.GetLogger(wh).Errorf("error building canary hooks url: %v", err)
+			wh.Context = context.AppendError(wh.Context, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+
+		list.Next = page.NextCursor
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", nextURL))
+	}
+
+	if err := common.ServeHookListJSON(w, list, http.StatusOK); err != nil {
+		context.GetLogger(wh).Errorf("error sending hook list json: %v", err)
+	}
+}
+
 func (wh *webhookHandler) RemoveCanaryHook(w http.ResponseWriter, r *http.Request) {
 	context.GetLogger(wh).Debug("RemoveCanaryHook")
-	hookID := context.GetCanaryHookID(wh)
+	hook := context.GetCanaryHook(wh)
 
-	if err := getApp(wh).storage.Hooks().Delete(wh, hookID); err != nil {
+	if err := getApp(wh).storage.Hooks().Delete(wh, hook.ID); err != nil {
 		wh.Context = context.AppendError(wh.Context, errcode.ErrorCodeUnknown.WithDetail(err))
 		return
 	}
 
 	context.GetLogger(wh).Print("remove hook")
+	wh.dispatchHookEvent(hook, common.EventHookDeleted)
 	w.WriteHeader(http.StatusAccepted)
 }
 
@@ -101,11 +195,16 @@ func (wh *webhookHandler) EditCanaryHook(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	hook.Update(edit, updateToken)
-	if err := getApp(wh).storage.Hooks().Store(wh, hook); err != nil {
+	hook.Update(edit, updateToken, getApp(wh).secretOverlap)
+	if err := getApp(wh).notifiers.Validate(hook); err != nil {
+		wh.Context = context.AppendError(wh.Context, v1.ErrorCodeWebhookSetupInvalid.WithMessage(err.Error()))
+		return
+	} else if err := getApp(wh).storage.Hooks().Store(wh, hook); err != nil {
 		wh.Context = context.AppendError(wh.Context, v1.ErrorCodeWebhookSetupInvalid.WithDetail(err))
+		return
 	}
 
+	wh.dispatchHookEvent(hook, common.EventHookUpdated)
 	w.Header().Set(common.HeaderHookNextUpdateToken, hook.UpdateToken)
 	w.Header().Set(common.HeaderCanaryID, hook.CanaryID)
 	w.Header().Set(common.HeaderHookID, hook.ID)
@@ -126,8 +225,8 @@ func (wh *webhookHandler) CreateCanaryHook(w http.ResponseWriter, r *http.Reques
 
 	hook := edit.Hook()
 	hook.CanaryID = c.ID
-	if err := hook.Validate(); err != nil {
-		wh.Context = context.AppendError(wh.Context, v1.ErrorCodeWebhookSetupInvalid.WithDetail(err))
+	if err := getApp(wh).notifiers.Validate(hook); err != nil {
+		wh.Context = context.AppendError(wh.Context, v1.ErrorCodeWebhookSetupInvalid.WithMessage(err.Error()))
 		return
 	} else if err := getApp(wh).storage.Hooks().Store(wh, hook); err != nil {
 		wh.Context = context.AppendError(wh.Context, v1.ErrorCodeWebhookSetupInvalid.WithDetail(err))
@@ -139,6 +238,7 @@ func (wh *webhookHandler) CreateCanaryHook(w http.ResponseWriter, r *http.Reques
 	})
 
 	logger.Printf("canary hook created for %q", hook.Url)
+	wh.dispatchHookEvent(hook, common.EventHookCreated)
 	hookURL, err := getURLBuilder(wh).BuildCanaryHookURL(c.ID, hook.ID)
 	if err != nil {
 		logger.Errorf("error building hook url: %v", err)
@@ -146,8 +246,16 @@ func (wh *webhookHandler) CreateCanaryHook(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	w.Header().Set(common.HeaderHookNextUpdateToken, hook.UpdateToken)
 	w.Header().Set(common.HeaderCanaryID, c.ID)
 	w.Header().Set(common.HeaderHookID, hook.ID)
 	w.Header().Set("Location", hookURL)
 	w.WriteHeader(http.StatusCreated)
 }
+
+func (wh *webhookHandler) dispatchHookEvent(hook *common.WebHook, event string) {
+	app := getApp(wh)
+	if err := actions.DispatchHookEvent(wh, app.storage.Hooks(), app.deliveries, context.GetCanary(wh), hook, event); err != nil {
+		context.GetLogger(wh).Errorf("error dispatching %s event: %v", event, err)
+	}
+}
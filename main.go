@@ -9,18 +9,26 @@ import (
 	"math/rand"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/danielkrainas/canaria-api/configuration"
 	"github.com/danielkrainas/canaria-api/context"
 	"github.com/danielkrainas/canaria-api/handlers"
 	"github.com/danielkrainas/canaria-api/listener"
+	_ "github.com/danielkrainas/canaria-api/storage/boltdb"
 	_ "github.com/danielkrainas/canaria-api/storage/memory"
+	_ "github.com/danielkrainas/canaria-api/storage/sql"
 
 	_ "github.com/danielkrainas/canaria-api/auth/htpasswd"
 	_ "github.com/danielkrainas/canaria-api/auth/silly"
 	_ "github.com/danielkrainas/canaria-api/auth/token"
 
+	_ "github.com/danielkrainas/canaria-api/notifier/command"
+	_ "github.com/danielkrainas/canaria-api/notifier/file"
+	_ "github.com/danielkrainas/canaria-api/notifier/smtp"
+
 	log "github.com/Sirupsen/logrus"
 	ghandlers "github.com/gorilla/handlers"
 	"rsc.io/letsencrypt"
@@ -41,9 +49,21 @@ func main() {
 		log.Fatalln(err)
 	}
 
-	if err = server.ListenAndServe(); err != nil {
+	stopped := make(chan struct{})
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-sigs
+		context.GetLogger(server.app).Infof("received %v, shutting down", sig)
+		server.Shutdown()
+		close(stopped)
+	}()
+
+	if err = server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatalln(err)
 	}
+
+	<-stopped
 }
 
 func resolveConfiguration(args []string) (*configuration.Config, error) {
@@ -104,6 +124,15 @@ func newCanaryServer(ctx context.Context, config *configuration.Config) (*Canary
 
 func (server *CanaryServer) ListenAndServe() error {
 	config := server.config
+	if config.HTTP.Debug.Addr != "" {
+		go func(addr string) {
+			context.GetLogger(server.app).Infof("debug server listening %v", addr)
+			if err := http.ListenAndServe(addr, nil); err != nil {
+				context.GetLogger(server.app).Fatalf("error listening on debug interface: %v", err)
+			}
+		}(config.HTTP.Debug.Addr)
+	}
+
 	ln, err := listener.NewListener(config.HTTP.Net, config.HTTP.Addr)
 	if err != nil {
 		return err
@@ -179,6 +208,21 @@ func (server *CanaryServer) ListenAndServe() error {
 	return server.server.Serve(ln)
 }
 
+const shutdownTimeout = 10 * time.Second
+
+// Shutdown stops accepting requests, waits briefly for those in progress and
+// then drains the app's deliveries.
+func (server *CanaryServer) Shutdown() {
+	ctx, cancel := context.WithTimeout(server.app, shutdownTimeout)
+	defer cancel()
+
+	if err := server.server.Shutdown(ctx); err != nil {
+		context.GetLogger(server.app).Warnf("error shutting down http server: %v", err)
+	}
+
+	server.app.Shutdown()
+}
+
 func configureLogging(ctx context.Context, config *configuration.Config) (context.Context, error) {
 
 	log.SetLevel(logLevel(config.Log.Level))
@@ -0,0 +1,185 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/danielkrainas/canaria-api/common"
+	"github.com/danielkrainas/canaria-api/context"
+	"github.com/danielkrainas/canaria-api/notifier"
+)
+
+const (
+	defaultTimeout = 30 * time.Second
+
+	maxRecordedOutput = 4096
+)
+
+// commandNotifier runs one of the configured commands with the event as JSON
+// on stdin. Hooks choose a command by name through their "command" option,
+// they can never supply the command line themselves:
+//
+//	notifiers:
+//	  command:
+//	    timeout: 10s
+//	    commands:
+//	      page-oncall: ['/usr/local/bin/page', '--team', 'ops']
+//
+// The event, delivery and canary ids are also passed in the CANARY_EVENT,
+// CANARY_DELIVERY and CANARY_ID environment variables. A non-zero exit status
+// fails the attempt.
+type commandNotifier struct {
+	commands map[string][]string
+	timeout  time.Duration
+}
+
+func init() {
+	notifier.Register("command", newNotifier)
+}
+
+func newNotifier(options map[string]interface{}) (notifier.Notifier, error) {
+	cn := &commandNotifier{
+		commands: make(map[string][]string),
+		timeout:  defaultTimeout,
+	}
+
+	var commands map[string]interface{}
+	switch v := options["commands"].(type) {
+	case map[string]interface{}:
+		commands = v
+
+	case map[interface{}]interface{}:
+		commands = make(map[string]interface{}, len(v))
+		for k, argv := range v {
+			name, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid command name: %v", k)
+			}
+
+			commands[name] = argv
+		}
+
+	default:
+		return nil, errors.New(`"commands" must be set for the command notifier`)
+	}
+
+	for name := range commands {
+		argv, err := notifier.StringsOption(commands, name)
+		if err != nil {
+			return nil, err
+		} else if len(argv) == 0 {
+			return nil, fmt.Errorf("command %q is empty", name)
+		}
+
+		cn.commands[name] = argv
+	}
+
+	if timeout, err := notifier.StringOption(options, "timeout"); err != nil {
+		return nil, err
+	} else if timeout != "" {
+		if cn.timeout, err = time.ParseDuration(timeout); err != nil {
+			return nil, fmt.Errorf("invalid timeout: %v", err)
+		}
+	}
+
+	return cn, nil
+}
+
+func (cn *commandNotifier) command(wh *common.WebHook) (string, []string, error) {
+	name := wh.Options["command"]
+	if name == "" {
+		return "", nil, errors.New(`"command" option is required`)
+	}
+
+	argv, ok := cn.commands[name]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown command: %q", name)
+	}
+
+	return name, argv, nil
+}
+
+func (cn *commandNotifier) Validate(wh *common.WebHook) error {
+	_, _, err := cn.command(wh)
+	return err
+}
+
+func (cn *commandNotifier) Notify(ctx context.Context, d *common.Delivery, a *common.DeliveryAttempt) error {
+	name, argv, err := cn.command(d.Hook)
+	if err != nil {
+		return err
+	}
+
+	a.Url = "command:" + name
+	body, err := json.Marshal(d.Notification())
+	if err != nil {
+		return err
+	}
+
+	env := []string{
+		"CANARY_EVENT=" + d.Event,
+		"CANARY_DELIVERY=" + d.ID,
+		"CANARY_ID=" + d.CanaryID,
+	}
+
+	// only the command's name is recorded, its arguments may carry secrets
+	// from the configuration.
+	a.RequestHeaders = map[string][]string{
+		"Command":     {name},
+		"Environment": env,
+	}
+
+	a.RequestBody = string(body)
+
+	execCtx, cancel := context.WithTimeout(ctx, cn.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(execCtx, argv[0], argv[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = bytes.NewReader(body)
+	output := &limitedBuffer{limit: maxRecordedOutput}
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	start := time.Now()
+	err = cmd.Run()
+	a.Duration = time.Since(start).Nanoseconds() / int64(time.Millisecond)
+	a.ResponseBody = output.String()
+	if cmd.ProcessState != nil {
+		a.ResponseStatus = cmd.ProcessState.ExitCode()
+	}
+
+	if execCtx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("command %q timed out after %s", name, cn.timeout)
+	}
+
+	return err
+}
+
+// limitedBuffer keeps the first limit bytes written to it and discards the
+// rest so that a chatty command can't exhaust memory.
+type limitedBuffer struct {
+	bytes.Buffer
+
+	limit int
+}
+
+var _ io.Writer = &limitedBuffer{}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room > 0 {
+		if len(p) > room {
+			b.Buffer.Write(p[:room])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+
+	return len(p), nil
+}
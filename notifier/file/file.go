@@ -0,0 +1,142 @@
+package file
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/danielkrainas/canaria-api/common"
+	"github.com/danielkrainas/canaria-api/context"
+	"github.com/danielkrainas/canaria-api/notifier"
+)
+
+// fileNotifier appends every event as one line of JSON to a file named in the
+// configuration. Hooks select a sink by name through their "sink" option:
+//
+//	notifiers:
+//	  file:
+//	    sinks:
+//	      audit: '/var/log/canaria/events.jsonl'
+//
+// The file is opened for every event so it can be rotated underneath.
+type fileNotifier struct {
+	sinks map[string]string
+
+	mu sync.Mutex
+}
+
+type record struct {
+	Timestamp  int64                       `json:"timestamp"`
+	DeliveryID string                      `json:"delivery_id"`
+	HookID     string                      `json:"hook_id"`
+	CanaryID   string                      `json:"canary_id"`
+	Event      string                      `json:"event"`
+	Payload    *common.WebHookNotification `json:"payload"`
+}
+
+func init() {
+	notifier.Register("file", newNotifier)
+}
+
+func newNotifier(options map[string]interface{}) (notifier.Notifier, error) {
+	fn := &fileNotifier{
+		sinks: make(map[string]string),
+	}
+
+	switch v := options["sinks"].(type) {
+	case map[string]interface{}:
+		for name, path := range v {
+			if err := fn.addSink(name, path); err != nil {
+				return nil, err
+			}
+		}
+
+	case map[interface{}]interface{}:
+		for name, path := range v {
+			if err := fn.addSink(fmt.Sprint(name), path); err != nil {
+				return nil, err
+			}
+		}
+
+	default:
+		return nil, errors.New(`"sinks" must be set for the file notifier`)
+	}
+
+	return fn, nil
+}
+
+func (fn *fileNotifier) addSink(name string, path interface{}) error {
+	p, ok := path.(string)
+	if !ok || p == "" {
+		return fmt.Errorf("sink %q must be a file path", name)
+	}
+
+	fn.sinks[name] = p
+	return nil
+}
+
+func (fn *fileNotifier) sink(wh *common.WebHook) (string, string, error) {
+	name := wh.Options["sink"]
+	if name == "" {
+		return "", "", errors.New(`"sink" option is required`)
+	}
+
+	path, ok := fn.sinks[name]
+	if !ok {
+		return "", "", fmt.Errorf("unknown sink: %q", name)
+	}
+
+	return name, path, nil
+}
+
+func (fn *fileNotifier) Validate(wh *common.WebHook) error {
+	_, _, err := fn.sink(wh)
+	return err
+}
+
+func (fn *fileNotifier) Notify(ctx context.Context, d *common.Delivery, a *common.DeliveryAttempt) error {
+	name, path, err := fn.sink(d.Hook)
+	if err != nil {
+		return err
+	}
+
+	a.Url = "file:" + name
+	line, err := json.Marshal(&record{
+		Timestamp:  time.Now().Unix(),
+		DeliveryID: d.ID,
+		HookID:     d.HookID,
+		CanaryID:   d.CanaryID,
+		Event:      d.Event,
+		Payload:    d.Notification(),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	a.RequestBody = string(line)
+	line = append(line, '\n')
+
+	fn.mu.Lock()
+	defer fn.mu.Unlock()
+
+	start := time.Now()
+	defer func() {
+		a.Duration = time.Since(start).Nanoseconds() / int64(time.Millisecond)
+	}()
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,128 @@
+package notifier
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/danielkrainas/canaria-api/common"
+	"github.com/danielkrainas/canaria-api/context"
+)
+
+// Notifier delivers hook events over one kind of channel. Anything a hook
+// owner must not control, such as servers, credentials, commands or paths, is
+// part of the notifier's options from the configuration; hooks only pick from
+// what was configured through their options.
+type Notifier interface {
+	// Validate checks the hook's target before the hook is stored.
+	Validate(wh *common.WebHook) error
+
+	// Notify makes one attempt at delivering d to d.Hook and fills in a with
+	// what was sent and what came back.
+	Notify(ctx context.Context, d *common.Delivery, a *common.DeliveryAttempt) error
+}
+
+type NotifierFactory func(options map[string]interface{}) (Notifier, error)
+
+var notifiers map[string]NotifierFactory
+
+func init() {
+	notifiers = make(map[string]NotifierFactory)
+}
+
+func Register(name string, factory NotifierFactory) error {
+	if _, exists := notifiers[name]; exists {
+		return fmt.Errorf("notifier already registered: %s", name)
+	}
+
+	notifiers[name] = factory
+	return nil
+}
+
+func GetNotifier(name string, options map[string]interface{}) (Notifier, error) {
+	if factory, exists := notifiers[name]; exists {
+		return factory(options)
+	}
+
+	return nil, fmt.Errorf("no notifier registered with name: %s", name)
+}
+
+// Set holds the notifiers available to hooks, keyed by hook type.
+type Set map[string]Notifier
+
+func (s Set) For(wh *common.WebHook) (Notifier, error) {
+	if n, ok := s[wh.NotifierType()]; ok {
+		return n, nil
+	}
+
+	return nil, fmt.Errorf("unsupported hook type: %q", wh.NotifierType())
+}
+
+func (s Set) Validate(wh *common.WebHook) error {
+	if err := wh.Validate(); err != nil {
+		return err
+	}
+
+	n, err := s.For(wh)
+	if err != nil {
+		return err
+	}
+
+	return n.Validate(wh)
+}
+
+// StringOption reads an optional string from notifier options.
+func StringOption(options map[string]interface{}, name string) (string, error) {
+	v, ok := options[name]
+	if !ok || v == nil {
+		return "", nil
+	}
+
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%q must be a string", name)
+	}
+
+	return s, nil
+}
+
+// StringsOption reads an optional list of strings from notifier options. A
+// single string is split on commas.
+func StringsOption(options map[string]interface{}, name string) ([]string, error) {
+	switch v := options[name].(type) {
+	case nil:
+		return nil, nil
+
+	case string:
+		return SplitList(v), nil
+
+	case []string:
+		return v, nil
+
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%q must be a list of strings", name)
+			}
+
+			values = append(values, s)
+		}
+
+		return values, nil
+	}
+
+	return nil, fmt.Errorf("%q must be a list of strings", name)
+}
+
+// SplitList splits a comma separated hook option, dropping empty items.
+func SplitList(s string) []string {
+	values := make([]string, 0)
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
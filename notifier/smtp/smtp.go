@@ -0,0 +1,267 @@
+package smtp
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/danielkrainas/canaria-api/common"
+	"github.com/danielkrainas/canaria-api/context"
+	"github.com/danielkrainas/canaria-api/notifier"
+	"github.com/danielkrainas/canaria-api/uuid"
+)
+
+const (
+	defaultTimeout = 30 * time.Second
+	maxRecipients  = 10
+)
+
+// smtpNotifier emails events as JSON to the addresses in the hook's "to"
+// option. The server and credentials come from the configuration:
+//
+//	notifiers:
+//	  smtp:
+//	    addr: 'mail.example.com:587'
+//	    from: 'canary@example.com'
+//	    username: 'canary'
+//	    password: 'secret'
+//	    domains: ['example.com']
+//
+// When domains is set, hooks may only send to addresses in those domains.
+// STARTTLS is used whenever the server offers it, insecureskipverify turns off
+// certificate checks for servers with self-signed certificates.
+type smtpNotifier struct {
+	addr     string
+	host     string
+	from     string
+	sender   string
+	auth     smtp.Auth
+	domains  []string
+	timeout  time.Duration
+	insecure bool
+}
+
+func init() {
+	notifier.Register("smtp", newNotifier)
+}
+
+func newNotifier(options map[string]interface{}) (notifier.Notifier, error) {
+	sn := &smtpNotifier{
+		timeout: defaultTimeout,
+	}
+
+	var err error
+	if sn.addr, err = notifier.StringOption(options, "addr"); err != nil {
+		return nil, err
+	} else if sn.addr == "" {
+		return nil, errors.New(`"addr" must be set for the smtp notifier`)
+	}
+
+	if sn.host, _, err = net.SplitHostPort(sn.addr); err != nil {
+		return nil, fmt.Errorf("invalid addr: %v", err)
+	}
+
+	if sn.from, err = notifier.StringOption(options, "from"); err != nil {
+		return nil, err
+	}
+
+	sender, err := mail.ParseAddress(sn.from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from address: %v", err)
+	}
+
+	sn.sender = sender.Address
+
+	username, err := notifier.StringOption(options, "username")
+	if err != nil {
+		return nil, err
+	}
+
+	password, err := notifier.StringOption(options, "password")
+	if err != nil {
+		return nil, err
+	}
+
+	if username != "" {
+		sn.auth = smtp.PlainAuth("", username, password, sn.host)
+	}
+
+	if sn.domains, err = notifier.StringsOption(options, "domains"); err != nil {
+		return nil, err
+	}
+
+	if timeout, err := notifier.StringOption(options, "timeout"); err != nil {
+		return nil, err
+	} else if timeout != "" {
+		if sn.timeout, err = time.ParseDuration(timeout); err != nil {
+			return nil, fmt.Errorf("invalid timeout: %v", err)
+		}
+	}
+
+	if v, ok := options["insecureskipverify"].(bool); ok {
+		sn.insecure = v
+	}
+
+	return sn, nil
+}
+
+func (sn *smtpNotifier) recipients(wh *common.WebHook) ([]string, error) {
+	to := notifier.SplitList(wh.Options["to"])
+	if len(to) == 0 {
+		return nil, errors.New(`"to" option is required`)
+	} else if len(to) > maxRecipients {
+		return nil, fmt.Errorf("at most %d recipients are allowed", maxRecipients)
+	}
+
+	addresses := make([]string, 0, len(to))
+	for _, raw := range to {
+		address, err := mail.ParseAddress(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient %q: %v", raw, err)
+		}
+
+		if !sn.allowed(address.Address) {
+			return nil, fmt.Errorf("recipient %q is not in an allowed domain", address.Address)
+		}
+
+		addresses = append(addresses, address.Address)
+	}
+
+	return addresses, nil
+}
+
+func (sn *smtpNotifier) allowed(address string) bool {
+	if len(sn.domains) == 0 {
+		return true
+	}
+
+	domain := strings.ToLower(address[strings.LastIndex(address, "@")+1:])
+	for _, d := range sn.domains {
+		if strings.ToLower(d) == domain {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (sn *smtpNotifier) Validate(wh *common.WebHook) error {
+	_, err := sn.recipients(wh)
+	return err
+}
+
+func (sn *smtpNotifier) Notify(ctx context.Context, d *common.Delivery, a *common.DeliveryAttempt) error {
+	to, err := sn.recipients(d.Hook)
+	if err != nil {
+		return err
+	}
+
+	a.Url = "mailto:" + strings.Join(to, ",")
+	body, err := json.MarshalIndent(d.Notification(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	subject := "[canary] " + d.Event
+	if d.Canary != nil {
+		subject += " " + d.Canary.ID
+	}
+
+	headers := map[string][]string{
+		"From":              {sn.from},
+		"To":                {strings.Join(to, ", ")},
+		"Subject":           {subject},
+		"Date":              {time.Now().Format(time.RFC1123Z)},
+		"Message-ID":        {fmt.Sprintf("<%s@%s>", uuid.Generate(), sn.host)},
+		"MIME-Version":      {"1.0"},
+		"Content-Type":      {"application/json; charset=utf-8"},
+		"X-Canary-Event":    {d.Event},
+		"X-Canary-Delivery": {d.ID},
+	}
+
+	a.RequestHeaders = headers
+	a.RequestBody = string(body)
+
+	start := time.Now()
+	err = sn.send(to, headers, body)
+	a.Duration = time.Since(start).Nanoseconds() / int64(time.Millisecond)
+	return err
+}
+
+func (sn *smtpNotifier) send(to []string, headers map[string][]string, body []byte) error {
+	conn, err := net.DialTimeout("tcp", sn.addr, sn.timeout)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(sn.timeout)); err != nil {
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, sn.host)
+	if err != nil {
+		return err
+	}
+
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: sn.host, InsecureSkipVerify: sn.insecure}); err != nil {
+			return err
+		}
+	}
+
+	if sn.auth != nil {
+		if err := c.Auth(sn.auth); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(sn.sender); err != nil {
+		return err
+	}
+
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var msg bytes.Buffer
+	for _, name := range names {
+		for _, v := range headers[name] {
+			fmt.Fprintf(&msg, "%s: %s\r\n", name, v)
+		}
+	}
+
+	msg.WriteString("\r\n")
+	msg.Write(bytes.Replace(body, []byte("\n"), []byte("\r\n"), -1))
+	msg.WriteString("\r\n")
+	if _, err := w.Write(msg.Bytes()); err != nil {
+		return err
+	} else if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
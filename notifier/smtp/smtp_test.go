@@ -0,0 +1,170 @@
+package smtp
+
+import (
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/danielkrainas/canaria-api/common"
+	"github.com/danielkrainas/canaria-api/context"
+)
+
+// message is what the stand-in server received in a single session.
+type message struct {
+	from string
+	to   []string
+	data string
+}
+
+// serveSMTP accepts a single session on l and speaks just enough SMTP for the
+// notifier. Recipients in rejected get a 550.
+func serveSMTP(l net.Listener, rejected string) <-chan *message {
+	received := make(chan *message, 1)
+	go func() {
+		defer close(received)
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+		tc := textproto.NewConn(conn)
+		msg := &message{}
+		tc.PrintfLine("220 localhost ESMTP stand-in")
+		for {
+			line, err := tc.ReadLine()
+			if err != nil {
+				return
+			}
+
+			cmd := strings.ToUpper(line)
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				tc.PrintfLine("250 localhost")
+
+			case strings.HasPrefix(cmd, "MAIL FROM:"):
+				msg.from = strings.Trim(line[len("MAIL FROM:"):], "<> ")
+				tc.PrintfLine("250 OK")
+
+			case strings.HasPrefix(cmd, "RCPT TO:"):
+				rcpt := strings.Trim(line[len("RCPT TO:"):], "<> ")
+				if rcpt == rejected {
+					tc.PrintfLine("550 no such user")
+					continue
+				}
+
+				msg.to = append(msg.to, rcpt)
+				tc.PrintfLine("250 OK")
+
+			case cmd == "DATA":
+				tc.PrintfLine("354 go ahead")
+				data, err := tc.ReadDotBytes()
+				if err != nil {
+					return
+				}
+
+				msg.data = string(data)
+				tc.PrintfLine("250 OK")
+
+			case cmd == "QUIT":
+				tc.PrintfLine("221 bye")
+				received <- msg
+				return
+
+			default:
+				tc.PrintfLine("502 not implemented")
+			}
+		}
+	}()
+
+	return received
+}
+
+func newTestNotifier(t *testing.T, options map[string]interface{}) (*smtpNotifier, net.Listener) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		l.Close()
+	})
+
+	options["addr"] = l.Addr().String()
+	options["from"] = "Canary <canary@example.com>"
+	n, err := newNotifier(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return n.(*smtpNotifier), l
+}
+
+func newTestDelivery(to string) *common.Delivery {
+	wh := &common.WebHook{
+		ID:       "hook",
+		CanaryID: "canary",
+		Type:     "smtp",
+		Options:  map[string]string{"to": to},
+	}
+
+	c := &common.Canary{ID: "canary", TimeToLive: -1}
+	return common.NewDelivery(wh, c, common.EventDead)
+}
+
+func TestNotify(t *testing.T) {
+	sn, l := newTestNotifier(t, map[string]interface{}{})
+	received := serveSMTP(l, "")
+
+	d := newTestDelivery("ops@example.com, Oncall <oncall@example.org>")
+	a := &common.DeliveryAttempt{}
+	if err := sn.Notify(context.Background(), d, a); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := <-received
+	if msg == nil {
+		t.Fatal("the server received no message")
+	} else if msg.from != "canary@example.com" {
+		t.Fatalf("unexpected sender %q", msg.from)
+	} else if strings.Join(msg.to, ",") != "ops@example.com,oncall@example.org" {
+		t.Fatalf("unexpected recipients %v", msg.to)
+	}
+
+	for _, want := range []string{"Subject: [canary] dead canary", "X-Canary-Delivery: " + d.ID, `"action": "dead"`} {
+		if !strings.Contains(msg.data, want) {
+			t.Fatalf("message is missing %q:\n%s", want, msg.data)
+		}
+	}
+
+	if a.Url != "mailto:ops@example.com,oncall@example.org" {
+		t.Fatalf("unexpected attempt url %q", a.Url)
+	} else if a.RequestBody == "" || a.RequestHeaders["Subject"][0] != "[canary] dead canary" {
+		t.Fatalf("attempt was not recorded: %+v", a)
+	}
+}
+
+func TestNotifyRejectedRecipient(t *testing.T) {
+	sn, l := newTestNotifier(t, map[string]interface{}{})
+	serveSMTP(l, "nobody@example.com")
+
+	err := sn.Notify(context.Background(), newTestDelivery("nobody@example.com"), &common.DeliveryAttempt{})
+	if err == nil || !strings.Contains(err.Error(), "550") {
+		t.Fatalf("expected the server's 550, got %v", err)
+	}
+}
+
+func TestValidateDomains(t *testing.T) {
+	sn, _ := newTestNotifier(t, map[string]interface{}{
+		"domains": []interface{}{"example.com"},
+	})
+
+	if err := sn.Validate(newTestDelivery("ops@EXAMPLE.com").Hook); err != nil {
+		t.Fatalf("recipient in an allowed domain refused: %v", err)
+	} else if err := sn.Validate(newTestDelivery("ops@example.org").Hook); err == nil {
+		t.Fatal("recipient outside the allowed domains accepted")
+	} else if err := sn.Validate(newTestDelivery("").Hook); err == nil {
+		t.Fatal("hook without recipients accepted")
+	}
+}
@@ -0,0 +1,974 @@
+package boltdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+
+	"github.com/danielkrainas/canaria-api/common"
+	"github.com/danielkrainas/canaria-api/context"
+	"github.com/danielkrainas/canaria-api/storage"
+	"github.com/danielkrainas/canaria-api/storage/factory"
+)
+
+const driverName = "bolt"
+
+var (
+	bucketCanaries         = []byte("canaries")
+	bucketCanariesDeleted  = []byte("canaries.deleted")
+	bucketCanaryExpiries   = []byte("canaries.expiries")
+	bucketCanaryWarnings   = []byte("canaries.warnings")
+	bucketCanariesByUpdate = []byte("canaries.updated_at")
+	bucketCanariesByExpiry = []byte("canaries.expires_at")
+	bucketHooks            = []byte("hooks")
+	bucketHooksByCanary    = []byte("hooks.canary")
+	bucketDeliveries       = []byte("deliveries")
+	bucketDeliveriesQueued = []byte("deliveries.pending")
+	bucketDeliveriesByHook = []byte("deliveries.hook")
+	bucketRevisions        = []byte("revisions")
+
+	ErrNotFound = errors.New("entry not found")
+)
+
+func init() {
+	factory.Register(driverName, &boltDriverFactory{})
+}
+
+type boltDriverFactory struct{}
+
+func (factory *boltDriverFactory) Create(parameters map[string]interface{}) (storage.StorageDriver, error) {
+	path, ok := parameters["path"].(string)
+	if !ok || path == "" {
+		return nil, storage.Error{
+			DriverName: driverName,
+			Enclosed:   errors.New(`"path" must be set for the bolt storage driver`),
+		}
+	}
+
+	retention, err := storage.ParseDeliveryRetention(parameters)
+	if err != nil {
+		return nil, storage.Error{DriverName: driverName, Enclosed: err}
+	}
+
+	d, err := New(path)
+	if err != nil {
+		return nil, err
+	}
+
+	d.deliveries.retention = retention
+	return d, nil
+}
+
+type driver struct {
+	db         *bolt.DB
+	hooks      *hookStorage
+	canaries   *canaryStorage
+	deliveries *deliveryStorage
+	revisions  *revisionStorage
+}
+
+func New(path string) (*driver, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, storage.Error{DriverName: driverName, Enclosed: err}
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		buckets := [][]byte{
+			bucketCanaries,
+			bucketCanariesDeleted,
+			bucketCanaryExpiries,
+			bucketHooks,
+			bucketHooksByCanary,
+			bucketDeliveries,
+			bucketDeliveriesQueued,
+			bucketRevisions,
+		}
+
+		for _, name := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+
+		canaryIndexes := [][]byte{bucketCanaryWarnings, bucketCanariesByUpdate, bucketCanariesByExpiry}
+		if err := createIndex(tx, canaryIndexes, reindexCanaries); err != nil {
+			return err
+		}
+
+		return createIndex(tx, [][]byte{bucketDeliveriesByHook}, reindexDeliveries)
+	})
+
+	if err != nil {
+		db.Close()
+		return nil, storage.Error{DriverName: driverName, Enclosed: err}
+	}
+
+	return &driver{
+		db:         db,
+		canaries:   &canaryStorage{db: db},
+		hooks:      &hookStorage{db: db},
+		deliveries: &deliveryStorage{db: db, retention: storage.DefaultDeliveryRetention},
+		revisions:  &revisionStorage{db: db},
+	}, nil
+}
+
+// createIndex creates the buckets of an index that databases written by an
+// older version don't have yet and builds it from the data already there.
+func createIndex(tx *bolt.Tx, names [][]byte, build func(tx *bolt.Tx) error) error {
+	missing := false
+	for _, name := range names {
+		if tx.Bucket(name) != nil {
+			continue
+		}
+
+		if _, err := tx.CreateBucket(name); err != nil {
+			return err
+		}
+
+		missing = true
+	}
+
+	if !missing {
+		return nil
+	}
+
+	return build(tx)
+}
+
+func reindexCanaries(tx *bolt.Tx) error {
+	return tx.Bucket(bucketCanaries).ForEach(func(_, data []byte) error {
+		c := &common.Canary{}
+		if err := decode(data, c); err != nil {
+			return err
+		}
+
+		return indexCanary(tx, c)
+	})
+}
+
+func reindexDeliveries(tx *bolt.Tx) error {
+	return tx.Bucket(bucketDeliveries).ForEach(func(_, data []byte) error {
+		d := &common.Delivery{}
+		if err := decode(data, d); err != nil {
+			return err
+		}
+
+		return indexDelivery(tx, d)
+	})
+}
+
+func (d *driver) Hooks() storage.HookStorage {
+	return d.hooks
+}
+
+func (d *driver) Canaries() storage.CanaryStorage {
+	return d.canaries
+}
+
+func (d *driver) Deliveries() storage.DeliveryStorage {
+	return d.deliveries
+}
+
+func (d *driver) Revisions() storage.RevisionStorage {
+	return d.revisions
+}
+
+func encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func decode(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+func wrapError(err error) error {
+	if err == nil || err == ErrNotFound || err == storage.ErrConflict {
+		return err
+	}
+
+	return storage.Error{DriverName: driverName, Enclosed: err}
+}
+
+type hookStorage struct {
+	db *bolt.DB
+}
+
+func (hs *hookStorage) Get(ctx context.Context, id string) (*common.WebHook, error) {
+	wh := &common.WebHook{}
+	err := hs.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketHooks).Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+
+		return decode(data, wh)
+	})
+
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return wh, nil
+}
+
+func (hs *hookStorage) Store(ctx context.Context, wh *common.WebHook) error {
+	data, err := encode(wh)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	return wrapError(hs.db.Update(func(tx *bolt.Tx) error {
+		hooks := tx.Bucket(bucketHooks)
+		if old := hooks.Get([]byte(wh.ID)); old != nil {
+			prev := &common.WebHook{}
+			if err := decode(old, prev); err != nil {
+				return err
+			}
+
+			if prev.CanaryID != wh.CanaryID {
+				if err := unindexHook(tx, prev); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := hooks.Put([]byte(wh.ID), data); err != nil {
+			return err
+		}
+
+		byCanary, err := tx.Bucket(bucketHooksByCanary).CreateBucketIfNotExists([]byte(wh.CanaryID))
+		if err != nil {
+			return err
+		}
+
+		return byCanary.Put([]byte(wh.ID), []byte{})
+	}))
+}
+
+func (hs *hookStorage) Delete(ctx context.Context, id string) error {
+	return wrapError(hs.db.Update(func(tx *bolt.Tx) error {
+		hooks := tx.Bucket(bucketHooks)
+		data := hooks.Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+
+		wh := &common.WebHook{}
+		if err := decode(data, wh); err != nil {
+			return err
+		}
+
+		if err := unindexHook(tx, wh); err != nil {
+			return err
+		}
+
+		return hooks.Delete([]byte(id))
+	}))
+}
+
+func (hs *hookStorage) GetForCanary(ctx context.Context, canaryID string) ([]*common.WebHook, error) {
+	hooks := make([]*common.WebHook, 0)
+	err := hs.db.View(func(tx *bolt.Tx) error {
+		byCanary := tx.Bucket(bucketHooksByCanary).Bucket([]byte(canaryID))
+		if byCanary == nil {
+			return nil
+		}
+
+		all := tx.Bucket(bucketHooks)
+		return byCanary.ForEach(func(k, _ []byte) error {
+			data := all.Get(k)
+			if data == nil {
+				return nil
+			}
+
+			wh := &common.WebHook{}
+			if err := decode(data, wh); err != nil {
+				return err
+			}
+
+			hooks = append(hooks, wh)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return hooks, nil
+}
+
+func (hs *hookStorage) List(ctx context.Context, q *storage.HookQuery) (*storage.HookPage, error) {
+	hooks, err := hs.GetForCanary(ctx, q.CanaryID)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.PaginateHooks(hooks, q)
+}
+
+func (hs *hookStorage) DeleteForCanary(ctx context.Context, canaryID string) ([]string, error) {
+	ids := make([]string, 0)
+	err := hs.db.Update(func(tx *bolt.Tx) error {
+		index := tx.Bucket(bucketHooksByCanary)
+		byCanary := index.Bucket([]byte(canaryID))
+		if byCanary == nil {
+			return nil
+		}
+
+		all := tx.Bucket(bucketHooks)
+		err := byCanary.ForEach(func(k, _ []byte) error {
+			ids = append(ids, string(k))
+			return all.Delete(k)
+		})
+
+		if err != nil {
+			return err
+		}
+
+		return index.DeleteBucket([]byte(canaryID))
+	})
+
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return ids, nil
+}
+
+func unindexHook(tx *bolt.Tx, wh *common.WebHook) error {
+	byCanary := tx.Bucket(bucketHooksByCanary).Bucket([]byte(wh.CanaryID))
+	if byCanary == nil {
+		return nil
+	}
+
+	return byCanary.Delete([]byte(wh.ID))
+}
+
+type canaryStorage struct {
+	db *bolt.DB
+}
+
+func (cs *canaryStorage) IsDeleted(ctx context.Context, id string) bool {
+	deleted := false
+	cs.db.View(func(tx *bolt.Tx) error {
+		deleted = tx.Bucket(bucketCanariesDeleted).Get([]byte(id)) != nil
+		return nil
+	})
+
+	return deleted
+}
+
+func (cs *canaryStorage) Get(ctx context.Context, id string) (*common.Canary, error) {
+	c := &common.Canary{}
+	err := cs.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketCanaries).Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+
+		return decode(data, c)
+	})
+
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return c, nil
+}
+
+func (cs *canaryStorage) Store(ctx context.Context, c *common.Canary, r *common.CanaryRevision) error {
+	data, err := encode(c)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	return wrapError(cs.db.Update(func(tx *bolt.Tx) error {
+		canaries := tx.Bucket(bucketCanaries)
+		if old := canaries.Get([]byte(c.ID)); old != nil {
+			prev := &common.Canary{}
+			if err := decode(old, prev); err != nil {
+				return err
+			}
+
+			if err := unindexCanary(tx, prev); err != nil {
+				return err
+			}
+		}
+
+		return putCanary(tx, c, data, r)
+	}))
+}
+
+func (cs *canaryStorage) Replace(ctx context.Context, c *common.Canary, lastUpdateToken string, r *common.CanaryRevision) error {
+	data, err := encode(c)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	return wrapError(cs.db.Update(func(tx *bolt.Tx) error {
+		old := tx.Bucket(bucketCanaries).Get([]byte(c.ID))
+		if old == nil {
+			return storage.ErrConflict
+		}
+
+		prev := &common.Canary{}
+		if err := decode(old, prev); err != nil {
+			return err
+		} else if prev.UpdateToken != lastUpdateToken {
+			return storage.ErrConflict
+		}
+
+		if err := unindexCanary(tx, prev); err != nil {
+			return err
+		}
+
+		return putCanary(tx, c, data, r)
+	}))
+}
+
+func putCanary(tx *bolt.Tx, c *common.Canary, data []byte, r *common.CanaryRevision) error {
+	if err := tx.Bucket(bucketCanaries).Put([]byte(c.ID), data); err != nil {
+		return err
+	} else if err := indexCanary(tx, c); err != nil {
+		return err
+	}
+
+	if r != nil {
+		return appendRevision(tx, r)
+	}
+
+	return nil
+}
+
+func (cs *canaryStorage) Delete(ctx context.Context, id string) error {
+	return wrapError(cs.db.Update(func(tx *bolt.Tx) error {
+		canaries := tx.Bucket(bucketCanaries)
+		data := canaries.Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+
+		c := &common.Canary{}
+		if err := decode(data, c); err != nil {
+			return err
+		}
+
+		if err := unindexCanary(tx, c); err != nil {
+			return err
+		}
+
+		if err := tx.Bucket(bucketCanariesDeleted).Put([]byte(id), data); err != nil {
+			return err
+		}
+
+		return canaries.Delete([]byte(id))
+	}))
+}
+
+func (cs *canaryStorage) GetExpired(ctx context.Context, before int64) ([]*common.Canary, error) {
+	expired := make([]*common.Canary, 0)
+	err := cs.db.View(func(tx *bolt.Tx) error {
+		canaries := tx.Bucket(bucketCanaries)
+		cur := tx.Bucket(bucketCanaryExpiries).Cursor()
+		for k, v := cur.First(); k != nil; k, v = cur.Next() {
+			if int64(binary.BigEndian.Uint64(k[:8])) >= before {
+				break
+			}
+
+			data := canaries.Get(v)
+			if data == nil {
+				continue
+			}
+
+			c := &common.Canary{}
+			if err := decode(data, c); err != nil {
+				return err
+			}
+
+			expired = append(expired, c)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return expired, nil
+}
+
+func (cs *canaryStorage) GetWarnable(ctx context.Context, now int64) ([]*common.Canary, error) {
+	warnable := make([]*common.Canary, 0)
+	err := cs.db.View(func(tx *bolt.Tx) error {
+		canaries := tx.Bucket(bucketCanaries)
+		cur := tx.Bucket(bucketCanaryWarnings).Cursor()
+		for k, v := cur.First(); k != nil && orderedValue(k) <= now; k, v = cur.Next() {
+			data := canaries.Get(v)
+			if data == nil {
+				continue
+			}
+
+			c := &common.Canary{}
+			if err := decode(data, c); err != nil {
+				return err
+			}
+
+			warnable = append(warnable, c)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return warnable, nil
+}
+
+// List walks the index of the requested order from the cursor on and stops
+// once the page is full, canaries are only decoded until then.
+func (cs *canaryStorage) List(ctx context.Context, q *storage.CanaryQuery) (*storage.CanaryPage, error) {
+	var after []byte
+	if q.Cursor != "" {
+		value, id, err := storage.DecodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		after = orderedKey(value, id)
+	}
+
+	index := bucketCanariesByUpdate
+	if q.SortBy == storage.SortExpiresAt {
+		index = bucketCanariesByExpiry
+	}
+
+	limit := storage.PageLimit(q.Limit)
+	page := &storage.CanaryPage{Canaries: make([]*common.Canary, 0)}
+	err := cs.db.View(func(tx *bolt.Tx) error {
+		canaries := tx.Bucket(bucketCanaries)
+		cur := tx.Bucket(index).Cursor()
+		for k, v := seek(cur, after, q.Descending); k != nil; k, v = step(cur, q.Descending) {
+			data := canaries.Get(v)
+			if data == nil {
+				continue
+			}
+
+			c := &common.Canary{}
+			if err := decode(data, c); err != nil {
+				return err
+			} else if !q.Matches(c) {
+				continue
+			}
+
+			if len(page.Canaries) == limit {
+				last := page.Canaries[limit-1]
+				page.NextCursor = storage.EncodeCursor(q.SortValue(last), last.ID)
+				return nil
+			}
+
+			page.Canaries = append(page.Canaries, c)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return page, nil
+}
+
+// expiry keys sort by deadline first so the reaper can stop at the first
+// canary that is still alive.
+func expiryKey(c *common.Canary) []byte {
+	key := make([]byte, 8+len(c.ID))
+	binary.BigEndian.PutUint64(key, uint64(c.ExpiresAt()))
+	copy(key[8:], c.ID)
+	return key
+}
+
+// ordered keys sort by value, negative ones included, and then by id, the
+// order storage.Paginate uses.
+func orderedKey(value int64, id string) []byte {
+	key := make([]byte, 8+len(id))
+	binary.BigEndian.PutUint64(key, uint64(value)^1<<63)
+	copy(key[8:], id)
+	return key
+}
+
+func orderedValue(key []byte) int64 {
+	return int64(binary.BigEndian.Uint64(key[:8]) ^ 1<<63)
+}
+
+// seek positions cur on the first key after the one a page cursor points at,
+// going backwards when descending.
+func seek(cur *bolt.Cursor, after []byte, descending bool) ([]byte, []byte) {
+	if after == nil {
+		if descending {
+			return cur.Last()
+		}
+
+		return cur.First()
+	}
+
+	k, v := cur.Seek(after)
+	if descending {
+		if k == nil {
+			return cur.Last()
+		}
+
+		return cur.Prev()
+	}
+
+	if k != nil && bytes.Equal(k, after) {
+		return cur.Next()
+	}
+
+	return k, v
+}
+
+func step(cur *bolt.Cursor, descending bool) ([]byte, []byte) {
+	if descending {
+		return cur.Prev()
+	}
+
+	return cur.Next()
+}
+
+// indexCanary adds c to the reaper's expiry index, the warning index and the
+// orders List walks.
+func indexCanary(tx *bolt.Tx, c *common.Canary) error {
+	id := []byte(c.ID)
+	if err := tx.Bucket(bucketCanariesByUpdate).Put(orderedKey(c.UpdatedAt, c.ID), id); err != nil {
+		return err
+	} else if err := tx.Bucket(bucketCanariesByExpiry).Put(orderedKey(c.ExpiresAt(), c.ID), id); err != nil {
+		return err
+	}
+
+	if at := c.NextWarningAt(); at != 0 {
+		if err := tx.Bucket(bucketCanaryWarnings).Put(orderedKey(at, c.ID), id); err != nil {
+			return err
+		}
+	}
+
+	if c.IsDead() {
+		return nil
+	}
+
+	return tx.Bucket(bucketCanaryExpiries).Put(expiryKey(c), id)
+}
+
+func unindexCanary(tx *bolt.Tx, c *common.Canary) error {
+	if err := tx.Bucket(bucketCanariesByUpdate).Delete(orderedKey(c.UpdatedAt, c.ID)); err != nil {
+		return err
+	} else if err := tx.Bucket(bucketCanariesByExpiry).Delete(orderedKey(c.ExpiresAt(), c.ID)); err != nil {
+		return err
+	}
+
+	if at := c.NextWarningAt(); at != 0 {
+		if err := tx.Bucket(bucketCanaryWarnings).Delete(orderedKey(at, c.ID)); err != nil {
+			return err
+		}
+	}
+
+	if c.IsDead() {
+		return nil
+	}
+
+	return tx.Bucket(bucketCanaryExpiries).Delete(expiryKey(c))
+}
+
+type deliveryStorage struct {
+	db        *bolt.DB
+	retention storage.DeliveryRetention
+}
+
+func (ds *deliveryStorage) Get(ctx context.Context, id string) (*common.Delivery, error) {
+	d := &common.Delivery{}
+	err := ds.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketDeliveries).Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+
+		return decode(data, d)
+	})
+
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return d, nil
+}
+
+func (ds *deliveryStorage) Store(ctx context.Context, d *common.Delivery) error {
+	data, err := encode(d)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	return wrapError(ds.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(bucketDeliveries).Put([]byte(d.ID), data); err != nil {
+			return err
+		} else if err := indexDelivery(tx, d); err != nil {
+			return err
+		}
+
+		pending := tx.Bucket(bucketDeliveriesQueued)
+		if d.IsPending() {
+			return pending.Put([]byte(d.ID), []byte{})
+		}
+
+		return pending.Delete([]byte(d.ID))
+	}))
+}
+
+func (ds *deliveryStorage) Delete(ctx context.Context, id string) error {
+	return wrapError(ds.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(bucketDeliveriesQueued).Delete([]byte(id)); err != nil {
+			return err
+		}
+
+		return deleteDelivery(tx, id)
+	}))
+}
+
+func deleteDelivery(tx *bolt.Tx, id string) error {
+	deliveries := tx.Bucket(bucketDeliveries)
+	data := deliveries.Get([]byte(id))
+	if data == nil {
+		return nil
+	}
+
+	d := &common.Delivery{}
+	if err := decode(data, d); err != nil {
+		return err
+	} else if err := unindexDelivery(tx, d); err != nil {
+		return err
+	}
+
+	return deliveries.Delete([]byte(id))
+}
+
+// deliveries are indexed per hook, newest last, so a hook's deliveries are
+// listed without going through those of every other hook.
+func indexDelivery(tx *bolt.Tx, d *common.Delivery) error {
+	byHook, err := tx.Bucket(bucketDeliveriesByHook).CreateBucketIfNotExists([]byte(d.HookID))
+	if err != nil {
+		return err
+	}
+
+	return byHook.Put(orderedKey(d.CreatedAt, d.ID), []byte(d.ID))
+}
+
+func unindexDelivery(tx *bolt.Tx, d *common.Delivery) error {
+	byHook := tx.Bucket(bucketDeliveriesByHook).Bucket([]byte(d.HookID))
+	if byHook == nil {
+		return nil
+	}
+
+	return byHook.Delete(orderedKey(d.CreatedAt, d.ID))
+}
+
+func (ds *deliveryStorage) GetPending(ctx context.Context) ([]*common.Delivery, error) {
+	pending := make([]*common.Delivery, 0)
+	err := ds.db.View(func(tx *bolt.Tx) error {
+		all := tx.Bucket(bucketDeliveries)
+		return tx.Bucket(bucketDeliveriesQueued).ForEach(func(k, _ []byte) error {
+			data := all.Get(k)
+			if data == nil {
+				return fmt.Errorf("pending delivery %s has no record", k)
+			}
+
+			d := &common.Delivery{}
+			if err := decode(data, d); err != nil {
+				return err
+			}
+
+			pending = append(pending, d)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return pending, nil
+}
+
+func loadDeliveries(tx *bolt.Tx) ([]*common.Delivery, error) {
+	deliveries := make([]*common.Delivery, 0)
+	err := tx.Bucket(bucketDeliveries).ForEach(func(_, data []byte) error {
+		d := &common.Delivery{}
+		if err := decode(data, d); err != nil {
+			return err
+		}
+
+		deliveries = append(deliveries, d)
+		return nil
+	})
+
+	return deliveries, err
+}
+
+// List walks the hook's index backwards from the cursor, newest first, and
+// only decodes the deliveries of the page.
+func (ds *deliveryStorage) List(ctx context.Context, q *storage.DeliveryQuery) (*storage.DeliveryPage, error) {
+	var after []byte
+	if q.Cursor != "" {
+		createdAt, id, err := storage.DecodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		after = orderedKey(createdAt, id)
+	}
+
+	limit := storage.PageLimit(q.Limit)
+	page := &storage.DeliveryPage{Deliveries: make([]*common.Delivery, 0)}
+	err := ds.db.View(func(tx *bolt.Tx) error {
+		byHook := tx.Bucket(bucketDeliveriesByHook).Bucket([]byte(q.HookID))
+		if byHook == nil {
+			return nil
+		}
+
+		deliveries := tx.Bucket(bucketDeliveries)
+		cur := byHook.Cursor()
+		for k, v := seek(cur, after, true); k != nil; k, v = cur.Prev() {
+			data := deliveries.Get(v)
+			if data == nil {
+				continue
+			}
+
+			if len(page.Deliveries) == limit {
+				last := page.Deliveries[limit-1]
+				page.NextCursor = storage.EncodeCursor(last.CreatedAt, last.ID)
+				return nil
+			}
+
+			d := &common.Delivery{}
+			if err := decode(data, d); err != nil {
+				return err
+			}
+
+			page.Deliveries = append(page.Deliveries, d)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return page, nil
+}
+
+func (ds *deliveryStorage) Prune(ctx context.Context, now int64) (int, error) {
+	removed := 0
+	err := ds.db.Update(func(tx *bolt.Tx) error {
+		deliveries, err := loadDeliveries(tx)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ds.retention.Expired(deliveries, now) {
+			if err := deleteDelivery(tx, id); err != nil {
+				return err
+			}
+
+			removed++
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, wrapError(err)
+	}
+
+	return removed, nil
+}
+
+type revisionStorage struct {
+	db *bolt.DB
+}
+
+func sequenceKey(seq int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(seq))
+	return key
+}
+
+func (rs *revisionStorage) Append(ctx context.Context, r *common.CanaryRevision) error {
+	return wrapError(rs.db.Update(func(tx *bolt.Tx) error {
+		return appendRevision(tx, r)
+	}))
+}
+
+func appendRevision(tx *bolt.Tx, r *common.CanaryRevision) error {
+	revisions, err := tx.Bucket(bucketRevisions).CreateBucketIfNotExists([]byte(r.CanaryID))
+	if err != nil {
+		return err
+	}
+
+	r.Chain(0, "")
+	if _, last := revisions.Cursor().Last(); last != nil {
+		prev := &common.CanaryRevision{}
+		if err := decode(last, prev); err != nil {
+			return err
+		}
+
+		r.Chain(prev.Sequence, prev.Hash)
+	}
+
+	data, err := encode(r)
+	if err != nil {
+		return err
+	}
+
+	return revisions.Put(sequenceKey(r.Sequence), data)
+}
+
+func (rs *revisionStorage) List(ctx context.Context, q *storage.RevisionQuery) (*storage.RevisionPage, error) {
+	all := make([]*common.CanaryRevision, 0)
+	err := rs.db.View(func(tx *bolt.Tx) error {
+		revisions := tx.Bucket(bucketRevisions).Bucket([]byte(q.CanaryID))
+		if revisions == nil {
+			return nil
+		}
+
+		return revisions.ForEach(func(_, data []byte) error {
+			r := &common.CanaryRevision{}
+			if err := decode(data, r); err != nil {
+				return err
+			}
+
+			all = append(all, r)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return storage.PaginateRevisions(all, q)
+}
@@ -2,6 +2,7 @@ package memory
 
 import (
 	"errors"
+	"sort"
 	"sync"
 
 	"github.com/danielkrainas/canaria-api/common"
@@ -19,24 +20,43 @@ func init() {
 type memoryDriverFactory struct{}
 
 func (factory *memoryDriverFactory) Create(parameters map[string]interface{}) (storage.StorageDriver, error) {
-	return New(), nil
+	retention, err := storage.ParseDeliveryRetention(parameters)
+	if err != nil {
+		return nil, storage.Error{DriverName: driverName, Enclosed: err}
+	}
+
+	d := New()
+	d.deliveries.retention = retention
+	return d, nil
 }
 
 type driver struct {
-	hooks    *hookStorage
-	canaries *canaryStorage
+	hooks      *hookStorage
+	canaries   *canaryStorage
+	deliveries *deliveryStorage
+	revisions  *revisionStorage
 }
 
 func New() *driver {
+	revisions := &revisionStorage{
+		revisions: make(map[string][]common.CanaryRevision),
+	}
+
 	return &driver{
 		canaries: &canaryStorage{
-			deleted:  make(map[string]common.Canary),
-			canaries: make(map[string]common.Canary),
+			deleted:   make(map[string]common.Canary),
+			canaries:  make(map[string]common.Canary),
+			revisions: revisions,
 		},
 		hooks: &hookStorage{
 			hooks:         make(map[string]common.WebHook),
 			hooksByCanary: make(map[string][]common.WebHook),
 		},
+		deliveries: &deliveryStorage{
+			deliveries: make(map[string]common.Delivery),
+			retention:  storage.DefaultDeliveryRetention,
+		},
+		revisions: revisions,
 	}
 }
 
@@ -48,6 +68,14 @@ func (d *driver) Canaries() storage.CanaryStorage {
 	return d.canaries
 }
 
+func (d *driver) Deliveries() storage.DeliveryStorage {
+	return d.deliveries
+}
+
+func (d *driver) Revisions() storage.RevisionStorage {
+	return d.revisions
+}
+
 type hookStorage struct {
 	mu            sync.Mutex
 	hooks         map[string]common.WebHook
@@ -71,10 +99,12 @@ func (hs *hookStorage) Store(ctx context.Context, wh *common.WebHook) error {
 	defer hs.mu.Unlock()
 
 	hs.hooks[wh.ID] = *wh
-	hooks, ok := hs.hooksByCanary[wh.CanaryID]
-	if !ok {
-		hooks = make([]common.WebHook, 1)
-		hs.hooksByCanary[wh.CanaryID] = hooks
+	hooks := hs.hooksByCanary[wh.CanaryID]
+	for i := 0; i < len(hooks); i++ {
+		if hooks[i].ID == wh.ID {
+			hooks[i] = *wh
+			return nil
+		}
 	}
 
 	hs.hooksByCanary[wh.CanaryID] = append(hooks, *wh)
@@ -117,12 +147,22 @@ func (hs *hookStorage) GetForCanary(ctx context.Context, canaryID string) ([]*co
 	}
 
 	for _, wh := range vhooks {
+		wh := wh
 		hooks = append(hooks, &wh)
 	}
 
 	return hooks, nil
 }
 
+func (hs *hookStorage) List(ctx context.Context, q *storage.HookQuery) (*storage.HookPage, error) {
+	hooks, err := hs.GetForCanary(ctx, q.CanaryID)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.PaginateHooks(hooks, q)
+}
+
 func (hs *hookStorage) DeleteForCanary(ctx context.Context, canaryID string) ([]string, error) {
 	hs.mu.Lock()
 	defer hs.mu.Unlock()
@@ -132,7 +172,7 @@ func (hs *hookStorage) DeleteForCanary(ctx context.Context, canaryID string) ([]
 		return []string{}, nil
 	}
 
-	ids := make([]string, len(hooks))
+	ids := make([]string, 0, len(hooks))
 	for _, wh := range hooks {
 		ids = append(ids, wh.ID)
 		delete(hs.hooks, wh.ID)
@@ -142,10 +182,20 @@ func (hs *hookStorage) DeleteForCanary(ctx context.Context, canaryID string) ([]
 	return ids, nil
 }
 
+type expiryEntry struct {
+	id        string
+	expiresAt int64
+}
+
 type canaryStorage struct {
 	mu       sync.Mutex
 	canaries map[string]common.Canary
 	deleted  map[string]common.Canary
+	expiries []expiryEntry
+
+	// revisions are appended while the canary is locked, nothing sees the
+	// canary changed without its revision.
+	revisions *revisionStorage
 }
 
 func (cs *canaryStorage) IsDeleted(ctx context.Context, id string) bool {
@@ -168,11 +218,39 @@ func (cs *canaryStorage) Get(ctx context.Context, id string) (*common.Canary, er
 	return &c, nil
 }
 
-func (cs *canaryStorage) Store(ctx context.Context, c *common.Canary) error {
+func (cs *canaryStorage) Store(ctx context.Context, c *common.Canary, r *common.CanaryRevision) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if old, ok := cs.canaries[c.ID]; ok {
+		cs.unindexExpiry(&old)
+	}
+
+	cs.canaries[c.ID] = *c
+	cs.indexExpiry(c)
+	if r != nil {
+		cs.revisions.Append(ctx, r)
+	}
+
+	return nil
+}
+
+func (cs *canaryStorage) Replace(ctx context.Context, c *common.Canary, lastUpdateToken string, r *common.CanaryRevision) error {
 	cs.mu.Lock()
 	defer cs.mu.Unlock()
 
+	old, ok := cs.canaries[c.ID]
+	if !ok || old.UpdateToken != lastUpdateToken {
+		return storage.ErrConflict
+	}
+
+	cs.unindexExpiry(&old)
 	cs.canaries[c.ID] = *c
+	cs.indexExpiry(c)
+	if r != nil {
+		cs.revisions.Append(ctx, r)
+	}
+
 	return nil
 }
 
@@ -181,9 +259,201 @@ func (cs *canaryStorage) Delete(ctx context.Context, id string) error {
 	defer cs.mu.Unlock()
 
 	if c, ok := cs.canaries[id]; ok {
+		cs.unindexExpiry(&c)
 		delete(cs.canaries, id)
 		cs.deleted[id] = c
 	}
 
 	return nil
 }
+
+func (cs *canaryStorage) GetExpired(ctx context.Context, before int64) ([]*common.Canary, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	expired := make([]*common.Canary, 0)
+	for _, e := range cs.expiries {
+		if e.expiresAt >= before {
+			break
+		}
+
+		c := cs.canaries[e.id]
+		expired = append(expired, &c)
+	}
+
+	return expired, nil
+}
+
+func (cs *canaryStorage) GetWarnable(ctx context.Context, now int64) ([]*common.Canary, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	warnable := make([]*common.Canary, 0)
+	for _, c := range cs.canaries {
+		if at := c.NextWarningAt(); at != 0 && at <= now {
+			c := c
+			warnable = append(warnable, &c)
+		}
+	}
+
+	return warnable, nil
+}
+
+func (cs *canaryStorage) List(ctx context.Context, q *storage.CanaryQuery) (*storage.CanaryPage, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	canaries := make([]*common.Canary, 0, len(cs.canaries))
+	for _, c := range cs.canaries {
+		c := c
+		canaries = append(canaries, &c)
+	}
+
+	return storage.Paginate(canaries, q)
+}
+
+func (cs *canaryStorage) expiryPosition(expiresAt int64) int {
+	return sort.Search(len(cs.expiries), func(i int) bool {
+		return cs.expiries[i].expiresAt >= expiresAt
+	})
+}
+
+func (cs *canaryStorage) indexExpiry(c *common.Canary) {
+	if c.IsDead() {
+		return
+	}
+
+	e := expiryEntry{id: c.ID, expiresAt: c.ExpiresAt()}
+	i := cs.expiryPosition(e.expiresAt)
+	cs.expiries = append(cs.expiries, expiryEntry{})
+	copy(cs.expiries[i+1:], cs.expiries[i:])
+	cs.expiries[i] = e
+}
+
+func (cs *canaryStorage) unindexExpiry(c *common.Canary) {
+	if c.IsDead() {
+		return
+	}
+
+	for i := cs.expiryPosition(c.ExpiresAt()); i < len(cs.expiries); i++ {
+		if cs.expiries[i].id == c.ID {
+			cs.expiries = append(cs.expiries[:i], cs.expiries[i+1:]...)
+			return
+		}
+	}
+}
+
+type deliveryStorage struct {
+	mu         sync.Mutex
+	deliveries map[string]common.Delivery
+	retention  storage.DeliveryRetention
+}
+
+func (ds *deliveryStorage) Get(ctx context.Context, id string) (*common.Delivery, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	d, ok := ds.deliveries[id]
+	if !ok {
+		return nil, errors.New("entry not found")
+	}
+
+	return &d, nil
+}
+
+func (ds *deliveryStorage) Store(ctx context.Context, d *common.Delivery) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	ds.deliveries[d.ID] = *d
+	return nil
+}
+
+func (ds *deliveryStorage) Delete(ctx context.Context, id string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	delete(ds.deliveries, id)
+	return nil
+}
+
+func (ds *deliveryStorage) GetPending(ctx context.Context) ([]*common.Delivery, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	pending := make([]*common.Delivery, 0)
+	for _, d := range ds.deliveries {
+		if d.IsPending() {
+			d := d
+			pending = append(pending, &d)
+		}
+	}
+
+	return pending, nil
+}
+
+func (ds *deliveryStorage) List(ctx context.Context, q *storage.DeliveryQuery) (*storage.DeliveryPage, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	deliveries := make([]*common.Delivery, 0)
+	for _, d := range ds.deliveries {
+		if d.HookID == q.HookID {
+			d := d
+			deliveries = append(deliveries, &d)
+		}
+	}
+
+	return storage.PaginateDeliveries(deliveries, q)
+}
+
+func (ds *deliveryStorage) Prune(ctx context.Context, now int64) (int, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	deliveries := make([]*common.Delivery, 0, len(ds.deliveries))
+	for _, d := range ds.deliveries {
+		d := d
+		deliveries = append(deliveries, &d)
+	}
+
+	expired := ds.retention.Expired(deliveries, now)
+	for _, id := range expired {
+		delete(ds.deliveries, id)
+	}
+
+	return len(expired), nil
+}
+
+type revisionStorage struct {
+	mu        sync.Mutex
+	revisions map[string][]common.CanaryRevision
+}
+
+func (rs *revisionStorage) Append(ctx context.Context, r *common.CanaryRevision) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	revisions := rs.revisions[r.CanaryID]
+	if n := len(revisions); n > 0 {
+		r.Chain(revisions[n-1].Sequence, revisions[n-1].Hash)
+	} else {
+		r.Chain(0, "")
+	}
+
+	rs.revisions[r.CanaryID] = append(revisions, *r)
+	return nil
+}
+
+func (rs *revisionStorage) List(ctx context.Context, q *storage.RevisionQuery) (*storage.RevisionPage, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	revisions := make([]*common.CanaryRevision, 0, len(rs.revisions[q.CanaryID]))
+	for _, r := range rs.revisions[q.CanaryID] {
+		r := r
+		revisions = append(revisions, &r)
+	}
+
+	return storage.PaginateRevisions(revisions, q)
+}
@@ -0,0 +1,315 @@
+package storage
+
+import (
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/danielkrainas/canaria-api/common"
+)
+
+const (
+	SortUpdatedAt = "updated_at"
+	SortExpiresAt = "expires_at"
+
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type CanaryQuery struct {
+	States     []string
+	Selector   common.Selector
+	SortBy     string
+	Descending bool
+	Cursor     string
+	Limit      int
+}
+
+type CanaryPage struct {
+	Canaries   []*common.Canary
+	NextCursor string
+}
+
+type RevisionQuery struct {
+	CanaryID   string
+	Descending bool
+	Cursor     string
+	Limit      int
+}
+
+type RevisionPage struct {
+	Revisions  []*common.CanaryRevision
+	NextCursor string
+}
+
+type HookQuery struct {
+	CanaryID string
+	Cursor   string
+	Limit    int
+}
+
+type HookPage struct {
+	Hooks      []*common.WebHook
+	NextCursor string
+}
+
+type DeliveryQuery struct {
+	HookID string
+	Cursor string
+	Limit  int
+}
+
+type DeliveryPage struct {
+	Deliveries []*common.Delivery
+	NextCursor string
+}
+
+func (q *CanaryQuery) Matches(c *common.Canary) bool {
+	if len(q.States) > 0 {
+		state := c.State()
+		found := false
+		for _, s := range q.States {
+			if s == state {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return q.Selector.Matches(c.Labels)
+}
+
+// SortValue is the value canaries are ordered by, ties are broken by id.
+func (q *CanaryQuery) SortValue(c *common.Canary) int64 {
+	if q.SortBy == SortExpiresAt {
+		return c.ExpiresAt()
+	}
+
+	return c.UpdatedAt
+}
+
+func (q *CanaryQuery) less(a *common.Canary, av int64, b *common.Canary, bv int64) bool {
+	if av == bv {
+		if q.Descending {
+			return a.ID > b.ID
+		}
+
+		return a.ID < b.ID
+	}
+
+	if q.Descending {
+		return av > bv
+	}
+
+	return av < bv
+}
+
+// EncodeCursor makes an opaque cursor from the sort value and id of the last
+// entry of a page.
+func EncodeCursor(value int64, id string) string {
+	return base64.URLEncoding.EncodeToString([]byte(strconv.FormatInt(value, 10) + ":" + id))
+}
+
+// DecodeCursor reverses EncodeCursor.
+func DecodeCursor(cursor string) (int64, string, error) {
+	raw, err := base64.URLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, "", ErrInvalidCursor
+	}
+
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return 0, "", ErrInvalidCursor
+	}
+
+	value, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, "", ErrInvalidCursor
+	}
+
+	return value, parts[1], nil
+}
+
+// PageLimit bounds a requested page size, zero meaning the default.
+func PageLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultPageSize
+	} else if limit > MaxPageSize {
+		return MaxPageSize
+	}
+
+	return limit
+}
+
+// Paginate filters, sorts and pages the given canaries according to the
+// query. It is shared by drivers that cannot evaluate the query natively.
+func Paginate(canaries []*common.Canary, q *CanaryQuery) (*CanaryPage, error) {
+	limit := PageLimit(q.Limit)
+	matched := make([]*common.Canary, 0, len(canaries))
+	for _, c := range canaries {
+		if q.Matches(c) {
+			matched = append(matched, c)
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return q.less(matched[i], q.SortValue(matched[i]), matched[j], q.SortValue(matched[j]))
+	})
+
+	start := 0
+	if q.Cursor != "" {
+		value, id, err := DecodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		after := &common.Canary{ID: id}
+		start = sort.Search(len(matched), func(i int) bool {
+			return q.less(after, value, matched[i], q.SortValue(matched[i]))
+		})
+	}
+
+	page := &CanaryPage{}
+	end := start + limit
+	if end >= len(matched) {
+		end = len(matched)
+	} else {
+		last := matched[end-1]
+		page.NextCursor = EncodeCursor(q.SortValue(last), last.ID)
+	}
+
+	page.Canaries = matched[start:end]
+	return page, nil
+}
+
+// PaginateRevisions pages revisions that are already ordered by ascending
+// sequence. The cursor is the sequence of the last revision returned.
+func PaginateRevisions(revisions []*common.CanaryRevision, q *RevisionQuery) (*RevisionPage, error) {
+	ordered := revisions
+	if q.Descending {
+		ordered = make([]*common.CanaryRevision, len(revisions))
+		for i, r := range revisions {
+			ordered[len(revisions)-1-i] = r
+		}
+	}
+
+	start := 0
+	if q.Cursor != "" {
+		after, _, err := DecodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		start = sort.Search(len(ordered), func(i int) bool {
+			if q.Descending {
+				return ordered[i].Sequence < after
+			}
+
+			return ordered[i].Sequence > after
+		})
+	}
+
+	page := &RevisionPage{}
+	end := start + PageLimit(q.Limit)
+	if end >= len(ordered) {
+		end = len(ordered)
+	} else {
+		page.NextCursor = EncodeCursor(ordered[end-1].Sequence, q.CanaryID)
+	}
+
+	page.Revisions = ordered[start:end]
+	return page, nil
+}
+
+// PaginateHooks pages the hooks of a canary ordered by id. The cursor is the
+// id of the last hook returned.
+func PaginateHooks(hooks []*common.WebHook, q *HookQuery) (*HookPage, error) {
+	matched := make([]*common.WebHook, 0, len(hooks))
+	for _, wh := range hooks {
+		if wh.CanaryID == q.CanaryID {
+			matched = append(matched, wh)
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].ID < matched[j].ID
+	})
+
+	start := 0
+	if q.Cursor != "" {
+		_, after, err := DecodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		start = sort.Search(len(matched), func(i int) bool {
+			return matched[i].ID > after
+		})
+	}
+
+	page := &HookPage{}
+	end := start + PageLimit(q.Limit)
+	if end >= len(matched) {
+		end = len(matched)
+	} else {
+		page.NextCursor = EncodeCursor(0, matched[end-1].ID)
+	}
+
+	page.Hooks = matched[start:end]
+	return page, nil
+}
+
+func newerDelivery(a *common.Delivery, b *common.Delivery) bool {
+	if a.CreatedAt == b.CreatedAt {
+		return a.ID > b.ID
+	}
+
+	return a.CreatedAt > b.CreatedAt
+}
+
+// PaginateDeliveries pages the deliveries of a hook, newest first.
+func PaginateDeliveries(deliveries []*common.Delivery, q *DeliveryQuery) (*DeliveryPage, error) {
+	matched := make([]*common.Delivery, 0, len(deliveries))
+	for _, d := range deliveries {
+		if d.HookID == q.HookID {
+			matched = append(matched, d)
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return newerDelivery(matched[i], matched[j])
+	})
+
+	start := 0
+	if q.Cursor != "" {
+		createdAt, id, err := DecodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		after := &common.Delivery{ID: id, CreatedAt: createdAt}
+		start = sort.Search(len(matched), func(i int) bool {
+			return newerDelivery(after, matched[i])
+		})
+	}
+
+	page := &DeliveryPage{}
+	end := start + PageLimit(q.Limit)
+	if end >= len(matched) {
+		end = len(matched)
+	} else {
+		last := matched[end-1]
+		page.NextCursor = EncodeCursor(last.CreatedAt, last.ID)
+	}
+
+	page.Deliveries = matched[start:end]
+	return page, nil
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/danielkrainas/canaria-api/common"
+)
+
+const (
+	DefaultDeliveryMaxAge    = 7 * 24 * time.Hour
+	DefaultDeliveriesPerHook = 100
+)
+
+// DeliveryRetention limits how many finished deliveries a driver keeps.
+// Pending deliveries are never pruned.
+type DeliveryRetention struct {
+	MaxAge     time.Duration
+	MaxPerHook int
+}
+
+var DefaultDeliveryRetention = DeliveryRetention{
+	MaxAge:     DefaultDeliveryMaxAge,
+	MaxPerHook: DefaultDeliveriesPerHook,
+}
+
+// ParseDeliveryRetention reads the "deliveryretention" (a duration) and
+// "maxdeliveries" (per hook) driver parameters. A value of 0 disables the
+// corresponding limit.
+func ParseDeliveryRetention(parameters map[string]interface{}) (DeliveryRetention, error) {
+	r := DefaultDeliveryRetention
+	switch v := parameters["deliveryretention"].(type) {
+	case nil:
+	case string:
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return r, fmt.Errorf("invalid deliveryretention: %q", v)
+		}
+
+		r.MaxAge = d
+	case int:
+		if v < 0 {
+			return r, fmt.Errorf("invalid deliveryretention: %d", v)
+		}
+
+		r.MaxAge = time.Duration(v) * time.Second
+	default:
+		return r, fmt.Errorf("invalid deliveryretention: %v", v)
+	}
+
+	switch v := parameters["maxdeliveries"].(type) {
+	case nil:
+	case int:
+		if v < 0 {
+			return r, fmt.Errorf("invalid maxdeliveries: %d", v)
+		}
+
+		r.MaxPerHook = v
+	default:
+		return r, fmt.Errorf("invalid maxdeliveries: %v", v)
+	}
+
+	return r, nil
+}
+
+// Expired returns the IDs of the finished deliveries that fall outside of the
+// retention limits.
+func (r DeliveryRetention) Expired(deliveries []*common.Delivery, now int64) []string {
+	byHook := make(map[string][]*common.Delivery)
+	for _, d := range deliveries {
+		if !d.IsPending() {
+			byHook[d.HookID] = append(byHook[d.HookID], d)
+		}
+	}
+
+	expired := make([]string, 0)
+	cutoff := now - int64(r.MaxAge/time.Second)
+	for _, finished := range byHook {
+		sort.Slice(finished, func(i, j int) bool {
+			return newerDelivery(finished[i], finished[j])
+		})
+
+		for i, d := range finished {
+			if (r.MaxPerHook > 0 && i >= r.MaxPerHook) || (r.MaxAge > 0 && d.CreatedAt < cutoff) {
+				expired = append(expired, d.ID)
+			}
+		}
+	}
+
+	return expired
+}
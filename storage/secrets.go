@@ -0,0 +1,266 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/danielkrainas/canaria-api/common"
+	"github.com/danielkrainas/canaria-api/context"
+)
+
+const sealedSecretPrefix = "enc:v1:"
+
+var ErrSecretKeyMissing = errors.New("encrypted secret found but no secret key is configured")
+
+// SecretCipher seals webhook secrets with AES-256-GCM before they reach a
+// storage driver.
+type SecretCipher struct {
+	aead cipher.AEAD
+}
+
+func NewSecretCipher(key []byte) (*SecretCipher, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("secret key must be 32 bytes, got %d", len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SecretCipher{aead: aead}, nil
+}
+
+func (sc *SecretCipher) Seal(secret string) (string, error) {
+	if secret == "" || sc == nil {
+		return secret, nil
+	}
+
+	nonce := make([]byte, sc.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := sc.aead.Seal(nonce, nonce, []byte(secret), nil)
+	return sealedSecretPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open reverses Seal. Values that were stored before a key was configured are
+// returned as they are.
+func (sc *SecretCipher) Open(value string) (string, error) {
+	if !strings.HasPrefix(value, sealedSecretPrefix) {
+		return value, nil
+	} else if sc == nil {
+		return "", ErrSecretKeyMissing
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, sealedSecretPrefix))
+	if err != nil {
+		return "", err
+	}
+
+	n := sc.aead.NonceSize()
+	if len(sealed) < n {
+		return "", errors.New("sealed secret is too short")
+	}
+
+	secret, err := sc.aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return "", err
+	}
+
+	return string(secret), nil
+}
+
+func (sc *SecretCipher) sealHook(wh *common.WebHook) (*common.WebHook, error) {
+	sealed := *wh
+	var err error
+	if sealed.Secret, err = sc.Seal(wh.Secret); err != nil {
+		return nil, err
+	} else if sealed.PreviousSecret, err = sc.Seal(wh.PreviousSecret); err != nil {
+		return nil, err
+	}
+
+	return &sealed, nil
+}
+
+func (sc *SecretCipher) openHook(wh *common.WebHook) error {
+	var err error
+	if wh.Secret, err = sc.Open(wh.Secret); err != nil {
+		return err
+	} else if wh.PreviousSecret, err = sc.Open(wh.PreviousSecret); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// WithSecretCipher wraps the driver so that hook secrets, including those in
+// the hook snapshots of queued deliveries, are only ever stored sealed. A nil
+// cipher leaves new secrets in plain text but still refuses to hand out
+// sealed ones.
+func WithSecretCipher(d StorageDriver, sc *SecretCipher) StorageDriver {
+	return &sealedDriver{
+		StorageDriver: d,
+		hooks:         &sealedHookStorage{HookStorage: d.Hooks(), cipher: sc},
+		deliveries:    &sealedDeliveryStorage{DeliveryStorage: d.Deliveries(), cipher: sc},
+	}
+}
+
+type sealedDriver struct {
+	StorageDriver
+
+	hooks      *sealedHookStorage
+	deliveries *sealedDeliveryStorage
+}
+
+func (d *sealedDriver) Hooks() HookStorage {
+	return d.hooks
+}
+
+func (d *sealedDriver) Deliveries() DeliveryStorage {
+	return d.deliveries
+}
+
+type sealedHookStorage struct {
+	HookStorage
+
+	cipher *SecretCipher
+}
+
+func (hs *sealedHookStorage) Get(ctx context.Context, id string) (*common.WebHook, error) {
+	wh, err := hs.HookStorage.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := hs.cipher.openHook(wh); err != nil {
+		return nil, err
+	}
+
+	return wh, nil
+}
+
+func (hs *sealedHookStorage) Store(ctx context.Context, wh *common.WebHook) error {
+	sealed, err := hs.cipher.sealHook(wh)
+	if err != nil {
+		return err
+	}
+
+	return hs.HookStorage.Store(ctx, sealed)
+}
+
+func (hs *sealedHookStorage) GetForCanary(ctx context.Context, canaryID string) ([]*common.WebHook, error) {
+	hooks, err := hs.HookStorage.GetForCanary(ctx, canaryID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, wh := range hooks {
+		if err := hs.cipher.openHook(wh); err != nil {
+			return nil, err
+		}
+	}
+
+	return hooks, nil
+}
+
+func (hs *sealedHookStorage) List(ctx context.Context, q *HookQuery) (*HookPage, error) {
+	page, err := hs.HookStorage.List(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, wh := range page.Hooks {
+		if err := hs.cipher.openHook(wh); err != nil {
+			return nil, err
+		}
+	}
+
+	return page, nil
+}
+
+type sealedDeliveryStorage struct {
+	DeliveryStorage
+
+	cipher *SecretCipher
+}
+
+func (ds *sealedDeliveryStorage) open(deliveries ...*common.Delivery) error {
+	for _, d := range deliveries {
+		if d.Hook == nil {
+			continue
+		}
+
+		if err := ds.cipher.openHook(d.Hook); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (ds *sealedDeliveryStorage) Get(ctx context.Context, id string) (*common.Delivery, error) {
+	d, err := ds.DeliveryStorage.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ds.open(d); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func (ds *sealedDeliveryStorage) Store(ctx context.Context, d *common.Delivery) error {
+	if d.Hook == nil {
+		return ds.DeliveryStorage.Store(ctx, d)
+	}
+
+	hook, err := ds.cipher.sealHook(d.Hook)
+	if err != nil {
+		return err
+	}
+
+	sealed := *d
+	sealed.Hook = hook
+	return ds.DeliveryStorage.Store(ctx, &sealed)
+}
+
+func (ds *sealedDeliveryStorage) GetPending(ctx context.Context) ([]*common.Delivery, error) {
+	pending, err := ds.DeliveryStorage.GetPending(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ds.open(pending...); err != nil {
+		return nil, err
+	}
+
+	return pending, nil
+}
+
+func (ds *sealedDeliveryStorage) List(ctx context.Context, q *DeliveryQuery) (*DeliveryPage, error) {
+	page, err := ds.DeliveryStorage.List(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ds.open(page.Deliveries...); err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
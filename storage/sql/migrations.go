@@ -0,0 +1,385 @@
+package sql
+
+import (
+	gosql "database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/danielkrainas/canaria-api/common"
+)
+
+type migration struct {
+	version     int
+	description string
+	statements  []string
+
+	// apply runs after the statements for changes that SQL alone can't make.
+	apply func(tx *gosql.Tx, d *dialect) error
+}
+
+var migrations = []migration{
+	{
+		version:     1,
+		description: "create canary, label, hook and tombstone tables",
+		statements: []string{
+			`CREATE TABLE canaries (
+				id TEXT PRIMARY KEY,
+				ttl BIGINT NOT NULL,
+				updated_at BIGINT NOT NULL,
+				expires_at BIGINT,
+				title TEXT NOT NULL,
+				message TEXT NOT NULL,
+				signature TEXT NOT NULL,
+				pubkey TEXT NOT NULL,
+				pubkey_url TEXT NOT NULL,
+				update_token TEXT NOT NULL
+			)`,
+			`CREATE INDEX canaries_expires_at ON canaries (expires_at)`,
+			`CREATE TABLE canary_labels (
+				canary_id TEXT NOT NULL,
+				position INTEGER NOT NULL,
+				label TEXT NOT NULL,
+				PRIMARY KEY (canary_id, position)
+			)`,
+			`CREATE INDEX canary_labels_label ON canary_labels (label)`,
+			`CREATE TABLE canary_tombstones (
+				id TEXT PRIMARY KEY,
+				deleted_at BIGINT NOT NULL
+			)`,
+			`CREATE TABLE hooks (
+				id TEXT PRIMARY KEY,
+				canary_id TEXT NOT NULL,
+				name TEXT NOT NULL,
+				content_type TEXT NOT NULL,
+				secret TEXT NOT NULL,
+				signature_algorithm TEXT NOT NULL,
+				insecure_ssl BOOLEAN NOT NULL,
+				url TEXT NOT NULL,
+				events TEXT NOT NULL,
+				active BOOLEAN NOT NULL,
+				update_token TEXT NOT NULL,
+				updated_at BIGINT NOT NULL
+			)`,
+			`CREATE INDEX hooks_canary_id ON hooks (canary_id)`,
+		},
+	},
+	{
+		version:     2,
+		description: "create delivery table",
+		statements: []string{
+			`CREATE TABLE deliveries (
+				id TEXT PRIMARY KEY,
+				hook_id TEXT NOT NULL,
+				canary_id TEXT NOT NULL,
+				event TEXT NOT NULL,
+				status TEXT NOT NULL,
+				attempts INTEGER NOT NULL,
+				created_at BIGINT NOT NULL,
+				next_attempt_at BIGINT NOT NULL,
+				payload BLOB NOT NULL
+			)`,
+			`CREATE INDEX deliveries_status ON deliveries (status, next_attempt_at)`,
+		},
+	},
+	{
+		version:     3,
+		description: "add canary signature verification columns",
+		statements: []string{
+			`ALTER TABLE canaries ADD COLUMN pubkey_fingerprint TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE canaries ADD COLUMN verified BOOLEAN NOT NULL DEFAULT FALSE`,
+		},
+	},
+	{
+		version:     4,
+		description: "add canary revision column",
+		statements: []string{
+			`ALTER TABLE canaries ADD COLUMN revision BIGINT NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		version:     5,
+		description: "create canary revision history table",
+		statements: []string{
+			`CREATE TABLE canary_revisions (
+				canary_id TEXT NOT NULL,
+				sequence BIGINT NOT NULL,
+				id TEXT NOT NULL,
+				action TEXT NOT NULL,
+				revision BIGINT NOT NULL,
+				recorded_at BIGINT NOT NULL,
+				ttl BIGINT NOT NULL,
+				actor TEXT NOT NULL,
+				remote_addr TEXT NOT NULL,
+				content_hash TEXT NOT NULL,
+				previous_token_hash TEXT NOT NULL,
+				PRIMARY KEY (canary_id, sequence)
+			)`,
+		},
+	},
+	{
+		version:     6,
+		description: "add published state and hash chain to canary revisions",
+		statements: []string{
+			`ALTER TABLE canary_revisions ADD COLUMN title TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE canary_revisions ADD COLUMN message TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE canary_revisions ADD COLUMN labels TEXT NOT NULL DEFAULT '[]'`,
+			`ALTER TABLE canary_revisions ADD COLUMN updated_at BIGINT NOT NULL DEFAULT 0`,
+			`ALTER TABLE canary_revisions ADD COLUMN signature TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE canary_revisions ADD COLUMN previous_hash TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE canary_revisions ADD COLUMN hash TEXT NOT NULL DEFAULT ''`,
+		},
+		apply: hashPreChainRevisions,
+	},
+	{
+		version:     7,
+		description: "add canary expiry warnings",
+		statements: []string{
+			`ALTER TABLE canaries ADD COLUMN warnings TEXT NOT NULL DEFAULT '[]'`,
+			`ALTER TABLE canaries ADD COLUMN warnings_sent TEXT NOT NULL DEFAULT '[]'`,
+			`ALTER TABLE canaries ADD COLUMN next_warning_at BIGINT`,
+			`CREATE INDEX canaries_next_warning_at ON canaries (next_warning_at)`,
+		},
+	},
+	{
+		version:     8,
+		description: "index deliveries by hook",
+		statements: []string{
+			`CREATE INDEX deliveries_hook ON deliveries (hook_id, created_at)`,
+		},
+	},
+	{
+		version:     9,
+		description: "add hook secret rotation columns",
+		statements: []string{
+			`ALTER TABLE hooks ADD COLUMN secret_fingerprint TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE hooks ADD COLUMN previous_secret TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE hooks ADD COLUMN previous_secret_expires_at BIGINT NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		version:     10,
+		description: "add hook failure tracking columns",
+		statements: []string{
+			`ALTER TABLE hooks ADD COLUMN consecutive_failures INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE hooks ADD COLUMN last_error TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE hooks ADD COLUMN disabled_at BIGINT NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		version:     11,
+		description: "add hook notifier type and options",
+		statements: []string{
+			`ALTER TABLE hooks ADD COLUMN type TEXT NOT NULL DEFAULT 'webhook'`,
+			`ALTER TABLE hooks ADD COLUMN options TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version:     12,
+		description: "add hook payload templates",
+		statements: []string{
+			`ALTER TABLE hooks ADD COLUMN template TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version:     13,
+		description: "add canary owners and collaborators",
+		statements: []string{
+			`ALTER TABLE canaries ADD COLUMN owner TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE canaries ADD COLUMN collaborators TEXT NOT NULL DEFAULT '[]'`,
+		},
+	},
+	{
+		version:     14,
+		description: "split canary labels into keys and values for selectors",
+		statements: []string{
+			`ALTER TABLE canary_labels ADD COLUMN label_key TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE canary_labels ADD COLUMN label_value TEXT NOT NULL DEFAULT ''`,
+			`CREATE INDEX canary_labels_key ON canary_labels (label_key, label_value)`,
+			`CREATE INDEX canaries_updated_at ON canaries (updated_at)`,
+		},
+		apply: splitLabels,
+	},
+	{
+		version:     15,
+		description: "record the member a revision changed",
+		statements: []string{
+			`ALTER TABLE canary_revisions ADD COLUMN member TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+}
+
+func migrate(db *gosql.DB, d *dialect) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied_at BIGINT NOT NULL
+	)`)
+
+	if err != nil {
+		return err
+	}
+
+	var current int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		if err := applyMigration(db, d, m); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %v", m.version, m.description, err)
+		}
+	}
+
+	return nil
+}
+
+func applyMigration(db *gosql.DB, d *dialect, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range m.statements {
+		if _, err := tx.Exec(d.ddl(stmt)); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if m.apply != nil {
+		if err := m.apply(tx, d); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	_, err = tx.Exec(d.rebind(`INSERT INTO schema_migrations (version, description, applied_at) VALUES (?, ?, ?)`), m.version, m.description, time.Now().Unix())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// hashPreChainRevisions starts the chain of every canary with the revisions
+// recorded before there was one. It only runs as part of the migration that
+// adds the chain, when nothing has been linked or published yet, so no hash
+// that was ever served changes.
+func hashPreChainRevisions(tx *gosql.Tx, d *dialect) error {
+	rows, err := tx.Query(`SELECT DISTINCT canary_id FROM canary_revisions WHERE hash = ''`)
+	if err != nil {
+		return err
+	}
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+
+		ids = append(ids, id)
+	}
+
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err := chainRevisions(tx, d, id); err != nil {
+			return fmt.Errorf("canary %s: %v", id, err)
+		}
+	}
+
+	return nil
+}
+
+func chainRevisions(tx *gosql.Tx, d *dialect, canaryID string) error {
+	rows, err := tx.Query(d.rebind(`SELECT sequence, action, ttl, title, message, labels, updated_at, signature FROM canary_revisions WHERE canary_id = ? ORDER BY sequence`), canaryID)
+	if err != nil {
+		return err
+	}
+
+	var entries []*common.ChainEntry
+	for rows.Next() {
+		e := &common.ChainEntry{CanaryID: canaryID}
+		var labels string
+		if err := rows.Scan(&e.Sequence, &e.Action, &e.TimeToLive, &e.Title, &e.Message, &labels, &e.UpdatedAt, &e.Signature); err != nil {
+			rows.Close()
+			return err
+		}
+
+		if err := json.Unmarshal([]byte(labels), &e.Labels); err != nil {
+			rows.Close()
+			return err
+		}
+
+		entries = append(entries, e)
+	}
+
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	prevHash := ""
+	for _, e := range entries {
+		e.PreviousHash = prevHash
+		e.Hash = e.ComputeHash()
+		if _, err := tx.Exec(d.rebind(`UPDATE canary_revisions SET previous_hash = ?, hash = ? WHERE canary_id = ? AND sequence = ?`), e.PreviousHash, e.Hash, canaryID, e.Sequence); err != nil {
+			return err
+		}
+
+		prevHash = e.Hash
+	}
+
+	return nil
+}
+
+// splitLabels fills in the key and value of the labels stored before
+// migration 14, the split is the one selectors use.
+func splitLabels(tx *gosql.Tx, d *dialect) error {
+	rows, err := tx.Query(`SELECT canary_id, position, label FROM canary_labels`)
+	if err != nil {
+		return err
+	}
+
+	type storedLabel struct {
+		canaryID string
+		position int
+		label    string
+	}
+
+	var labels []storedLabel
+	for rows.Next() {
+		var l storedLabel
+		if err := rows.Scan(&l.canaryID, &l.position, &l.label); err != nil {
+			rows.Close()
+			return err
+		}
+
+		labels = append(labels, l)
+	}
+
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, l := range labels {
+		key, value := common.SplitLabel(l.label)
+		if _, err := tx.Exec(d.rebind(`UPDATE canary_labels SET label_key = ?, label_value = ? WHERE canary_id = ? AND position = ?`), key, value, l.canaryID, l.position); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,945 @@
+package sql
+
+import (
+	"bytes"
+	gosql "database/sql"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/danielkrainas/canaria-api/common"
+	"github.com/danielkrainas/canaria-api/context"
+	"github.com/danielkrainas/canaria-api/storage"
+	"github.com/danielkrainas/canaria-api/storage/factory"
+)
+
+const driverName = "sql"
+
+var ErrNotFound = errors.New("entry not found")
+
+type dialect struct {
+	name     string
+	driver   string
+	numbered bool
+	types    *strings.Replacer
+}
+
+var dialects = map[string]*dialect{
+	"sqlite3": {
+		name:   "sqlite3",
+		driver: "sqlite3",
+		types:  strings.NewReplacer(),
+	},
+	"postgres": {
+		name:     "postgres",
+		driver:   "postgres",
+		numbered: true,
+		types:    strings.NewReplacer("BLOB", "BYTEA"),
+	},
+}
+
+func (d *dialect) rebind(query string) string {
+	if !d.numbered {
+		return query
+	}
+
+	var buf bytes.Buffer
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			buf.WriteString("$" + strconv.Itoa(n))
+		} else {
+			buf.WriteRune(r)
+		}
+	}
+
+	return buf.String()
+}
+
+func (d *dialect) ddl(stmt string) string {
+	return d.types.Replace(stmt)
+}
+
+func init() {
+	factory.Register(driverName, &sqlDriverFactory{})
+}
+
+type sqlDriverFactory struct{}
+
+func (factory *sqlDriverFactory) Create(parameters map[string]interface{}) (storage.StorageDriver, error) {
+	dialectName, _ := parameters["dialect"].(string)
+	if dialectName == "" {
+		dialectName = "sqlite3"
+	}
+
+	dsn, ok := parameters["dsn"].(string)
+	if !ok || dsn == "" {
+		return nil, storage.Error{
+			DriverName: driverName,
+			Enclosed:   errors.New(`"dsn" must be set for the sql storage driver`),
+		}
+	}
+
+	retention, err := storage.ParseDeliveryRetention(parameters)
+	if err != nil {
+		return nil, storage.Error{DriverName: driverName, Enclosed: err}
+	}
+
+	d, err := New(dialectName, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	d.deliveries.retention = retention
+	return d, nil
+}
+
+type driver struct {
+	db         *gosql.DB
+	hooks      *hookStorage
+	canaries   *canaryStorage
+	deliveries *deliveryStorage
+	revisions  *revisionStorage
+}
+
+func New(dialectName string, dsn string) (*driver, error) {
+	d, ok := dialects[dialectName]
+	if !ok {
+		return nil, storage.Error{
+			DriverName: driverName,
+			Enclosed:   fmt.Errorf("unsupported dialect: %q", dialectName),
+		}
+	}
+
+	db, err := gosql.Open(d.driver, dsn)
+	if err != nil {
+		return nil, storage.Error{DriverName: driverName, Enclosed: err}
+	}
+
+	if d.name == "sqlite3" {
+		// sqlite only allows a single writer, serialize access instead of
+		// failing with "database is locked".
+		db.SetMaxOpenConns(1)
+	}
+
+	if err := migrate(db, d); err != nil {
+		db.Close()
+		return nil, storage.Error{DriverName: driverName, Enclosed: err}
+	}
+
+	return &driver{
+		db:         db,
+		canaries:   &canaryStorage{db: db, dialect: d},
+		hooks:      &hookStorage{db: db, dialect: d},
+		deliveries: &deliveryStorage{db: db, dialect: d, retention: storage.DefaultDeliveryRetention},
+		revisions:  &revisionStorage{db: db, dialect: d},
+	}, nil
+}
+
+func (d *driver) Hooks() storage.HookStorage {
+	return d.hooks
+}
+
+func (d *driver) Canaries() storage.CanaryStorage {
+	return d.canaries
+}
+
+func (d *driver) Deliveries() storage.DeliveryStorage {
+	return d.deliveries
+}
+
+func (d *driver) Revisions() storage.RevisionStorage {
+	return d.revisions
+}
+
+func wrapError(err error) error {
+	if err == nil || err == ErrNotFound || err == storage.ErrConflict {
+		return err
+	}
+
+	if err == gosql.ErrNoRows {
+		return ErrNotFound
+	}
+
+	return storage.Error{DriverName: driverName, Enclosed: err}
+}
+
+func withTx(db *gosql.DB, fn func(tx *gosql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+const canaryColumns = `id, ttl, updated_at, title, message, signature, pubkey, pubkey_url, pubkey_fingerprint, verified, revision, warnings, warnings_sent, owner, collaborators, update_token`
+
+type canaryStorage struct {
+	db      *gosql.DB
+	dialect *dialect
+}
+
+func (cs *canaryStorage) scanCanary(row scanner) (*common.Canary, error) {
+	c := &common.Canary{}
+	var warnings, warningsSent, collaborators string
+	err := row.Scan(&c.ID, &c.TimeToLive, &c.UpdatedAt, &c.Title, &c.Message, &c.Signature, &c.PublicKey, &c.PublicKeyUrl, &c.PublicKeyFingerprint, &c.Verified, &c.Revision, &warnings, &warningsSent, &c.Owner, &collaborators, &c.UpdateToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(warnings), &c.Warnings); err != nil {
+		return nil, err
+	} else if err := json.Unmarshal([]byte(warningsSent), &c.WarningsSent); err != nil {
+		return nil, err
+	} else if err := json.Unmarshal([]byte(collaborators), &c.Collaborators); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (cs *canaryStorage) loadLabels(c *common.Canary) error {
+	rows, err := cs.db.Query(cs.dialect.rebind(`SELECT label FROM canary_labels WHERE canary_id = ? ORDER BY position`), c.ID)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+	c.Labels = make([]string, 0)
+	for rows.Next() {
+		var label string
+		if err := rows.Scan(&label); err != nil {
+			return err
+		}
+
+		c.Labels = append(c.Labels, label)
+	}
+
+	return rows.Err()
+}
+
+func (cs *canaryStorage) IsDeleted(ctx context.Context, id string) bool {
+	var found string
+	err := cs.db.QueryRow(cs.dialect.rebind(`SELECT id FROM canary_tombstones WHERE id = ?`), id).Scan(&found)
+	return err == nil
+}
+
+func (cs *canaryStorage) Get(ctx context.Context, id string) (*common.Canary, error) {
+	row := cs.db.QueryRow(cs.dialect.rebind(`SELECT `+canaryColumns+` FROM canaries WHERE id = ?`), id)
+	c, err := cs.scanCanary(row)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	if err := cs.loadLabels(c); err != nil {
+		return nil, wrapError(err)
+	}
+
+	return c, nil
+}
+
+func (cs *canaryStorage) Store(ctx context.Context, c *common.Canary, r *common.CanaryRevision) error {
+	return wrapError(withTx(cs.db, func(tx *gosql.Tx) error {
+		return cs.put(tx, c, r)
+	}))
+}
+
+func (cs *canaryStorage) Replace(ctx context.Context, c *common.Canary, lastUpdateToken string, r *common.CanaryRevision) error {
+	return wrapError(withTx(cs.db, func(tx *gosql.Tx) error {
+		res, err := tx.Exec(cs.dialect.rebind(`UPDATE canaries SET update_token = ? WHERE id = ? AND update_token = ?`), c.UpdateToken, c.ID, lastUpdateToken)
+		if err != nil {
+			return err
+		}
+
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return storage.ErrConflict
+		}
+
+		return cs.put(tx, c, r)
+	}))
+}
+
+func (cs *canaryStorage) put(tx *gosql.Tx, c *common.Canary, r *common.CanaryRevision) error {
+	var expiresAt, nextWarningAt interface{}
+	if !c.IsDead() {
+		expiresAt = c.ExpiresAt()
+	}
+
+	if at := c.NextWarningAt(); at != 0 {
+		nextWarningAt = at
+	}
+
+	warnings, err := json.Marshal(c.Warnings)
+	if err != nil {
+		return err
+	}
+
+	warningsSent, err := json.Marshal(c.WarningsSent)
+	if err != nil {
+		return err
+	}
+
+	collaborators, err := json.Marshal(c.Collaborators)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(cs.dialect.rebind(`INSERT INTO canaries (`+canaryColumns+`, expires_at, next_warning_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			ttl = excluded.ttl,
+			updated_at = excluded.updated_at,
+			title = excluded.title,
+			message = excluded.message,
+			signature = excluded.signature,
+			pubkey = excluded.pubkey,
+			pubkey_url = excluded.pubkey_url,
+			pubkey_fingerprint = excluded.pubkey_fingerprint,
+			verified = excluded.verified,
+			revision = excluded.revision,
+			warnings = excluded.warnings,
+			warnings_sent = excluded.warnings_sent,
+			owner = excluded.owner,
+			collaborators = excluded.collaborators,
+			update_token = excluded.update_token,
+			expires_at = excluded.expires_at,
+			next_warning_at = excluded.next_warning_at`),
+		c.ID, c.TimeToLive, c.UpdatedAt, c.Title, c.Message, c.Signature, c.PublicKey, c.PublicKeyUrl, c.PublicKeyFingerprint, c.Verified, c.Revision,
+		string(warnings), string(warningsSent), c.Owner, string(collaborators), c.UpdateToken, expiresAt, nextWarningAt)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(cs.dialect.rebind(`DELETE FROM canary_labels WHERE canary_id = ?`), c.ID); err != nil {
+		return err
+	}
+
+	for i, label := range c.Labels {
+		key, value := common.SplitLabel(label)
+		if _, err := tx.Exec(cs.dialect.rebind(`INSERT INTO canary_labels (canary_id, position, label, label_key, label_value) VALUES (?, ?, ?, ?, ?)`), c.ID, i, label, key, value); err != nil {
+			return err
+		}
+	}
+
+	if r != nil {
+		return appendRevision(tx, cs.dialect, r)
+	}
+
+	return nil
+}
+
+func (cs *canaryStorage) Delete(ctx context.Context, id string) error {
+	return wrapError(withTx(cs.db, func(tx *gosql.Tx) error {
+		res, err := tx.Exec(cs.dialect.rebind(`DELETE FROM canaries WHERE id = ?`), id)
+		if err != nil {
+			return err
+		}
+
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return err
+		}
+
+		if _, err := tx.Exec(cs.dialect.rebind(`DELETE FROM canary_labels WHERE canary_id = ?`), id); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(cs.dialect.rebind(`INSERT INTO canary_tombstones (id, deleted_at) VALUES (?, ?)`), id, time.Now().Unix())
+		return err
+	}))
+}
+
+func (cs *canaryStorage) query(query string, args ...interface{}) ([]*common.Canary, error) {
+	rows, err := cs.db.Query(cs.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	canaries := make([]*common.Canary, 0)
+	for rows.Next() {
+		c, err := cs.scanCanary(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		canaries = append(canaries, c)
+	}
+
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for start := 0; start < len(canaries); start += labelBatchSize {
+		end := start + labelBatchSize
+		if end > len(canaries) {
+			end = len(canaries)
+		}
+
+		if err := cs.loadLabelBatch(canaries[start:end]); err != nil {
+			return nil, err
+		}
+	}
+
+	return canaries, nil
+}
+
+// labelBatchSize keeps the placeholders of a label query well below the
+// limits of the databases.
+const labelBatchSize = 500
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// loadLabelBatch loads the labels of several canaries with a single query.
+func (cs *canaryStorage) loadLabelBatch(canaries []*common.Canary) error {
+	byID := make(map[string]*common.Canary, len(canaries))
+	args := make([]interface{}, 0, len(canaries))
+	for _, c := range canaries {
+		c.Labels = make([]string, 0)
+		byID[c.ID] = c
+		args = append(args, c.ID)
+	}
+
+	rows, err := cs.db.Query(cs.dialect.rebind(`SELECT canary_id, label FROM canary_labels WHERE canary_id IN (`+placeholders(len(args))+`) ORDER BY canary_id, position`), args...)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var id, label string
+		if err := rows.Scan(&id, &label); err != nil {
+			return err
+		}
+
+		if c, ok := byID[id]; ok {
+			c.Labels = append(c.Labels, label)
+		}
+	}
+
+	return rows.Err()
+}
+
+func (cs *canaryStorage) GetExpired(ctx context.Context, before int64) ([]*common.Canary, error) {
+	expired, err := cs.query(`SELECT `+canaryColumns+` FROM canaries WHERE expires_at < ? ORDER BY expires_at`, before)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return expired, nil
+}
+
+func (cs *canaryStorage) GetWarnable(ctx context.Context, now int64) ([]*common.Canary, error) {
+	warnable, err := cs.query(`SELECT `+canaryColumns+` FROM canaries WHERE next_warning_at <= ? ORDER BY next_warning_at`, now)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return warnable, nil
+}
+
+// canaryFilter translates the states and selector of a query into conditions
+// on canaries. Labels are matched against their keys and values in
+// canary_labels.
+func canaryFilter(q *storage.CanaryQuery, now int64) ([]string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	if len(q.States) > 0 {
+		var states []string
+		for _, state := range q.States {
+			switch state {
+			case common.StateDead:
+				states = append(states, `ttl < 0`)
+
+			case common.StateZombie:
+				states = append(states, `(ttl >= 0 AND updated_at + ttl < ?)`)
+				args = append(args, now)
+
+			case common.StateAlive:
+				states = append(states, `(ttl >= 0 AND updated_at + ttl >= ?)`)
+				args = append(args, now)
+			}
+		}
+
+		if len(states) == 0 {
+			states = append(states, `1 = 0`)
+		}
+
+		conds = append(conds, `(`+strings.Join(states, ` OR `)+`)`)
+	}
+
+	for _, r := range q.Selector {
+		cond := `EXISTS (SELECT 1 FROM canary_labels l WHERE l.canary_id = canaries.id AND l.label_key = ?`
+		args = append(args, r.Key)
+		if len(r.Values) > 0 {
+			cond += ` AND l.label_value IN (` + placeholders(len(r.Values)) + `)`
+			for _, v := range r.Values {
+				args = append(args, v)
+			}
+		}
+
+		cond += `)`
+		switch r.Operator {
+		case common.SelectorNotExists, common.SelectorNotEquals, common.SelectorNotIn:
+			cond = `NOT ` + cond
+		}
+
+		conds = append(conds, cond)
+	}
+
+	return conds, args
+}
+
+func (cs *canaryStorage) List(ctx context.Context, q *storage.CanaryQuery) (*storage.CanaryPage, error) {
+	conds, args := canaryFilter(q, time.Now().Unix())
+	sortColumn := `updated_at`
+	if q.SortBy == storage.SortExpiresAt {
+		sortColumn = `updated_at + ttl`
+	}
+
+	order, after := `ASC`, `>`
+	if q.Descending {
+		order, after = `DESC`, `<`
+	}
+
+	if q.Cursor != "" {
+		value, id, err := storage.DecodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		conds = append(conds, fmt.Sprintf(`(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))`, sortColumn, after))
+		args = append(args, value, value, id)
+	}
+
+	query := `SELECT ` + canaryColumns + ` FROM canaries`
+	if len(conds) > 0 {
+		query += ` WHERE ` + strings.Join(conds, ` AND `)
+	}
+
+	// one more than the page tells whether there is a next one.
+	limit := storage.PageLimit(q.Limit)
+	query += fmt.Sprintf(` ORDER BY %[1]s %[2]s, id %[2]s LIMIT ?`, sortColumn, order)
+	canaries, err := cs.query(query, append(args, limit+1)...)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	page := &storage.CanaryPage{Canaries: canaries}
+	if len(canaries) > limit {
+		last := canaries[limit-1]
+		page.Canaries = canaries[:limit]
+		page.NextCursor = storage.EncodeCursor(q.SortValue(last), last.ID)
+	}
+
+	return page, nil
+}
+
+const hookColumns = `id, canary_id, name, content_type, secret, secret_fingerprint, previous_secret, previous_secret_expires_at, signature_algorithm, insecure_ssl, url, events, active, consecutive_failures, last_error, disabled_at, type, options, template, update_token, updated_at`
+
+type hookStorage struct {
+	db      *gosql.DB
+	dialect *dialect
+}
+
+func (hs *hookStorage) scanHook(row scanner) (*common.WebHook, error) {
+	wh := &common.WebHook{}
+	var events, options string
+	err := row.Scan(&wh.ID, &wh.CanaryID, &wh.Name, &wh.ContentType, &wh.Secret, &wh.SecretFingerprint, &wh.PreviousSecret, &wh.PreviousSecretExpiresAt, &wh.SignatureAlgorithm, &wh.InsecureSSL, &wh.Url, &events, &wh.Active,
+		&wh.ConsecutiveFailures, &wh.LastError, &wh.DisabledAt, &wh.Type, &options, &wh.Template, &wh.UpdateToken, &wh.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(events), &wh.Events); err != nil {
+		return nil, err
+	}
+
+	if options != "" {
+		if err := json.Unmarshal([]byte(options), &wh.Options); err != nil {
+			return nil, err
+		}
+	}
+
+	return wh, nil
+}
+
+func (hs *hookStorage) Get(ctx context.Context, id string) (*common.WebHook, error) {
+	wh, err := hs.scanHook(hs.db.QueryRow(hs.dialect.rebind(`SELECT `+hookColumns+` FROM hooks WHERE id = ?`), id))
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return wh, nil
+}
+
+func (hs *hookStorage) Store(ctx context.Context, wh *common.WebHook) error {
+	events := wh.Events
+	if events == nil {
+		events = []string{}
+	}
+
+	encodedEvents, err := json.Marshal(events)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	var encodedOptions []byte
+	if len(wh.Options) > 0 {
+		if encodedOptions, err = json.Marshal(wh.Options); err != nil {
+			return wrapError(err)
+		}
+	}
+
+	_, err = hs.db.Exec(hs.dialect.rebind(`INSERT INTO hooks (`+hookColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			canary_id = excluded.canary_id,
+			name = excluded.name,
+			content_type = excluded.content_type,
+			secret = excluded.secret,
+			secret_fingerprint = excluded.secret_fingerprint,
+			previous_secret = excluded.previous_secret,
+			previous_secret_expires_at = excluded.previous_secret_expires_at,
+			signature_algorithm = excluded.signature_algorithm,
+			insecure_ssl = excluded.insecure_ssl,
+			url = excluded.url,
+			events = excluded.events,
+			active = excluded.active,
+			consecutive_failures = excluded.consecutive_failures,
+			last_error = excluded.last_error,
+			disabled_at = excluded.disabled_at,
+			type = excluded.type,
+			options = excluded.options,
+			template = excluded.template,
+			update_token = excluded.update_token,
+			updated_at = excluded.updated_at`),
+		wh.ID, wh.CanaryID, wh.Name, wh.ContentType, wh.Secret, wh.SecretFingerprint, wh.PreviousSecret, wh.PreviousSecretExpiresAt,
+		wh.SignatureAlgorithm, wh.InsecureSSL, wh.Url, string(encodedEvents), wh.Active, wh.ConsecutiveFailures, wh.LastError, wh.DisabledAt, wh.Type, string(encodedOptions), wh.Template, wh.UpdateToken, wh.UpdatedAt)
+
+	return wrapError(err)
+}
+
+func (hs *hookStorage) Delete(ctx context.Context, id string) error {
+	_, err := hs.db.Exec(hs.dialect.rebind(`DELETE FROM hooks WHERE id = ?`), id)
+	return wrapError(err)
+}
+
+func (hs *hookStorage) GetForCanary(ctx context.Context, canaryID string) ([]*common.WebHook, error) {
+	rows, err := hs.db.Query(hs.dialect.rebind(`SELECT `+hookColumns+` FROM hooks WHERE canary_id = ? ORDER BY id`), canaryID)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	defer rows.Close()
+	hooks := make([]*common.WebHook, 0)
+	for rows.Next() {
+		wh, err := hs.scanHook(rows)
+		if err != nil {
+			return nil, wrapError(err)
+		}
+
+		hooks = append(hooks, wh)
+	}
+
+	return hooks, wrapError(rows.Err())
+}
+
+func (hs *hookStorage) List(ctx context.Context, q *storage.HookQuery) (*storage.HookPage, error) {
+	hooks, err := hs.GetForCanary(ctx, q.CanaryID)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.PaginateHooks(hooks, q)
+}
+
+func (hs *hookStorage) DeleteForCanary(ctx context.Context, canaryID string) ([]string, error) {
+	ids := make([]string, 0)
+	err := withTx(hs.db, func(tx *gosql.Tx) error {
+		rows, err := tx.Query(hs.dialect.rebind(`SELECT id FROM hooks WHERE canary_id = ?`), canaryID)
+		if err != nil {
+			return err
+		}
+
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+
+			ids = append(ids, id)
+		}
+
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(hs.dialect.rebind(`DELETE FROM hooks WHERE canary_id = ?`), canaryID)
+		return err
+	})
+
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return ids, nil
+}
+
+type deliveryStorage struct {
+	db        *gosql.DB
+	dialect   *dialect
+	retention storage.DeliveryRetention
+}
+
+func decodeDelivery(payload []byte) (*common.Delivery, error) {
+	d := &common.Delivery{}
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(d); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func (ds *deliveryStorage) Get(ctx context.Context, id string) (*common.Delivery, error) {
+	var payload []byte
+	if err := ds.db.QueryRow(ds.dialect.rebind(`SELECT payload FROM deliveries WHERE id = ?`), id).Scan(&payload); err != nil {
+		return nil, wrapError(err)
+	}
+
+	d, err := decodeDelivery(payload)
+	return d, wrapError(err)
+}
+
+func (ds *deliveryStorage) Store(ctx context.Context, d *common.Delivery) error {
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(d); err != nil {
+		return wrapError(err)
+	}
+
+	_, err := ds.db.Exec(ds.dialect.rebind(`INSERT INTO deliveries (id, hook_id, canary_id, event, status, attempts, created_at, next_attempt_at, payload)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			status = excluded.status,
+			attempts = excluded.attempts,
+			next_attempt_at = excluded.next_attempt_at,
+			payload = excluded.payload`),
+		d.ID, d.HookID, d.CanaryID, d.Event, d.Status, d.Attempts, d.CreatedAt, d.NextAttemptAt, payload.Bytes())
+
+	return wrapError(err)
+}
+
+func (ds *deliveryStorage) Delete(ctx context.Context, id string) error {
+	_, err := ds.db.Exec(ds.dialect.rebind(`DELETE FROM deliveries WHERE id = ?`), id)
+	return wrapError(err)
+}
+
+func (ds *deliveryStorage) GetPending(ctx context.Context) ([]*common.Delivery, error) {
+	rows, err := ds.db.Query(ds.dialect.rebind(`SELECT payload FROM deliveries WHERE status = ? ORDER BY next_attempt_at`), common.DeliveryPending)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	defer rows.Close()
+	pending := make([]*common.Delivery, 0)
+	for rows.Next() {
+		var payload []byte
+		if err := rows.Scan(&payload); err != nil {
+			return nil, wrapError(err)
+		}
+
+		d, err := decodeDelivery(payload)
+		if err != nil {
+			return nil, wrapError(err)
+		}
+
+		pending = append(pending, d)
+	}
+
+	return pending, wrapError(rows.Err())
+}
+
+func (ds *deliveryStorage) List(ctx context.Context, q *storage.DeliveryQuery) (*storage.DeliveryPage, error) {
+	query := `SELECT payload FROM deliveries WHERE hook_id = ?`
+	args := []interface{}{q.HookID}
+	if q.Cursor != "" {
+		createdAt, id, err := storage.DecodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		query += ` AND (created_at < ? OR (created_at = ? AND id < ?))`
+		args = append(args, createdAt, createdAt, id)
+	}
+
+	limit := storage.PageLimit(q.Limit)
+	query += ` ORDER BY created_at DESC, id DESC LIMIT ?`
+	rows, err := ds.db.Query(ds.dialect.rebind(query), append(args, limit+1)...)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	defer rows.Close()
+	deliveries := make([]*common.Delivery, 0)
+	for rows.Next() {
+		var payload []byte
+		if err := rows.Scan(&payload); err != nil {
+			return nil, wrapError(err)
+		}
+
+		d, err := decodeDelivery(payload)
+		if err != nil {
+			return nil, wrapError(err)
+		}
+
+		deliveries = append(deliveries, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+
+	page := &storage.DeliveryPage{Deliveries: deliveries}
+	if len(deliveries) > limit {
+		last := deliveries[limit-1]
+		page.Deliveries = deliveries[:limit]
+		page.NextCursor = storage.EncodeCursor(last.CreatedAt, last.ID)
+	}
+
+	return page, nil
+}
+
+// Prune only needs the columns that retention is decided on, the payloads are
+// never decoded.
+func (ds *deliveryStorage) Prune(ctx context.Context, now int64) (int, error) {
+	removed := 0
+	err := withTx(ds.db, func(tx *gosql.Tx) error {
+		rows, err := tx.Query(`SELECT id, hook_id, status, created_at FROM deliveries`)
+		if err != nil {
+			return err
+		}
+
+		deliveries := make([]*common.Delivery, 0)
+		for rows.Next() {
+			d := &common.Delivery{}
+			if err := rows.Scan(&d.ID, &d.HookID, &d.Status, &d.CreatedAt); err != nil {
+				rows.Close()
+				return err
+			}
+
+			deliveries = append(deliveries, d)
+		}
+
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, id := range ds.retention.Expired(deliveries, now) {
+			if _, err := tx.Exec(ds.dialect.rebind(`DELETE FROM deliveries WHERE id = ?`), id); err != nil {
+				return err
+			}
+
+			removed++
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, wrapError(err)
+	}
+
+	return removed, nil
+}
+
+const revisionColumns = `id, canary_id, sequence, action, revision, recorded_at, ttl, actor, remote_addr, member, content_hash, previous_token_hash, title, message, labels, updated_at, signature, previous_hash, hash`
+
+type revisionStorage struct {
+	db      *gosql.DB
+	dialect *dialect
+}
+
+func (rs *revisionStorage) Append(ctx context.Context, r *common.CanaryRevision) error {
+	return wrapError(withTx(rs.db, func(tx *gosql.Tx) error {
+		return appendRevision(tx, rs.dialect, r)
+	}))
+}
+
+func appendRevision(tx *gosql.Tx, d *dialect, r *common.CanaryRevision) error {
+	var prevSequence int64
+	var prevHash string
+	row := tx.QueryRow(d.rebind(`SELECT sequence, hash FROM canary_revisions WHERE canary_id = ? ORDER BY sequence DESC LIMIT 1`), r.CanaryID)
+	if err := row.Scan(&prevSequence, &prevHash); err != nil && err != gosql.ErrNoRows {
+		return err
+	}
+
+	labels, err := json.Marshal(r.Labels)
+	if err != nil {
+		return err
+	}
+
+	r.Chain(prevSequence, prevHash)
+	_, err = tx.Exec(d.rebind(`INSERT INTO canary_revisions (`+revisionColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		r.ID, r.CanaryID, r.Sequence, r.Action, r.Revision, r.Timestamp, r.TimeToLive, r.Actor, r.RemoteAddr, r.Member, r.ContentHash, r.PreviousTokenHash,
+		r.Title, r.Message, string(labels), r.UpdatedAt, r.Signature, r.PreviousHash, r.Hash)
+
+	return err
+}
+
+func (rs *revisionStorage) List(ctx context.Context, q *storage.RevisionQuery) (*storage.RevisionPage, error) {
+	rows, err := rs.db.Query(rs.dialect.rebind(`SELECT `+revisionColumns+` FROM canary_revisions WHERE canary_id = ? ORDER BY sequence`), q.CanaryID)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	defer rows.Close()
+	revisions := make([]*common.CanaryRevision, 0)
+	for rows.Next() {
+		r := &common.CanaryRevision{}
+		var labels string
+		err := rows.Scan(&r.ID, &r.CanaryID, &r.Sequence, &r.Action, &r.Revision, &r.Timestamp, &r.TimeToLive, &r.Actor, &r.RemoteAddr, &r.Member, &r.ContentHash, &r.PreviousTokenHash,
+			&r.Title, &r.Message, &labels, &r.UpdatedAt, &r.Signature, &r.PreviousHash, &r.Hash)
+
+		if err != nil {
+			return nil, wrapError(err)
+		}
+
+		if err := json.Unmarshal([]byte(labels), &r.Labels); err != nil {
+			return nil, wrapError(err)
+		}
+
+		revisions = append(revisions, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+
+	return storage.PaginateRevisions(revisions, q)
+}
@@ -0,0 +1,487 @@
+package sql
+
+import (
+	gosql "database/sql"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/danielkrainas/canaria-api/common"
+	"github.com/danielkrainas/canaria-api/context"
+	"github.com/danielkrainas/canaria-api/storage"
+)
+
+func newTestDriver(t *testing.T) *driver {
+	d, err := New("sqlite3", filepath.Join(t.TempDir(), "canaria.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		d.db.Close()
+	})
+
+	return d
+}
+
+func newTestCanary(id string, labels ...string) *common.Canary {
+	return &common.Canary{
+		ID:            id,
+		TimeToLive:    600,
+		UpdatedAt:     time.Now().Unix(),
+		Title:         "canary " + id,
+		Labels:        labels,
+		Warnings:      []int64{60},
+		Owner:         "alice",
+		Collaborators: []string{"bob"},
+		UpdateToken:   id + "-token",
+	}
+}
+
+func TestCanaryRoundTrip(t *testing.T) {
+	d := newTestDriver(t)
+	ctx := context.Background()
+
+	c := newTestCanary("a", "env=prod", "team=core")
+	if err := d.Canaries().Store(ctx, c, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := d.Canaries().Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(got, c) {
+		t.Fatalf("stored canary differs:\n got  %+v\n want %+v", got, c)
+	}
+
+	if _, err := d.Canaries().Get(ctx, "missing"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound for a missing canary, got %v", err)
+	}
+}
+
+func TestCanaryReplace(t *testing.T) {
+	d := newTestDriver(t)
+	ctx := context.Background()
+
+	c := newTestCanary("a", "env=prod")
+	if err := d.Canaries().Store(ctx, c, common.NewCanaryRevision(c, common.RevisionCreated, "")); err != nil {
+		t.Fatal(err)
+	}
+
+	next := *c
+	next.Title = "changed"
+	next.Labels = []string{"env=dev"}
+	next.UpdateToken = "next-token"
+	r := common.NewCanaryRevision(&next, common.RevisionUpdated, c.UpdateToken)
+	if err := d.Canaries().Replace(ctx, &next, "wrong-token", r); err != storage.ErrConflict {
+		t.Fatalf("expected ErrConflict for a stale token, got %v", err)
+	} else if n := countRevisions(t, d, "a"); n != 1 {
+		t.Fatalf("a conflicting replace recorded a revision, %d revisions", n)
+	}
+
+	if err := d.Canaries().Replace(ctx, &next, c.UpdateToken, r); err != nil {
+		t.Fatal(err)
+	} else if n := countRevisions(t, d, "a"); n != 2 {
+		t.Fatalf("expected 2 revisions after replace, got %d", n)
+	}
+
+	got, err := d.Canaries().Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	} else if got.Title != "changed" || got.UpdateToken != "next-token" || !reflect.DeepEqual(got.Labels, []string{"env=dev"}) {
+		t.Fatalf("replace was not stored: %+v", got)
+	}
+}
+
+func countRevisions(t *testing.T, d *driver, canaryID string) int {
+	page, err := d.Revisions().List(context.Background(), &storage.RevisionQuery{CanaryID: canaryID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return len(page.Revisions)
+}
+
+func TestCanaryDelete(t *testing.T) {
+	d := newTestDriver(t)
+	ctx := context.Background()
+
+	if err := d.Canaries().Store(ctx, newTestCanary("a"), nil); err != nil {
+		t.Fatal(err)
+	} else if d.Canaries().IsDeleted(ctx, "a") {
+		t.Fatal("live canary reported as deleted")
+	}
+
+	if err := d.Canaries().Delete(ctx, "a"); err != nil {
+		t.Fatal(err)
+	} else if _, err := d.Canaries().Get(ctx, "a"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound after delete, got %v", err)
+	} else if !d.Canaries().IsDeleted(ctx, "a") {
+		t.Fatal("deleted canary has no tombstone")
+	}
+}
+
+func TestCanaryList(t *testing.T) {
+	d := newTestDriver(t)
+	ctx := context.Background()
+
+	now := time.Now().Unix()
+	for i := 0; i < 5; i++ {
+		env := "env=prod"
+		if i%2 == 1 {
+			env = "env=dev"
+		}
+
+		c := newTestCanary(fmt.Sprintf("c%d", i), env)
+		c.UpdatedAt = now + int64(i)
+		if i == 4 {
+			c.Kill()
+		}
+
+		if err := d.Canaries().Store(ctx, c, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	selector, err := common.ParseSelector("env=prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := &storage.CanaryQuery{Selector: selector, States: []string{common.StateAlive}, Limit: 1}
+	var ids []string
+	for {
+		page, err := d.Canaries().List(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, c := range page.Canaries {
+			ids = append(ids, c.ID)
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+
+		q.Cursor = page.NextCursor
+	}
+
+	if want := []string{"c0", "c2"}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("expected %v, got %v", want, ids)
+	}
+
+	page, err := d.Canaries().List(ctx, &storage.CanaryQuery{States: []string{common.StateDead}})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(page.Canaries) != 1 || page.Canaries[0].ID != "c4" {
+		t.Fatalf("expected only the dead canary, got %+v", page.Canaries)
+	}
+}
+
+// listAll follows the cursors of q to the last page.
+func listAll(t *testing.T, d *driver, q storage.CanaryQuery) []string {
+	ids := make([]string, 0)
+	for {
+		page, err := d.Canaries().List(context.Background(), &q)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, c := range page.Canaries {
+			ids = append(ids, c.ID)
+		}
+
+		if page.NextCursor == "" {
+			return ids
+		}
+
+		q.Cursor = page.NextCursor
+	}
+}
+
+func TestCanaryListMatchesPaginate(t *testing.T) {
+	d := newTestDriver(t)
+	ctx := context.Background()
+
+	now := time.Now().Unix()
+	labels := [][]string{
+		{"env=prod", "team=a"},
+		{"env=dev"},
+		{"env=prod", "beta"},
+		{},
+		{"env=staging", "team=b"},
+		{"team=a", "beta"},
+	}
+
+	var all []*common.Canary
+	for i := 0; i < 12; i++ {
+		c := newTestCanary(fmt.Sprintf("c%02d", i), labels[i%len(labels)]...)
+		c.UpdatedAt = now - int64(i%4)*100
+		c.TimeToLive = int64(150 + i%3*100)
+		if i%5 == 4 {
+			c.Kill()
+		}
+
+		if err := d.Canaries().Store(ctx, c, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		all = append(all, c)
+	}
+
+	selectors := []string{"", "env=prod", "env!=prod", "beta", "!team", "env in (dev, staging)", "env notin (prod), team"}
+	states := [][]string{nil, {common.StateAlive}, {common.StateZombie, common.StateDead}}
+	for _, sel := range selectors {
+		selector, err := common.ParseSelector(sel)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, st := range states {
+			for _, sortBy := range []string{storage.SortUpdatedAt, storage.SortExpiresAt} {
+				for _, desc := range []bool{false, true} {
+					q := storage.CanaryQuery{Selector: selector, States: st, SortBy: sortBy, Descending: desc, Limit: 2}
+					want := make([]string, 0)
+					expected, err := storage.Paginate(all, &storage.CanaryQuery{Selector: selector, States: st, SortBy: sortBy, Descending: desc, Limit: storage.MaxPageSize})
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					for _, c := range expected.Canaries {
+						want = append(want, c.ID)
+					}
+
+					if got := listAll(t, d, q); !reflect.DeepEqual(got, want) {
+						t.Fatalf("selector %q, states %v, sort %s, descending %v: expected %v, got %v", sel, st, sortBy, desc, want, got)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestCanaryExpiredAndWarnable(t *testing.T) {
+	d := newTestDriver(t)
+	ctx := context.Background()
+
+	now := time.Now().Unix()
+	expired := newTestCanary("expired")
+	expired.UpdatedAt = now - 1000
+	warnable := newTestCanary("warnable")
+	warnable.UpdatedAt = now - 580
+	for _, c := range []*common.Canary{expired, warnable, newTestCanary("fresh")} {
+		if err := d.Canaries().Store(ctx, c, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := d.Canaries().GetExpired(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(got) != 1 || got[0].ID != "expired" {
+		t.Fatalf("expected only the expired canary, got %+v", got)
+	}
+
+	got, err = d.Canaries().GetWarnable(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make(map[string]bool)
+	for _, c := range got {
+		ids[c.ID] = true
+	}
+
+	if !ids["warnable"] || ids["fresh"] {
+		t.Fatalf("expected the warnable canary and not the fresh one, got %v", ids)
+	}
+}
+
+func TestRevisionChain(t *testing.T) {
+	d := newTestDriver(t)
+	ctx := context.Background()
+
+	c := newTestCanary("a")
+	for _, action := range []string{common.RevisionCreated, common.RevisionRefreshed, common.RevisionUpdated} {
+		r := common.NewCanaryRevision(c, action, c.UpdateToken)
+		if err := d.Revisions().Append(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	page, err := d.Revisions().List(ctx, &storage.RevisionQuery{CanaryID: "a"})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(page.Revisions) != 3 {
+		t.Fatalf("expected 3 revisions, got %d", len(page.Revisions))
+	}
+
+	prevHash := ""
+	for i, r := range page.Revisions {
+		if r.Sequence != int64(i+1) {
+			t.Fatalf("revision %d has sequence %d", i, r.Sequence)
+		} else if r.PreviousHash != prevHash {
+			t.Fatalf("revision %d does not link to the one before it", r.Sequence)
+		} else if r.Hash != r.ChainEntry().ComputeHash() {
+			t.Fatalf("revision %d has a wrong hash", r.Sequence)
+		}
+
+		prevHash = r.Hash
+	}
+
+	page, err = d.Revisions().List(ctx, &storage.RevisionQuery{CanaryID: "a", Descending: true, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(page.Revisions) != 2 || page.Revisions[0].Sequence != 3 || page.NextCursor == "" {
+		t.Fatalf("unexpected descending page: %+v", page)
+	}
+}
+
+func TestHooksAndDeliveries(t *testing.T) {
+	d := newTestDriver(t)
+	ctx := context.Background()
+
+	hooks := []*common.WebHook{
+		{ID: "h1", CanaryID: "a", Url: "http://example.com/1", Events: []string{common.EventDead}, Active: true},
+		{ID: "h2", CanaryID: "a", Url: "http://example.com/2", Events: []string{common.EventDead}, Active: true},
+		{ID: "h3", CanaryID: "b", Url: "http://example.com/3", Events: []string{common.EventDead}, Active: true},
+	}
+
+	for _, wh := range hooks {
+		if err := d.Hooks().Store(ctx, wh); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := d.Hooks().GetForCanary(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(got) != 2 {
+		t.Fatalf("expected 2 hooks for canary a, got %d", len(got))
+	}
+
+	c := newTestCanary("a")
+	for i := 0; i < 3; i++ {
+		dl := common.NewDelivery(hooks[0], c, common.EventDead)
+		dl.CreatedAt += int64(i)
+		if err := d.Deliveries().Store(ctx, dl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := d.Deliveries().Store(ctx, common.NewDelivery(hooks[1], c, common.EventDead)); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := d.Deliveries().List(ctx, &storage.DeliveryQuery{HookID: "h1"})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(page.Deliveries) != 3 {
+		t.Fatalf("expected 3 deliveries for h1, got %d", len(page.Deliveries))
+	} else if page.Deliveries[0].CreatedAt < page.Deliveries[2].CreatedAt {
+		t.Fatal("deliveries are not listed newest first")
+	}
+
+	first, err := d.Deliveries().List(ctx, &storage.DeliveryQuery{HookID: "h1", Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(first.Deliveries) != 2 || first.NextCursor == "" {
+		t.Fatalf("expected a first page of 2 with a cursor, got %d %q", len(first.Deliveries), first.NextCursor)
+	}
+
+	rest, err := d.Deliveries().List(ctx, &storage.DeliveryQuery{HookID: "h1", Limit: 2, Cursor: first.NextCursor})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(rest.Deliveries) != 1 || rest.NextCursor != "" || rest.Deliveries[0].ID != page.Deliveries[2].ID {
+		t.Fatalf("unexpected last page %+v", rest)
+	}
+
+	pending, err := d.Deliveries().GetPending(ctx)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(pending) != 4 {
+		t.Fatalf("expected 4 pending deliveries, got %d", len(pending))
+	}
+
+	deleted, err := d.Hooks().DeleteForCanary(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(deleted) != 2 {
+		t.Fatalf("expected 2 deleted hooks, got %v", deleted)
+	}
+
+	if _, err := d.Hooks().Get(ctx, "h3"); err != nil {
+		t.Fatalf("hook of another canary was deleted: %v", err)
+	}
+}
+
+func TestHashPreChainRevisions(t *testing.T) {
+	d := newTestDriver(t)
+	ctx := context.Background()
+
+	c := newTestCanary("a")
+	for _, action := range []string{common.RevisionCreated, common.RevisionRefreshed} {
+		if err := d.Revisions().Append(ctx, common.NewCanaryRevision(c, action, c.UpdateToken)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// revisions recorded before migration 6 have neither hash nor link.
+	if _, err := d.db.Exec(`UPDATE canary_revisions SET hash = '', previous_hash = ''`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := withTx(d.db, func(tx *gosql.Tx) error {
+		return hashPreChainRevisions(tx, dialects["sqlite3"])
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := d.Revisions().List(ctx, &storage.RevisionQuery{CanaryID: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := make([]*common.ChainEntry, len(page.Revisions))
+	for i, r := range page.Revisions {
+		entries[i] = r.ChainEntry()
+	}
+
+	if err := common.VerifyChain("a", entries, entries[len(entries)-1].Hash); err != nil {
+		t.Fatalf("pre-chain revisions were not chained: %v", err)
+	}
+}
+
+func TestSplitLabels(t *testing.T) {
+	d := newTestDriver(t)
+	ctx := context.Background()
+
+	if err := d.Canaries().Store(ctx, newTestCanary("a", "env=prod", "beta"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// labels stored before migration 14 have neither key nor value.
+	if _, err := d.db.Exec(`UPDATE canary_labels SET label_key = '', label_value = ''`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := withTx(d.db, func(tx *gosql.Tx) error {
+		return splitLabels(tx, dialects["sqlite3"])
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sel := range []string{"env=prod", "beta"} {
+		selector, err := common.ParseSelector(sel)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ids := listAll(t, d, storage.CanaryQuery{Selector: selector}); len(ids) != 1 {
+			t.Fatalf("selector %q: expected the canary, got %v", sel, ids)
+		}
+	}
+}
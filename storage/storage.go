@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/danielkrainas/canaria-api/common"
@@ -10,13 +11,23 @@ import (
 type StorageDriver interface {
 	Canaries() CanaryStorage
 	Hooks() HookStorage
+	Deliveries() DeliveryStorage
+	Revisions() RevisionStorage
 }
 
+// CanaryStorage stores canaries. Store and Replace append r, when it isn't
+// nil, to the canary's revisions in the same transaction, so that a canary
+// never changes without its history and the history never records a change
+// that didn't happen.
 type CanaryStorage interface {
 	IsDeleted(ctx context.Context, id string) bool
 	Get(ctx context.Context, id string) (*common.Canary, error)
-	Store(ctx context.Context, c *common.Canary) error
+	Store(ctx context.Context, c *common.Canary, r *common.CanaryRevision) error
+	Replace(ctx context.Context, c *common.Canary, lastUpdateToken string, r *common.CanaryRevision) error
 	Delete(ctx context.Context, id string) error
+	GetExpired(ctx context.Context, before int64) ([]*common.Canary, error)
+	GetWarnable(ctx context.Context, now int64) ([]*common.Canary, error)
+	List(ctx context.Context, q *CanaryQuery) (*CanaryPage, error)
 }
 
 type HookStorage interface {
@@ -25,6 +36,25 @@ type HookStorage interface {
 	Delete(ctx context.Context, id string) error
 	GetForCanary(ctx context.Context, canaryID string) ([]*common.WebHook, error)
 	DeleteForCanary(ctx context.Context, canaryID string) ([]string, error)
+	List(ctx context.Context, q *HookQuery) (*HookPage, error)
+}
+
+type DeliveryStorage interface {
+	Get(ctx context.Context, id string) (*common.Delivery, error)
+	Store(ctx context.Context, d *common.Delivery) error
+	Delete(ctx context.Context, id string) error
+	GetPending(ctx context.Context) ([]*common.Delivery, error)
+	List(ctx context.Context, q *DeliveryQuery) (*DeliveryPage, error)
+	Prune(ctx context.Context, now int64) (int, error)
+}
+
+// ErrConflict is returned by Replace when the stored canary no longer has the
+// expected update token.
+var ErrConflict = errors.New("canary was modified concurrently")
+
+type RevisionStorage interface {
+	Append(ctx context.Context, r *common.CanaryRevision) error
+	List(ctx context.Context, q *RevisionQuery) (*RevisionPage, error)
 }
 
 type Error struct {